@@ -9,11 +9,17 @@ import (
 var Config config
 
 type config struct {
-	Server server `yaml:"server"`
-	Log    Log    `yaml:"log"`
-	Redis  redis  `yaml:"redis"`
-	Argo   argo   `yaml:"argo"`
-	Argocd argocd `yaml:"argocd"`
+	Server    server    `yaml:"server"`
+	Log       Log       `yaml:"log"`
+	Redis     redis     `yaml:"redis"`
+	Argo      argo      `yaml:"argo"`
+	Argocd    argocd    `yaml:"argocd"`
+	Scheduler scheduler `yaml:"scheduler"`
+	Webhook   webhook   `yaml:"webhook"`
+	Queue     queue     `yaml:"queue"`
+	Logs      logs      `yaml:"logs"`
+	Notify    notify    `yaml:"notify"`
+	Metrics   Metrics   `yaml:"metrics"`
 }
 
 type server struct {
@@ -31,6 +37,7 @@ type redis struct {
 	Address  string `yaml:"address"`
 	Db       int    `yaml:"db"`
 	Password string `yaml:"password"`
+	PoolSize int    `yaml:"poolSize"` // 连接池大小，默认每个CPU 10个；每个任务事件(SSE)的订阅者在等待时占用一个连接
 }
 type argo struct {
 	Address            string `yaml:"address"`
@@ -51,6 +58,103 @@ type argocd struct {
 	Revision  string `json:"revision"`
 }
 
+type scheduler struct {
+	ReconcileInterval time.Duration `yaml:"reconcileInterval"` // 任务状态修复的间隔，0表示不启动
+	CronInterval      time.Duration `yaml:"cronInterval"`      // 检查定时任务的间隔，0表示不启动
+	Clean             Clean         `yaml:"clean"`
+}
+
+// 任务保留策略
+type Clean struct {
+	Interval time.Duration `yaml:"interval"` // 清理间隔，启动时先清理一次，0表示不启动
+	KeepJobs int           `yaml:"keepJobs"` // 每个应用保留最近的任务数
+	KeepDays int           `yaml:"keepDays"` // 保留最近天数内的任务
+	DryRun   bool          `yaml:"dryRun"`   // 只记录将要清理的任务，不做删除
+}
+
+// 接收代码仓库webhook的密钥，未配置的平台会拒绝请求
+type webhook struct {
+	GithubSecret string `yaml:"githubSecret"`
+	GitlabToken  string `yaml:"gitlabToken"`
+	GiteeSecret  string `yaml:"giteeSecret"`
+}
+
+// 任务并发控制，数量为0表示不限制
+type queue struct {
+	MaxGlobal        int            `yaml:"maxGlobal"`        // 全局运行中的任务数
+	MaxPerApp        int            `yaml:"maxPerApp"`        // 每个应用运行中的任务数，等待审批的任务不计入
+	AppLimits        map[string]int `yaml:"appLimits"`        // 单独设置应用的任务数
+	Policy           string         `yaml:"policy"`           // 超出时的默认策略: queue, reject, cancel
+	DispatchInterval time.Duration  `yaml:"dispatchInterval"` // 定时检查排队任务的间隔
+}
+
+// 日志采集
+type logs struct {
+	Containers    []string            `yaml:"containers"`    // 采集日志的容器，默认只采集main
+	AppContainers map[string][]string `yaml:"appContainers"` // 按应用配置采集的容器，如 init, wait, dind
+	Redact        Redact              `yaml:"redact"`
+	Archive       Archive             `yaml:"archive"`
+}
+
+// 日志保存前的脱敏
+type Redact struct {
+	Secrets         bool     `yaml:"secrets"`         // 隐藏workflow引用的k8s secret的值
+	SensitiveParams string   `yaml:"sensitiveParams"` // 敏感参数名的正则，隐藏这些参数的值
+	Patterns        []string `yaml:"patterns"`        // 隐藏匹配的内容，有分组时只隐藏分组
+}
+
+// 日志归档: 任务结束后把日志压缩保存到本地目录或S3，并删除redis中的日志
+type Archive struct {
+	Enabled      bool                     `yaml:"enabled"`
+	Type         string                   `yaml:"type"`         // local, s3
+	Dir          string                   `yaml:"dir"`          // 本地目录
+	S3           S3                       `yaml:"s3"`           // S3兼容的存储，如 MinIO
+	Retention    time.Duration            `yaml:"retention"`    // 归档的保留时间，默认100天
+	AppRetention map[string]time.Duration `yaml:"appRetention"` // 按应用配置保留时间
+	Interval     time.Duration            `yaml:"interval"`     // 清理过期归档、重试失败归档的间隔，0表示不启动
+}
+
+type S3 struct {
+	Endpoint  string `yaml:"endpoint"` // 如 http://127.0.0.1:9000，使用path-style访问
+	Region    string `yaml:"region"`
+	Bucket    string `yaml:"bucket"`
+	Prefix    string `yaml:"prefix"`
+	AccessKey string `yaml:"accessKey"`
+	SecretKey string `yaml:"secretKey"`
+}
+
+// 任务状态变化的通知，通知目标按应用保存在redis中
+type notify struct {
+	Timeout time.Duration `yaml:"timeout"` // 每次发送的超时时间
+	Retries int           `yaml:"retries"` // 失败后的重试次数
+	Backoff time.Duration `yaml:"backoff"` // 第一次重试的等待时间，之后每次翻倍
+	JobUrl  string        `yaml:"jobUrl"`  // 通知中任务详情的链接，%s替换为任务Id
+	LogsUrl string        `yaml:"logsUrl"` // 通知中任务日志的链接，%s替换为任务Id
+	Smtp    Smtp          `yaml:"smtp"`
+}
+
+// 邮件通知的SMTP服务器，host为空时不发送邮件
+type Smtp struct {
+	Host     string `yaml:"host"`
+	Port     int    `yaml:"port"`
+	Username string `yaml:"username"`
+	Password string `yaml:"password"`
+	From     string `yaml:"from"`
+	Tls      bool   `yaml:"tls"` // 直接使用TLS连接(465端口)，否则服务器支持时使用STARTTLS
+}
+
+// 交付效能指标(DORA)，部署任务结束时增量统计
+// 部署任务: 应用在Apps或ArgocdApps中(Apps为空时不限制)，分支在DeployBranches中，触发方式在DeployTriggers中(为空时不限制)
+type Metrics struct {
+	Retention      time.Duration       `yaml:"retention"`      // 统计数据的保留时间，默认400天
+	Teams          map[string][]string `yaml:"teams"`          // 团队包含的应用
+	Apps           []string            `yaml:"apps"`           // 统计部署的应用
+	DeployBranches []string            `yaml:"deployBranches"` // 部署的分支，默认master和main，*表示所有分支
+	DeployTriggers []string            `yaml:"deployTriggers"` // 部署的触发方式: api, webhook, schedule, retry, resubmit
+	ArgocdApps     map[string]string   `yaml:"argocdApps"`     // 应用对应的Argocd Application，部署时间以Argocd同步完成的时间为准，失败以同步失败和健康状态为准
+	SyncInterval   time.Duration       `yaml:"syncInterval"`   // 同步Argocd部署历史的间隔，0表示不启动
+}
+
 func InitConfig(filepath string) error {
 	bs, err := ioutil.ReadFile(filepath)
 	if err != nil {
@@ -0,0 +1,108 @@
+package controller
+
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/pkg/errors"
+	"lyyops-cicd/dao"
+	"lyyops-cicd/pkg/common"
+	"lyyops-cicd/pkg/log"
+	"lyyops-cicd/pkg/scheduler"
+	"strconv"
+)
+
+// 用于查看和手动触发后台任务
+
+type AdminController struct{}
+
+func AdminControllerGroupRegistry(group *gin.RouterGroup) {
+	controller := AdminController{}
+	group.GET("/reconcile", controller.GetReconcile)
+	group.POST("/reconcile", controller.Reconcile)
+	group.GET("/clean", controller.GetClean)
+	group.POST("/clean", controller.Clean)
+	group.POST("/reindex", controller.Reindex)
+}
+
+// GetReconcile AdminController godoc
+// @Summary 最近一次任务状态修复的结果
+// @Tags 后台管理
+// @Accept json
+// @Produce json
+// @Success 200 {string} string ""
+// @Router /admin/reconcile [get]
+func (a *AdminController) GetReconcile(c *gin.Context) {
+	c.JSON(200, common.SuccessResponse(c, scheduler.LastReconcile()))
+}
+
+// Reconcile AdminController godoc
+// @Summary 立即执行任务状态修复
+// @Tags 后台管理
+// @Accept json
+// @Produce json
+// @Success 200 {string} string ""
+// @Router /admin/reconcile [post]
+func (a *AdminController) Reconcile(c *gin.Context) {
+	c.JSON(200, common.SuccessResponse(c, scheduler.Reconcile()))
+}
+
+// GetClean AdminController godoc
+// @Summary 最近一次任务清理的结果
+// @Tags 后台管理
+// @Accept json
+// @Produce json
+// @Success 200 {string} string ""
+// @Router /admin/clean [get]
+func (a *AdminController) GetClean(c *gin.Context) {
+	c.JSON(200, common.SuccessResponse(c, scheduler.LastClean()))
+}
+
+// Clean AdminController godoc
+// @Summary 立即执行任务清理
+// @Tags 后台管理
+// @Accept json
+// @Produce json
+// @Param dry_run query boolean true "只返回将要清理的任务，不做删除" Enums(true,false)
+// @Success 200 {string} string ""
+// @Router /admin/clean [post]
+func (a *AdminController) Clean(c *gin.Context) {
+	var (
+		code   = common.Success
+		dryRun bool
+		err    error
+	)
+	if dryRun, err = strconv.ParseBool(c.Query("dry_run")); err != nil {
+		code = common.InvalidParam
+		err = errors.Wrap(err, "dry_run字段非法")
+		goto Fail
+	}
+	c.JSON(200, common.SuccessResponse(c, scheduler.CleanJobs(dryRun)))
+	return
+Fail:
+	log.Error(err)
+	c.JSON(400, common.NewResponse(c, code, err.Error()))
+}
+
+// Reindex AdminController godoc
+// @Summary 根据任务记录重建任务索引
+// @Tags 后台管理
+// @Accept json
+// @Produce json
+// @Success 200 {string} string ""
+// @Router /admin/reindex [post]
+func (a *AdminController) Reindex(c *gin.Context) {
+	var (
+		code  = common.Success
+		count int
+		err   error
+	)
+	if count, err = dao.RebuildJobIndex(c); err != nil {
+		code = common.ListJobFailed
+		err = errors.Wrap(err, "重建任务索引失败")
+		goto Fail
+	}
+	c.JSON(200, common.SuccessResponse(c, gin.H{"count": count}))
+	return
+Fail:
+	log.Error(err)
+	c.JSON(400, common.NewResponse(c, code, err.Error()))
+}
@@ -156,7 +156,7 @@ func (a *ApplicationController) Create(c *gin.Context) {
 		goto Fail
 	}
 
-	log.Info("application created: %s", app.Id)
+	log.Infof("application created: %s", app.Id)
 	c.JSON(200, common.SuccessResponse(c, app.Id))
 	return
 
@@ -1,9 +1,12 @@
 package controller
 
 import (
+	"context"
+	"fmt"
 	wfv1 "github.com/argoproj/argo-workflows/v3/pkg/apis/workflow/v1alpha1"
 	"github.com/gin-gonic/gin"
 	"github.com/pkg/errors"
+	"io"
 	"lyyops-cicd/dao"
 	"lyyops-cicd/dto"
 	"lyyops-cicd/pkg/common"
@@ -19,8 +22,15 @@ func JobControllerGroupRegistry(group *gin.RouterGroup) {
 	group.GET(":id", controller.Get)
 	group.GET("/list", controller.List)
 	group.GET("/search", controller.Search)
+	group.GET("/events/:id", controller.Events)
 	group.POST("/create", controller.Create)
 	group.POST("/delete/:id", controller.Delete)
+	group.POST("/stop/:id", controller.Stop)
+	group.POST("/terminate/:id", controller.Terminate)
+	group.POST("/resubmit/:id", controller.Resubmit)
+	group.POST("/retry/:id", controller.Retry)
+	group.POST("/approve/:id", controller.Approve)
+	group.POST("/reject/:id", controller.Reject)
 }
 
 // Get JobController godoc
@@ -51,6 +61,33 @@ func (a *JobController) Get(c *gin.Context) {
 	output.Id = job.Id
 	output.Status = job.Status
 	output.Cost = job.Cost
+	output.AppId = job.AppId
+	output.Trigger = job.Trigger
+	output.CreatedBy = job.CreatedBy
+	output.Commit = job.Commit
+	output.Branch = job.Branch
+	output.Revision = job.Revision
+	output.Parameters = dao.RedactParameters(job.Parameters)
+	output.OriginJob = job.OriginJob
+	output.OriginAction = job.OriginAction
+	output.RetryCount = job.RetryCount
+	output.Redactions = job.Redactions
+	if !job.ArchivedAt.IsZero() {
+		output.ArchivedAt = job.ArchivedAt.Format(time.RFC3339)
+	}
+	if job.Status == dao.JobStatusQueued {
+		output.QueuePosition = job.QueuePosition()
+	}
+	if job.CancelledBy != "" {
+		output.CancelledBy = job.CancelledBy
+		output.CancelledAt = job.CancelledAt.Format(time.RFC3339)
+	}
+	output.ApprovalGate = job.ApprovalGate
+	if output.Approvals, err = approvalsOutput(c, job.Id); err != nil {
+		code = common.GetJobStatusFailed
+		err = errors.Wrap(err, "dao.GetJobApprovals")
+		goto Fail
+	}
 
 	log.Debugf("get job: %+v", output)
 	c.JSON(200, common.SuccessResponse(c, output))
@@ -60,46 +97,141 @@ Fail:
 	c.JSON(400, common.NewResponse(c, code, err.Error()))
 }
 
+// Events JobController godoc
+// @Summary 订阅发布任务的状态变化(Server-Sent Events)
+// @Description 每个事件的data与获取发布任务的返回相同，任务结束时发送end事件并关闭连接
+// @Description 连接不受server.writeTimeout限制；每个连接等待事件时占用一个redis连接(XREAD BLOCK)，同时订阅的客户端数受redis.poolSize限制
+// @Tags 发布任务管理
+// @Produce text/event-stream
+// @Param id path string true "任务 ID"
+// @Param Last-Event-ID header string false "断线重连时上次收到的事件id"
+// @Param last_event_id query string false "同Last-Event-ID，用于不能设置header的客户端"
+// @Success 200 {string} string ""
+// @Router /job/events/{id} [get]
+func (a *JobController) Events(c *gin.Context) {
+	var (
+		code   = common.Success
+		id     = c.Param("id")
+		lastId = c.GetHeader("Last-Event-ID")
+		event  *dao.JobEvent
+		err    error
+	)
+	if lastId == "" {
+		lastId = c.Query("last_event_id")
+	}
+	if _, err = dao.GetJob(c, id); err != nil {
+		code = common.GetJobStatusFailed
+		goto Fail
+	}
+
+	// 新的连接先发送当前状态
+	if lastId == "" {
+		if event, err = dao.LatestJobEvent(c, id); err == nil && event == nil {
+			event, err = dao.JobEventSnapshot(c, id)
+		}
+		if err != nil {
+			code = common.GetJobStatusFailed
+			goto Fail
+		}
+		lastId = event.Id
+	}
+
+	if !common.ClearWriteDeadline(c.Request) {
+		log.Warningf("job[%s] events: cannot clear write deadline, stream will be closed after server.writeTimeout", id)
+	}
+	c.Header("Content-Type", "text/event-stream")
+	c.Header("Cache-Control", "no-cache")
+	c.Header("Connection", "keep-alive")
+	c.Header("X-Accel-Buffering", "no") // 关闭nginx缓存
+	if event != nil {
+		writeJobEvent(c, event)
+		if event.Type == dao.JobEventEnd {
+			return
+		}
+	}
+	c.Stream(func(w io.Writer) bool {
+		ctx := c.Request.Context() // 客户端断开时结束阻塞的读取
+		events, err := dao.ReadJobEvents(ctx, id, lastId, jobEventHeartbeat)
+		if err != nil {
+			if ctx.Err() == nil {
+				log.Errorf("read job[%s] events: %+v", id, err)
+			}
+			return false
+		}
+		for i := range events {
+			writeJobEvent(c, &events[i])
+			lastId = events[i].Id
+			if events[i].Type == dao.JobEventEnd {
+				return false
+			}
+		}
+		if len(events) > 0 {
+			return true
+		}
+
+		// 没有新事件时检查任务是否已结束(例如排队中被取消)，否则发送心跳
+		if event, err := dao.JobEventSnapshot(c, id); err != nil || event.Type == dao.JobEventEnd {
+			if event != nil {
+				writeJobEvent(c, event)
+			}
+			return false
+		}
+		fmt.Fprint(w, ": ping\n\n")
+		return true
+	})
+	return
+Fail:
+	log.Error(err)
+	c.JSON(400, common.NewResponse(c, code, err.Error()))
+}
+
+const jobEventHeartbeat = 15 * time.Second
+
+func writeJobEvent(c *gin.Context, event *dao.JobEvent) {
+	if event.Id != "" {
+		fmt.Fprintf(c.Writer, "id: %s\n", event.Id)
+	}
+	fmt.Fprintf(c.Writer, "event: %s\ndata: %s\n\n", event.Type, event.Data)
+	c.Writer.Flush()
+}
+
 // List JobController godoc
 // @Summary 发布任务列表
 // @Tags 发布任务管理
 // @Accept json
 // @Produce json
-// @Param size query int true "数量"
-// @Success 200 {string} string ""
+// @Param size query int false "数量"
+// @Param cursor query string false "上一页返回的next_cursor"
+// @Param app_id query string false "应用"
+// @Param status query string false "任务状态"
+// @Param branch query string false "代码分支"
+// @Param trigger query string false "触发方式" Enums(api,webhook,schedule,retry,resubmit)
+// @Param user query string false "调用者"
+// @Param commit query string false "完整的commit sha"
+// @Param revision query string false "应用内容的版本"
+// @Param since query string false "开始时间下限(RFC3339)"
+// @Param until query string false "开始时间上限(RFC3339)"
+// @Success 200 {object} dto.ListJobPageOutput
 // @Router /job/list [get]
 func (a *JobController) List(c *gin.Context) {
 	var (
-		code    common.StatusCode
-		jobs    []*dao.Job
-		size    int
-		outputs dto.ListJobOutput
-		out  dto.JobOutput
-		err     error
+		code  = common.Success
+		query dao.JobQuery
+		jobs  []*dao.Job
+		next  string
+		err   error
 	)
-	size, err = strconv.Atoi(c.Query("size"))
-	if err != nil || size <= 0 {
-		size = 100 // 默认值
+	if query, err = jobQuery(c); err != nil {
+		code = common.InvalidParam
+		goto Fail
 	}
 
-	jobs, err = dao.ListJob(c, "", size)
-	if err != nil {
+	if jobs, next, err = dao.QueryJobs(c, query); err != nil {
 		code = common.ListJobFailed
 		err = errors.Wrapf(err, code.GetMsg())
 		goto Fail
 	}
-
-	outputs = make(dto.ListJobOutput, len(jobs))
-	// 拼接返回结果
-	for k, job := range jobs {
-		out = dto.JobOutput{}
-		out.Id = job.Id
-		out.Start = job.StartTime.Format(time.RFC3339)
-		out.End = job.EndTime.Format(time.RFC3339)
-		out.Status = job.Status
-		outputs[k] = out
-	}
-	c.JSON(200, common.SuccessResponse(c, outputs))
+	c.JSON(200, common.SuccessResponse(c, jobPageOutput(jobs, next)))
 	return
 Fail:
 	log.Error(err)
@@ -112,62 +244,138 @@ Fail:
 // @Accept json
 // @Produce json
 // @Param keyword query string true "关键字"
-// @Success 200 {string} string ""
+// @Param size query int false "数量"
+// @Param cursor query string false "上一页返回的next_cursor"
+// @Param app_id query string false "应用"
+// @Param status query string false "任务状态"
+// @Param branch query string false "代码分支"
+// @Param trigger query string false "触发方式" Enums(api,webhook,schedule,retry,resubmit)
+// @Param user query string false "调用者"
+// @Param commit query string false "完整的commit sha"
+// @Param revision query string false "应用内容的版本"
+// @Param since query string false "开始时间下限(RFC3339)"
+// @Param until query string false "开始时间上限(RFC3339)"
+// @Success 200 {object} dto.ListJobPageOutput
 // @Router /job/search [get]
 func (a *JobController) Search(c *gin.Context) {
 	var (
-		code    common.StatusCode
-		jobs    []*dao.Job
-		outputs dto.ListJobOutput
-		out     dto.JobOutput
-		err     error
+		code  = common.Success
+		query dao.JobQuery
+		jobs  []*dao.Job
+		next  string
+		err   error
 	)
+	if query, err = jobQuery(c); err != nil {
+		code = common.InvalidParam
+		goto Fail
+	}
+	query.Keyword = c.Query("keyword")
 
-	jobs, err = dao.ListJob(c, c.Query("keyword"), 100)
-	if err != nil {
+	if jobs, next, err = dao.QueryJobs(c, query); err != nil {
 		code = common.ListJobFailed
 		err = errors.Wrapf(err, code.GetMsg())
 		goto Fail
 	}
-
-	outputs = make(dto.ListJobOutput, len(jobs))
-	// 拼接返回结果
-	for k, job := range jobs {
-		out = dto.JobOutput{}
-		out.Id = job.Id
-		out.Start = job.StartTime.Format(time.RFC3339)
-		out.End = job.EndTime.Format(time.RFC3339)
-		out.Status = job.Status
-		outputs[k] = out
-	}
-	c.JSON(200, common.SuccessResponse(c, outputs))
+	c.JSON(200, common.SuccessResponse(c, jobPageOutput(jobs, next)))
 	return
 Fail:
 	log.Error(err)
 	c.JSON(400, common.NewResponse(c, code, err.Error()))
 }
 
+// 解析任务列表的分页和过滤条件
+func jobQuery(c *gin.Context) (dao.JobQuery, error) {
+	var (
+		query = dao.JobQuery{
+			AppId:    c.Query("app_id"),
+			Status:   c.Query("status"),
+			Branch:   c.Query("branch"),
+			Trigger:  c.Query("trigger"),
+			User:     c.Query("user"),
+			Commit:   c.Query("commit"),
+			Revision: c.Query("revision"),
+			Cursor:   c.Query("cursor"),
+		}
+		err error
+	)
+	if size := c.Query("size"); size != "" {
+		if query.Size, err = strconv.Atoi(size); err != nil || query.Size <= 0 {
+			return query, errors.Errorf("size字段非法: %s", size)
+		}
+	}
+	if since := c.Query("since"); since != "" {
+		if query.Since, err = time.Parse(time.RFC3339, since); err != nil {
+			return query, errors.Wrap(err, "since字段非法")
+		}
+	}
+	if until := c.Query("until"); until != "" {
+		if query.Until, err = time.Parse(time.RFC3339, until); err != nil {
+			return query, errors.Wrap(err, "until字段非法")
+		}
+	}
+	return query, nil
+}
+
+func jobPageOutput(jobs []*dao.Job, next string) dto.ListJobPageOutput {
+	outputs := make(dto.ListJobOutput, len(jobs))
+	// 拼接返回结果
+	for k, job := range jobs {
+		outputs[k] = dto.JobOutput{
+			Id:         job.Id,
+			AppId:      job.AppId,
+			Start:      job.StartTime.Format(time.RFC3339),
+			End:        job.EndTime.Format(time.RFC3339),
+			Status:     job.Status,
+			Trigger:    job.Trigger,
+			CreatedBy:  job.CreatedBy,
+			Commit:     job.Commit,
+			Branch:     job.Branch,
+			Revision:   job.Revision,
+			Parameters: dao.RedactParameters(job.Parameters),
+		}
+	}
+	return dto.ListJobPageOutput{Jobs: outputs, NextCursor: next}
+}
+
 // Create JobController godoc
 // @Summary 创建发布任务
+// @Description 提交时把参数commit(分支或tag)或branch解析成commit sha记录在任务上；仓库地址取参数repo_url或应用的webhook触发规则，只支持http(s)地址，无法解析时commit为空
 // @Tags 发布任务管理
 // @Accept json
 // @Produce json
 // @Param id query string true "Application ID"
-// @Param branch query string true "代码分支"
+// @Param branch query string false "代码分支(等同于参数branch)"
+// @Param input body dto.CreateJobInput false "workflow参数"
+// @Param policy query string false "并发数已满时的策略" Enums(queue,reject,cancel)
 // @Success 200 {string} string ""
 // @Router /job/create [post]
 func (j *JobController) Create(c *gin.Context) {
 	var (
-		code       = common.Success
-		id, branch string
-		app        *dao.Application
-		job        = dao.Job{Ctx: c}
-		wf         *wfv1.Workflow
-		err        error
+		code  = common.Success
+		id    string
+		input = dto.CreateJobInput{}
+		app   *dao.Application
+		job   = dao.Job{Ctx: c}
+		wf    *wfv1.Workflow
+		err   error
 	)
 	id = c.Query("id")
-	branch = c.Query("branch")
-	log.Debugf("application: %s, branch: %s", id, branch)
+	if c.Request.ContentLength > 0 {
+		if err = c.ShouldBindJSON(&input); err != nil {
+			code = common.InvalidParam
+			err = errors.Wrap(err, "parameters字段非法")
+			goto Fail
+		}
+	}
+	if input.Parameters == nil {
+		input.Parameters = map[string]string{}
+	}
+	if branch := c.Query("branch"); branch != "" {
+		if _, ok := input.Parameters["branch"]; !ok {
+			input.Parameters["branch"] = branch
+		}
+	}
+	log.Debugf("application: %s, parameters: %v", id, input.Parameters)
 
 	// 获取applicaion的yaml内容
 	if app, err = dao.GetApplication(c, id); err != nil {
@@ -183,21 +391,30 @@ func (j *JobController) Create(c *gin.Context) {
 		goto Fail
 	}
 
-	// 修改workflow的参数branch
-	wf.Spec.Templates[0].Steps[0].Steps[0].Arguments.Parameters[1].Value = wfv1.AnyStringPtr(branch)
+	// 按参数名修改workflow的参数
+	if job.Parameters, err = dao.ResolveParameters(wf, input.Parameters); err != nil {
+		code = common.InvalidParameters
+		err = errors.Wrap(err, code.GetMsg())
+		goto Fail
+	}
 
 	//job.Id = wf.GetGenerateName()
+	job.AppId = id
+	job.Overrides = input.Parameters
+	job.Trigger = dao.JobTriggerApi
+	job.CreatedBy = operator(c)
+	job.Revision = app.Revision()
 	job.Workflow = wf
 	//log.Debugf("job workflow: %s", common.ParseJsonStr(job.Workflow))
 	job.PhaseNames = job.GetPhaseNames()
 	log.Debugf("job.PhaseNames: %v", job.PhaseNames)
 
-	if err = job.Create(); err != nil {
+	if err = job.Submit(c.Query("policy")); err != nil {
 		code = common.CreateJobFailed
 		err = errors.Wrap(err, code.GetMsg())
 		goto Fail
 	}
-	c.JSON(200, common.SuccessResponse(c, dto.CreateJobOutput{Id: job.Id}))
+	c.JSON(200, common.SuccessResponse(c, dto.CreateJobOutput{Id: job.Id, Status: job.Status}))
 	return
 Fail:
 	log.Error(err)
@@ -229,3 +446,206 @@ Fail:
 	log.Error(err)
 	c.JSON(400, common.NewResponse(c, code, err))
 }
+
+// Stop JobController godoc
+// @Summary 停止发布任务(会执行exit handler)
+// @Tags 发布任务管理
+// @Accept json
+// @Produce json
+// @Param id path string true "任务 ID"
+// @Param user query string false "操作人"
+// @Success 200 {string} string ""
+// @Router /job/stop/{id} [post]
+func (a *JobController) Stop(c *gin.Context) {
+	a.cancel(c, dao.JobCancelStop)
+}
+
+// Terminate JobController godoc
+// @Summary 终止发布任务
+// @Tags 发布任务管理
+// @Accept json
+// @Produce json
+// @Param id path string true "任务 ID"
+// @Param user query string false "操作人"
+// @Success 200 {string} string ""
+// @Router /job/terminate/{id} [post]
+func (a *JobController) Terminate(c *gin.Context) {
+	a.cancel(c, dao.JobCancelTerminate)
+}
+
+func (a *JobController) cancel(c *gin.Context, strategy string) {
+	var (
+		code = common.Success
+		job  = dao.Job{Ctx: c, Id: c.Param("id")}
+		err  error
+	)
+	if err = job.Validate(); err != nil {
+		code = common.InvalidParam
+		goto Fail
+	}
+	if err = job.Cancel(strategy, operator(c)); err != nil {
+		code = common.CancelJobFailed
+		err = errors.Wrap(err, code.GetMsg())
+		goto Fail
+	}
+	c.JSON(200, common.SuccessResponse(c, job.Id))
+	return
+Fail:
+	log.Error(err)
+	c.JSON(400, common.NewResponse(c, code, err.Error()))
+}
+
+// Resubmit JobController godoc
+// @Summary 重新提交发布任务(使用原任务的参数)
+// @Tags 发布任务管理
+// @Accept json
+// @Produce json
+// @Param id path string true "任务 ID"
+// @Success 200 {string} string ""
+// @Router /job/resubmit/{id} [post]
+func (a *JobController) Resubmit(c *gin.Context) {
+	var (
+		code   = common.Success
+		job    = dao.Job{Ctx: c, Id: c.Param("id"), CreatedBy: operator(c)}
+		newJob *dao.Job
+		err    error
+	)
+	if err = job.Validate(); err != nil {
+		code = common.InvalidParam
+		goto Fail
+	}
+	if newJob, err = job.Resubmit(); err != nil {
+		code = common.ResubmitJobFailed
+		err = errors.Wrap(err, code.GetMsg())
+		goto Fail
+	}
+	c.JSON(200, common.SuccessResponse(c, dto.CreateJobOutput{Id: newJob.Id}))
+	return
+Fail:
+	log.Error(err)
+	c.JSON(400, common.NewResponse(c, code, err.Error()))
+}
+
+// Retry JobController godoc
+// @Summary 重试发布任务(只重新执行失败的步骤)
+// @Tags 发布任务管理
+// @Accept json
+// @Produce json
+// @Param id path string true "任务 ID"
+// @Success 200 {string} string ""
+// @Router /job/retry/{id} [post]
+func (a *JobController) Retry(c *gin.Context) {
+	var (
+		code   = common.Success
+		job    = dao.Job{Ctx: c, Id: c.Param("id"), CreatedBy: operator(c)}
+		newJob *dao.Job
+		err    error
+	)
+	if err = job.Validate(); err != nil {
+		code = common.InvalidParam
+		goto Fail
+	}
+	if newJob, err = job.Retry(); err != nil {
+		code = common.RetryJobFailed
+		err = errors.Wrap(err, code.GetMsg())
+		goto Fail
+	}
+	c.JSON(200, common.SuccessResponse(c, dto.CreateJobOutput{Id: newJob.Id}))
+	return
+Fail:
+	log.Error(err)
+	c.JSON(400, common.NewResponse(c, code, err.Error()))
+}
+
+// 操作人: 优先取参数user，其次取请求头X-User
+func operator(c *gin.Context) string {
+	if user := c.Query("user"); user != "" {
+		return user
+	}
+	if user := c.GetHeader("X-User"); user != "" {
+		return user
+	}
+	return "anonymous"
+}
+
+// Approve JobController godoc
+// @Summary 审批通过，恢复等待审批的workflow
+// @Tags 发布任务管理
+// @Accept json
+// @Produce json
+// @Param id path string true "任务 ID"
+// @Param input body dto.ApprovalInput false "审批节点和意见"
+// @Param user query string false "审批人"
+// @Success 200 {string} string ""
+// @Router /job/approve/{id} [post]
+func (a *JobController) Approve(c *gin.Context) {
+	a.approval(c, dao.ApprovalApproved)
+}
+
+// Reject JobController godoc
+// @Summary 审批拒绝，停止等待审批的workflow
+// @Tags 发布任务管理
+// @Accept json
+// @Produce json
+// @Param id path string true "任务 ID"
+// @Param input body dto.ApprovalInput false "审批节点和意见"
+// @Param user query string false "审批人"
+// @Success 200 {string} string ""
+// @Router /job/reject/{id} [post]
+func (a *JobController) Reject(c *gin.Context) {
+	a.approval(c, dao.ApprovalRejected)
+}
+
+func (a *JobController) approval(c *gin.Context, action string) {
+	var (
+		code  = common.Success
+		job   = dao.Job{Ctx: c, Id: c.Param("id")}
+		input = dto.ApprovalInput{}
+		err   error
+	)
+	if err = job.Validate(); err != nil {
+		code = common.InvalidParam
+		goto Fail
+	}
+	if c.Request.ContentLength > 0 {
+		if err = c.ShouldBindJSON(&input); err != nil {
+			code = common.InvalidParam
+			err = errors.Wrap(err, code.GetMsg())
+			goto Fail
+		}
+	}
+	if action == dao.ApprovalApproved {
+		if err = job.Approve(input.Gate, operator(c), input.Comment); err != nil {
+			code = common.ApproveJobFailed
+			err = errors.Wrap(err, code.GetMsg())
+			goto Fail
+		}
+	} else if err = job.Reject(input.Gate, operator(c), input.Comment); err != nil {
+		code = common.RejectJobFailed
+		err = errors.Wrap(err, code.GetMsg())
+		goto Fail
+	}
+	c.JSON(200, common.SuccessResponse(c, job.Id))
+	return
+Fail:
+	log.Error(err)
+	c.JSON(400, common.NewResponse(c, code, err.Error()))
+}
+
+func approvalsOutput(ctx context.Context, id string) ([]dto.ApprovalOutput, error) {
+	approvals, err := dao.GetJobApprovals(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	var out []dto.ApprovalOutput
+	for _, a := range approvals {
+		out = append(out, dto.ApprovalOutput{
+			Gate:     a.Gate,
+			Action:   a.Action,
+			Approver: a.Approver,
+			Comment:  a.Comment,
+			Time:     a.Time.Format(time.RFC3339),
+		})
+	}
+	return out, nil
+}
@@ -1,11 +1,29 @@
 package controller
 
 import (
+	"compress/gzip"
+	"fmt"
+	common2 "github.com/argoproj/argo-workflows/v3/workflow/common"
 	"github.com/gin-gonic/gin"
 	"github.com/pkg/errors"
 	"lyyops-cicd/dao"
 	"lyyops-cicd/pkg/common"
 	"lyyops-cicd/pkg/log"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	logFormatText = "text"
+	logFormatJson = "json"
+)
+
+const (
+	logTailBatch     = 500              // 每次读取的行数
+	logTailInterval  = time.Second      // follow时检查新日志的间隔
+	logTailHeartbeat = 15 * time.Second // 没有新日志时心跳的间隔，SSE发送注释，text格式发送空行
+	logTailMaxIdle   = 10 * time.Minute // 找不到所属任务时，没有新日志超过该时间后结束跟踪
 )
 
 type LogsController struct{}
@@ -13,6 +31,10 @@ type LogsController struct{}
 func LogsControllerGroupRegistry(group *gin.RouterGroup) {
 	controller := LogsController{}
 	group.GET(":id", controller.Get)
+	group.GET("/tail/:id", controller.Tail)
+	group.GET("/job/:id", controller.GetJobLogs)
+	group.GET("/search", controller.Search)
+	group.GET("/job/download/:id", controller.DownloadJobLogs)
 }
 
 // Get LogsController godoc
@@ -21,23 +43,406 @@ func LogsControllerGroupRegistry(group *gin.RouterGroup) {
 // @Accept json
 // @Produce json
 // @Param id path string true "pod name"
+// @Param container query string false "容器名，默认main，all表示按时间合并所有容器"
+// @Param format query string false "text返回每行的文本(默认)，json返回结构化的日志" Enums(text,json)
 // @Success 200 {string} string ""
 // @Router /logs/{id} [get]
 func (l *LogsController) Get(c *gin.Context) {
 	var (
-		code = common.Success
-		res  []string
-		err  error
+		code       = common.Success
+		pod        = c.Param("id")
+		container  = c.DefaultQuery("container", common2.MainContainerName)
+		format     = c.DefaultQuery("format", logFormatText)
+		containers []string
+		res        []dao.LogRecord
+		err        error
 	)
-	res, err = dao.GetLog(c, c.Param("id"))
+	if format != logFormatText && format != logFormatJson {
+		code = common.InvalidParam
+		err = errors.Errorf("不支持的格式: %s", format)
+		goto Fail
+	}
+	if container == dao.AllContainers {
+		if containers, err = dao.PodContainers(c, pod); err == nil {
+			res, err = dao.MergeContainerLogs(c, pod, containers)
+		}
+	} else {
+		res, err = dao.GetContainerLog(c, pod, container)
+	}
 	if err != nil {
 		code = common.GetLogsFailed
 		err = errors.Wrap(err, code.GetMsg())
 		goto Fail
 	}
-	c.JSON(200, common.SuccessResponse(c, res))
+	c.JSON(200, common.SuccessResponse(c, renderLogs(res, format, container == dao.AllContainers)))
+	return
+Fail:
+	log.Error(err)
+	c.JSON(400, common.NewResponse(c, code, err.Error()))
+}
+
+// GetJobLogs LogsController godoc
+// @Summary 获取任务所有pod的日志
+// @Description 按阶段顺序返回，每行标明步骤、pod和容器
+// @Tags 日志管理
+// @Accept json
+// @Produce json
+// @Param id path string true "任务 ID"
+// @Param container query string false "容器名，默认所有容器"
+// @Param format query string false "json返回结构化的日志(默认)，text返回每行的文本" Enums(json,text)
+// @Success 200 {array} dao.LogRecord
+// @Router /logs/job/{id} [get]
+func (l *LogsController) GetJobLogs(c *gin.Context) {
+	var (
+		code   = common.Success
+		format = c.DefaultQuery("format", logFormatJson)
+		job    *dao.Job
+		lines  []dao.LogRecord
+		err    error
+	)
+	if format != logFormatText && format != logFormatJson {
+		code = common.InvalidParam
+		err = errors.Errorf("不支持的格式: %s", format)
+		goto Fail
+	}
+	if job, err = dao.GetJob(c, c.Param("id")); err != nil {
+		code = common.GetJobStatusFailed
+		goto Fail
+	}
+	if lines, err = job.Logs(c.Query("container")); err != nil {
+		code = common.GetLogsFailed
+		err = errors.Wrap(err, code.GetMsg())
+		goto Fail
+	}
+	c.JSON(200, common.SuccessResponse(c, renderLogs(lines, format, true)))
+	return
+Fail:
+	log.Error(err)
+	c.JSON(400, common.NewResponse(c, code, err.Error()))
+}
+
+// DownloadJobLogs LogsController godoc
+// @Summary 下载任务的全部日志
+// @Tags 日志管理
+// @Produce plain
+// @Produce application/gzip
+// @Param id path string true "任务 ID"
+// @Param format query string false "文件格式，默认text" Enums(text,gzip)
+// @Param container query string false "容器名，默认所有容器"
+// @Success 200 {string} string ""
+// @Router /logs/job/download/{id} [get]
+func (l *LogsController) DownloadJobLogs(c *gin.Context) {
+	var (
+		code   = common.Success
+		format = c.DefaultQuery("format", "text")
+		job    *dao.Job
+		err    error
+	)
+	if format != "text" && format != "gzip" {
+		code = common.InvalidParam
+		err = errors.Errorf("不支持的格式: %s", format)
+		goto Fail
+	}
+	if job, err = dao.GetJob(c, c.Param("id")); err != nil {
+		code = common.GetJobStatusFailed
+		goto Fail
+	}
+
+	if format == "gzip" {
+		c.Header("Content-Type", "application/gzip")
+		c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=%s.log.gz", job.Id))
+		gz := gzip.NewWriter(c.Writer)
+		err = job.WriteLogs(gz, c.Query("container"))
+		if cerr := gz.Close(); err == nil {
+			err = cerr
+		}
+	} else {
+		c.Header("Content-Type", "text/plain; charset=utf-8")
+		c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=%s.log", job.Id))
+		err = job.WriteLogs(c.Writer, c.Query("container"))
+	}
+	if err != nil {
+		log.Errorf("download job[%s] logs: %+v", job.Id, err) // 已开始写入，只能记录错误
+	}
+	return
+Fail:
+	log.Error(err)
+	c.JSON(400, common.NewResponse(c, code, err.Error()))
+}
+
+// Search LogsController godoc
+// @Summary 在多个任务的日志中搜索
+// @Description 按任务开始时间倒序搜索，匹配数、读取的日志字节数或搜索的任务数达到上限时返回next_cursor，从中断的日志行继续搜索
+// @Tags 日志管理
+// @Accept json
+// @Produce json
+// @Param q query string true "搜索内容"
+// @Param regex query boolean false "q为正则表达式"
+// @Param ignore_case query boolean false "忽略大小写"
+// @Param app_id query string false "应用"
+// @Param status query string false "任务状态"
+// @Param step query string false "步骤名"
+// @Param since query string false "任务开始时间下限(RFC3339)"
+// @Param until query string false "任务开始时间上限(RFC3339)"
+// @Param context query int false "匹配行前后各返回的行数，最多10"
+// @Param limit query int false "最多返回的匹配数，默认100"
+// @Param max_bytes query int false "最多读取的日志字节数，默认16MB，最大256MB"
+// @Param max_jobs query int false "最多搜索的任务数，默认200，最大1000"
+// @Param cursor query string false "上次返回的next_cursor"
+// @Success 200 {object} dao.LogSearchResult
+// @Router /logs/search [get]
+func (l *LogsController) Search(c *gin.Context) {
+	var (
+		code   = common.Success
+		query  dao.LogSearchQuery
+		result *dao.LogSearchResult
+		err    error
+	)
+	if query, err = logSearchQuery(c); err != nil {
+		code = common.InvalidParam
+		goto Fail
+	}
+	if result, err = dao.SearchLogs(c, query); err != nil {
+		code = common.GetLogsFailed
+		err = errors.Wrap(err, code.GetMsg())
+		goto Fail
+	}
+	c.JSON(200, common.SuccessResponse(c, result))
 	return
 Fail:
 	log.Error(err)
 	c.JSON(400, common.NewResponse(c, code, err.Error()))
 }
+
+// 解析日志搜索的参数
+func logSearchQuery(c *gin.Context) (dao.LogSearchQuery, error) {
+	var (
+		query = dao.LogSearchQuery{
+			Query:  c.Query("q"),
+			AppId:  c.Query("app_id"),
+			Status: c.Query("status"),
+			Step:   c.Query("step"),
+			Cursor: c.Query("cursor"),
+		}
+		err error
+	)
+	if query.Query == "" {
+		return query, errors.New("q不能为空")
+	}
+	if query.Regex, err = strconv.ParseBool(c.DefaultQuery("regex", "false")); err != nil {
+		return query, errors.Wrap(err, "regex字段非法")
+	}
+	if query.IgnoreCase, err = strconv.ParseBool(c.DefaultQuery("ignore_case", "false")); err != nil {
+		return query, errors.Wrap(err, "ignore_case字段非法")
+	}
+	if since := c.Query("since"); since != "" {
+		if query.Since, err = time.Parse(time.RFC3339, since); err != nil {
+			return query, errors.Wrap(err, "since字段非法")
+		}
+	}
+	if until := c.Query("until"); until != "" {
+		if query.Until, err = time.Parse(time.RFC3339, until); err != nil {
+			return query, errors.Wrap(err, "until字段非法")
+		}
+	}
+	if query.Context, err = strconv.Atoi(c.DefaultQuery("context", "2")); err != nil || query.Context < 0 {
+		return query, errors.Errorf("context字段非法: %s", c.Query("context"))
+	}
+	if query.MaxMatches, err = strconv.Atoi(c.DefaultQuery("limit", "0")); err != nil || query.MaxMatches < 0 {
+		return query, errors.Errorf("limit字段非法: %s", c.Query("limit"))
+	}
+	if query.MaxBytes, err = strconv.ParseInt(c.DefaultQuery("max_bytes", "0"), 10, 64); err != nil || query.MaxBytes < 0 {
+		return query, errors.Errorf("max_bytes字段非法: %s", c.Query("max_bytes"))
+	}
+	if query.MaxJobs, err = strconv.Atoi(c.DefaultQuery("max_jobs", "0")); err != nil || query.MaxJobs < 0 {
+		return query, errors.Errorf("max_jobs字段非法: %s", c.Query("max_jobs"))
+	}
+	return query, nil
+}
+
+// Tail LogsController godoc
+// @Summary 实时查看pod日志
+// @Description 默认返回分块的纯文本；Accept为text/event-stream时返回SSE，事件id为下一行的offset，可用Last-Event-ID断线续传
+// @Tags 日志管理
+// @Produce plain
+// @Produce text/event-stream
+// @Param id path string true "pod name"
+// @Param container query string false "容器名，默认main"
+// @Param follow query boolean false "持续输出新的日志，任务结束后关闭(找不到所属任务时10分钟没有新日志后关闭)；15秒没有新日志时发送心跳，text格式为空行"
+// @Param since query string false "只返回该时间之后的日志(RFC3339)"
+// @Param tail query int false "只返回最后N行"
+// @Param offset query int false "从第offset行开始(从0开始)，用于断线续传"
+// @Param format query string false "text每行输出文本(默认)，json每行输出一个json" Enums(text,json)
+// @Success 200 {string} string ""
+// @Router /logs/tail/{id} [get]
+func (l *LogsController) Tail(c *gin.Context) {
+	var (
+		code      = common.Success
+		pod       = c.Param("id")
+		container = c.DefaultQuery("container", common2.MainContainerName)
+		sse       = strings.Contains(c.GetHeader("Accept"), "text/event-stream")
+		format    = c.DefaultQuery("format", logFormatText)
+		follow    bool
+		start     int64
+		err       error
+	)
+	if follow, err = strconv.ParseBool(c.DefaultQuery("follow", "false")); err != nil {
+		code = common.InvalidParam
+		err = errors.Wrap(err, "follow字段非法")
+		goto Fail
+	}
+	if format != logFormatText && format != logFormatJson {
+		code = common.InvalidParam
+		err = errors.Errorf("不支持的格式: %s", format)
+		goto Fail
+	}
+	if start, err = logTailStart(c, pod, container); err != nil {
+		code = common.InvalidParam
+		goto Fail
+	}
+
+	if follow && !common.ClearWriteDeadline(c.Request) {
+		log.Warningf("tail logs %s: cannot clear write deadline, stream will be closed after server.writeTimeout", pod)
+	}
+	if sse {
+		c.Header("Content-Type", "text/event-stream")
+		c.Header("Cache-Control", "no-cache")
+	} else {
+		c.Header("Content-Type", "text/plain; charset=utf-8")
+	}
+	c.Header("X-Accel-Buffering", "no") // 关闭nginx缓存
+	if err = tailLogs(c, pod, container, format, start, follow, sse); err != nil {
+		log.Errorf("tail logs %s: %+v", pod, err)
+	}
+	return
+Fail:
+	log.Error(err)
+	c.JSON(400, common.NewResponse(c, code, err.Error()))
+}
+
+// 开始读取的行号: offset(或Last-Event-ID) > since，再按tail截取最后N行
+func logTailStart(c *gin.Context, pod, container string) (int64, error) {
+	var (
+		start int64
+		err   error
+	)
+	offset := c.Query("offset")
+	if offset == "" {
+		offset = c.GetHeader("Last-Event-ID")
+	}
+	switch {
+	case offset != "":
+		if start, err = strconv.ParseInt(offset, 10, 64); err != nil || start < 0 {
+			return 0, errors.Errorf("offset字段非法: %s", offset)
+		}
+		return start, nil // 断线续传时忽略since和tail
+	case c.Query("since") != "":
+		since, err := time.Parse(time.RFC3339, c.Query("since"))
+		if err != nil {
+			return 0, errors.Wrap(err, "since字段非法")
+		}
+		if start, err = dao.LogOffsetSince(c, pod, container, since); err != nil {
+			return 0, err
+		}
+	}
+
+	if tail := c.Query("tail"); tail != "" {
+		n, err := strconv.ParseInt(tail, 10, 64)
+		if err != nil || n < 0 {
+			return 0, errors.Errorf("tail字段非法: %s", tail)
+		}
+		length, err := dao.LogLength(c, pod, container)
+		if err != nil {
+			return 0, err
+		}
+		if length-n > start {
+			start = length - n
+		}
+	}
+	return start, nil
+}
+
+// 从start行开始输出日志，follow时等待新的日志直到任务的日志采集完成或客户端断开
+// 找不到pod所属的任务时，没有新日志超过logTailMaxIdle后结束
+func tailLogs(c *gin.Context, pod, container, format string, start int64, follow, sse bool) error {
+	var (
+		ctx      = c.Request.Context()
+		idle     = time.Now() // 最后一次输出(日志或心跳)的时间
+		lastLine = time.Now() // 最后一次读到日志的时间
+		finished bool
+	)
+	for {
+		lines, err := dao.GetLogRange(ctx, pod, container, start, start+logTailBatch-1)
+		if err != nil {
+			return err
+		}
+		for i := range lines {
+			start++
+			line := lines[i].Text()
+			if format == logFormatJson {
+				line = lines[i].String()
+			}
+			if sse {
+				fmt.Fprintf(c.Writer, "id: %d\ndata: %s\n\n", start, line)
+			} else {
+				fmt.Fprintln(c.Writer, line)
+			}
+		}
+		c.Writer.Flush()
+		if len(lines) == logTailBatch {
+			continue
+		}
+		if !follow || finished {
+			break
+		}
+
+		// 日志采集完成后再读取一次，避免漏掉最后的日志
+		if len(lines) > 0 {
+			idle, lastLine = time.Now(), time.Now()
+		}
+		if id := dao.LogJobId(ctx, pod); id != "" {
+			if job, err := dao.GetJob(ctx, id); err != nil || job.LogsComplete() {
+				finished = true
+				continue
+			}
+		} else if time.Since(lastLine) >= logTailMaxIdle {
+			log.Warningf("tail logs %s: job not found, stop after %s idle", pod, logTailMaxIdle)
+			finished = true
+			continue
+		}
+		if time.Since(idle) >= logTailHeartbeat {
+			idle = time.Now()
+			if sse {
+				fmt.Fprint(c.Writer, ": ping\n\n")
+			} else {
+				fmt.Fprintln(c.Writer)
+			}
+			c.Writer.Flush()
+		}
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-time.After(logTailInterval):
+		}
+	}
+	if sse {
+		fmt.Fprintf(c.Writer, "id: %d\nevent: end\ndata: \n\n", start)
+		c.Writer.Flush()
+	}
+	return nil
+}
+
+// 按格式输出日志，text格式合并多个容器时在行首标明容器
+func renderLogs(records []dao.LogRecord, format string, labelContainer bool) interface{} {
+	if format == logFormatJson {
+		return records
+	}
+	lines := make([]string, len(records))
+	for i := range records {
+		lines[i] = records[i].Text()
+		if labelContainer {
+			lines[i] = fmt.Sprintf("[%s] %s", records[i].Container, lines[i])
+		}
+	}
+	return lines
+}
@@ -0,0 +1,140 @@
+package controller
+
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/pkg/errors"
+	"lyyops-cicd/dao"
+	"lyyops-cicd/dto"
+	"lyyops-cicd/pkg/common"
+	"lyyops-cicd/pkg/log"
+	"math"
+	"time"
+)
+
+// 交付效能指标(DORA)
+
+const (
+	defaultMetricsRange = 30 * 24 * time.Hour
+	maxMetricsRange     = 366 * 24 * time.Hour
+)
+
+type MetricsController struct{}
+
+func MetricsControllerGroupRegistry(group *gin.RouterGroup) {
+	controller := MetricsController{}
+	group.GET("/dora", controller.Dora)
+}
+
+// Dora MetricsController godoc
+// @Summary 交付效能指标(部署频率、变更前置时间、变更失败率、服务恢复时间)
+// @Description 按应用或团队统计，app_id和team都为空时统计所有应用；只统计部署任务(见metrics配置)，Argocd应用的失败以同步失败和健康状态为准；时间单位为秒
+// @Tags 交付效能指标
+// @Accept json
+// @Produce json
+// @Param app_id query string false "应用"
+// @Param team query string false "团队(配置文件metrics.teams)"
+// @Param since query string false "开始时间(RFC3339)，默认为30天前"
+// @Param until query string false "结束时间(RFC3339)，默认为当前时间"
+// @Param interval query string false "时间序列的间隔" Enums(day,week,month)
+// @Success 200 {object} dto.GetDoraMetricsOutput
+// @Router /metrics/dora [get]
+func (m *MetricsController) Dora(c *gin.Context) {
+	var (
+		code   = common.Success
+		query  dao.DoraQuery
+		series []*dao.DoraStats
+		total  *dao.DoraStats
+		output = dto.GetDoraMetricsOutput{Team: c.Query("team")}
+		err    error
+	)
+	if query, err = doraQuery(c); err != nil {
+		code = common.InvalidParam
+		goto Fail
+	}
+	if query.AppIds == nil {
+		if query.AppIds, err = dao.ListApplication(c); err != nil {
+			code = common.ListApplicationFailed
+			err = errors.Wrap(err, code.GetMsg())
+			goto Fail
+		}
+	}
+
+	if series, total, err = dao.QueryDora(c, query); err != nil {
+		code = common.GetDoraMetricsFailed
+		err = errors.Wrap(err, code.GetMsg())
+		goto Fail
+	}
+	output.AppIds = query.AppIds
+	output.Interval = query.Interval
+	output.Summary = doraMetricsOutput(total)
+	output.Series = make([]dto.DoraMetricsOutput, len(series))
+	for k, s := range series {
+		output.Series[k] = doraMetricsOutput(s)
+	}
+	c.JSON(200, common.SuccessResponse(c, output))
+	return
+Fail:
+	log.Error(err)
+	c.JSON(400, common.NewResponse(c, code, err.Error()))
+}
+
+// 解析指标的应用范围、时间范围和间隔，没有指定应用和团队时AppIds为nil
+func doraQuery(c *gin.Context) (dao.DoraQuery, error) {
+	var (
+		query = dao.DoraQuery{Until: time.Now(), Interval: c.DefaultQuery("interval", dao.MetricsIntervalDay)}
+		err   error
+	)
+	switch query.Interval {
+	case dao.MetricsIntervalDay, dao.MetricsIntervalWeek, dao.MetricsIntervalMonth:
+	default:
+		return query, errors.Errorf("interval字段非法: %s", query.Interval)
+	}
+	if until := c.Query("until"); until != "" {
+		if query.Until, err = time.Parse(time.RFC3339, until); err != nil {
+			return query, errors.Wrap(err, "until字段非法")
+		}
+	}
+	query.Since = query.Until.Add(-defaultMetricsRange)
+	if since := c.Query("since"); since != "" {
+		if query.Since, err = time.Parse(time.RFC3339, since); err != nil {
+			return query, errors.Wrap(err, "since字段非法")
+		}
+	}
+	if !query.Since.Before(query.Until) {
+		return query, errors.New("since必须早于until")
+	}
+	if query.Until.Sub(query.Since) > maxMetricsRange {
+		return query, errors.Errorf("时间范围不能超过%d天", int(maxMetricsRange.Hours()/24))
+	}
+
+	switch appId, team := c.Query("app_id"), c.Query("team"); {
+	case appId != "":
+		query.AppIds = []string{appId}
+	case team != "":
+		apps, ok := dao.TeamApps(team)
+		if !ok {
+			return query, errors.Errorf("团队不存在: %s", team)
+		}
+		query.AppIds = append([]string{}, apps...)
+	}
+	return query, nil
+}
+
+func doraMetricsOutput(s *dao.DoraStats) dto.DoraMetricsOutput {
+	return dto.DoraMetricsOutput{
+		Start:               s.Start.Format(time.RFC3339),
+		End:                 s.End.Format(time.RFC3339),
+		Deployments:         s.Deployments,
+		Failures:            s.Failures,
+		DeploymentFrequency: round(s.DeploymentFrequency(), 3),
+		LeadTime:            int64(s.LeadTime() / time.Second),
+		ChangeFailureRate:   round(s.ChangeFailureRate(), 3),
+		TimeToRestore:       int64(s.TimeToRestore() / time.Second),
+		Restores:            s.RestoreCount,
+	}
+}
+
+func round(f float64, places int) float64 {
+	p := math.Pow(10, float64(places))
+	return math.Round(f*p) / p
+}
@@ -0,0 +1,425 @@
+package controller
+
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/pkg/errors"
+	"lyyops-cicd/dao"
+	"lyyops-cicd/dto"
+	"lyyops-cicd/pkg/common"
+	"lyyops-cicd/pkg/log"
+	"net/mail"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// 任务状态变化的通知目标和通知记录
+
+type NotifyController struct{}
+
+func NotifyControllerGroupRegistry(group *gin.RouterGroup) {
+	controller := NotifyController{}
+	group.GET("/target/list", controller.ListTarget)
+	group.POST("/target/create", controller.CreateTarget)
+	group.POST("/target/delete/:id", controller.DeleteTarget)
+	group.POST("/target/test/:id", controller.TestTarget)
+
+	group.GET("/subscription/list", controller.ListSubscription)
+	group.POST("/subscription/create", controller.CreateSubscription)
+	group.POST("/subscription/delete/:id", controller.DeleteSubscription)
+	group.POST("/subscription/test/:id", controller.TestSubscription)
+
+	group.GET("/delivery/:job_id", controller.ListDelivery)
+}
+
+// ListTarget NotifyController godoc
+// @Summary 通知目标列表
+// @Tags 通知
+// @Accept json
+// @Produce json
+// @Param app_id query string false "Application ID"
+// @Success 200 {object} []dto.NotifyTargetOutput
+// @Router /notify/target/list [get]
+func (n *NotifyController) ListTarget(c *gin.Context) {
+	var (
+		code    = common.Success
+		targets []*dao.NotifyTarget
+		outputs []dto.NotifyTargetOutput
+		err     error
+	)
+	if targets, err = dao.ListNotifyTarget(c, c.Query("app_id")); err != nil {
+		code = common.ListNotifyTargetFailed
+		err = errors.Wrap(err, code.GetMsg())
+		goto Fail
+	}
+	outputs = make([]dto.NotifyTargetOutput, len(targets))
+	for k, target := range targets {
+		outputs[k] = notifyTargetOutput(target)
+	}
+	c.JSON(200, common.SuccessResponse(c, outputs))
+	return
+Fail:
+	log.Error(err)
+	c.JSON(400, common.NewResponse(c, code, err.Error()))
+}
+
+// CreateTarget NotifyController godoc
+// @Summary 创建通知目标
+// @Description type: webhook(带HMAC签名的http请求), dingtalk, wecom, slack；events为空时只通知结束的状态，*为全部状态
+// @Tags 通知
+// @Accept json
+// @Produce json
+// @Param input body dto.CreateNotifyTargetInput true "通知目标"
+// @Param user query string false "操作人"
+// @Success 200 {object} dto.NotifyTargetOutput
+// @Router /notify/target/create [post]
+func (n *NotifyController) CreateTarget(c *gin.Context) {
+	var (
+		code   = common.Success
+		input  = dto.CreateNotifyTargetInput{}
+		target = dao.NotifyTarget{Ctx: c}
+		err    error
+	)
+	if err = c.ShouldBindJSON(&input); err != nil {
+		code = common.InvalidParam
+		err = errors.Wrap(err, code.GetMsg())
+		goto Fail
+	}
+	if input.AppId == "" || input.Url == "" {
+		code = common.InvalidParam
+		err = errors.New("app_id和url不能为空")
+		goto Fail
+	}
+	if !isNotifyType(input.Type) {
+		code = common.InvalidParam
+		err = errors.Errorf("不支持的通知类型: %s", input.Type)
+		goto Fail
+	}
+	for _, e := range input.Events {
+		if !dao.IsNotifyEvent(e) {
+			code = common.InvalidParam
+			err = errors.Errorf("不支持的任务状态: %s", e)
+			goto Fail
+		}
+	}
+	if _, err = dao.GetApplication(c, input.AppId); err != nil {
+		code = common.ApplicationNotFound
+		err = errors.Wrap(err, code.GetMsg())
+		goto Fail
+	}
+
+	target.AppId = input.AppId
+	target.Name = input.Name
+	target.Type = input.Type
+	target.Url = input.Url
+	target.Secret = input.Secret
+	target.Events = input.Events
+	target.CreatedBy = operator(c)
+	target.CreatedAt = time.Now()
+	if err = target.Save(); err != nil {
+		code = common.SaveNotifyTargetFailed
+		err = errors.Wrap(err, code.GetMsg())
+		goto Fail
+	}
+	c.JSON(200, common.SuccessResponse(c, notifyTargetOutput(&target)))
+	return
+Fail:
+	log.Error(err)
+	c.JSON(400, common.NewResponse(c, code, err.Error()))
+}
+
+// DeleteTarget NotifyController godoc
+// @Summary 删除通知目标
+// @Tags 通知
+// @Accept json
+// @Produce json
+// @Param id path string true "通知目标 ID"
+// @Success 200 {string} string ""
+// @Router /notify/target/delete/{id} [post]
+func (n *NotifyController) DeleteTarget(c *gin.Context) {
+	var (
+		code   = common.Success
+		target = dao.NotifyTarget{Ctx: c, Id: c.Param("id")}
+		err    error
+	)
+	if err = target.Delete(); err != nil {
+		code = common.DeleteNotifyTargetFailed
+		err = errors.Wrap(err, code.GetMsg())
+		goto Fail
+	}
+	c.JSON(200, common.SuccessResponse(c, target.Id))
+	return
+Fail:
+	log.Error(err)
+	c.JSON(400, common.NewResponse(c, code, err.Error()))
+}
+
+// TestTarget NotifyController godoc
+// @Summary 发送测试通知
+// @Tags 通知
+// @Accept json
+// @Produce json
+// @Param id path string true "通知目标 ID"
+// @Success 200 {string} string ""
+// @Router /notify/target/test/{id} [post]
+func (n *NotifyController) TestTarget(c *gin.Context) {
+	var (
+		code     = common.Success
+		target   *dao.NotifyTarget
+		delivery *dao.NotifyDelivery
+		err      error
+	)
+	if target, err = dao.GetNotifyTarget(c, c.Param("id")); err != nil {
+		code = common.GetNotifyTargetFailed
+		err = errors.Wrap(err, code.GetMsg())
+		goto Fail
+	}
+	if delivery = target.SendTest(c.Request.Context()); delivery.Status != dao.NotifyDeliverySuccess {
+		code = common.SendNotifyFailed
+		err = errors.Errorf("%s: %s", code.GetMsg(), delivery.Attempts[0].Error)
+		goto Fail
+	}
+	c.JSON(200, common.SuccessResponse(c, delivery))
+	return
+Fail:
+	log.Error(err)
+	c.JSON(400, common.NewResponse(c, code, err.Error()))
+}
+
+// ListSubscription NotifyController godoc
+// @Summary 邮件订阅列表
+// @Tags 通知
+// @Accept json
+// @Produce json
+// @Param app_id query string false "Application ID"
+// @Param user query string false "订阅的用户"
+// @Success 200 {string} string ""
+// @Router /notify/subscription/list [get]
+func (n *NotifyController) ListSubscription(c *gin.Context) {
+	var (
+		code = common.Success
+		subs []*dao.NotifySubscription
+		err  error
+	)
+	if subs, err = dao.ListNotifySubscription(c, c.Query("app_id"), c.Query("user")); err != nil {
+		code = common.ListNotifySubscriptionFailed
+		err = errors.Wrap(err, code.GetMsg())
+		goto Fail
+	}
+	c.JSON(200, common.SuccessResponse(c, subs))
+	return
+Fail:
+	log.Error(err)
+	c.JSON(400, common.NewResponse(c, code, err.Error()))
+}
+
+// CreateSubscription NotifyController godoc
+// @Summary 订阅应用的邮件通知
+// @Description events: failed(只通知失败), deploy(任务结束), all(所有状态变化)；同一个用户重复订阅时更新
+// @Tags 通知
+// @Accept json
+// @Produce json
+// @Param input body dto.CreateNotifySubscriptionInput true "邮件订阅"
+// @Param user query string false "订阅的用户"
+// @Success 200 {string} string ""
+// @Router /notify/subscription/create [post]
+func (n *NotifyController) CreateSubscription(c *gin.Context) {
+	var (
+		code  = common.Success
+		input = dto.CreateNotifySubscriptionInput{}
+		sub   = dao.NotifySubscription{Ctx: c}
+		err   error
+	)
+	if err = c.ShouldBindJSON(&input); err != nil {
+		code = common.InvalidParam
+		err = errors.Wrap(err, code.GetMsg())
+		goto Fail
+	}
+	if input.AppId == "" {
+		code = common.InvalidParam
+		err = errors.New("app_id不能为空")
+		goto Fail
+	}
+	if input.Email, err = parseEmail(input.Email); err != nil {
+		code = common.InvalidParam
+		goto Fail
+	}
+	if input.Events == "" {
+		input.Events = dao.SubscribeFailed
+	}
+	if !isSubscribeEvent(input.Events) {
+		code = common.InvalidParam
+		err = errors.Errorf("不支持的订阅事件: %s", input.Events)
+		goto Fail
+	}
+	if _, err = dao.GetApplication(c, input.AppId); err != nil {
+		code = common.ApplicationNotFound
+		err = errors.Wrap(err, code.GetMsg())
+		goto Fail
+	}
+
+	sub.AppId = input.AppId
+	sub.User = operator(c)
+	sub.Email = input.Email
+	sub.Events = input.Events
+	sub.CreatedAt = time.Now()
+	if err = sub.Save(); err != nil {
+		code = common.SaveNotifySubscriptionFailed
+		err = errors.Wrap(err, code.GetMsg())
+		goto Fail
+	}
+	c.JSON(200, common.SuccessResponse(c, sub))
+	return
+Fail:
+	log.Error(err)
+	c.JSON(400, common.NewResponse(c, code, err.Error()))
+}
+
+// DeleteSubscription NotifyController godoc
+// @Summary 取消邮件订阅
+// @Tags 通知
+// @Accept json
+// @Produce json
+// @Param id path string true "订阅 ID"
+// @Success 200 {string} string ""
+// @Router /notify/subscription/delete/{id} [post]
+func (n *NotifyController) DeleteSubscription(c *gin.Context) {
+	var (
+		code = common.Success
+		sub  = dao.NotifySubscription{Ctx: c, Id: c.Param("id")}
+		err  error
+	)
+	if err = sub.Delete(); err != nil {
+		code = common.DeleteNotifySubscriptionFailed
+		err = errors.Wrap(err, code.GetMsg())
+		goto Fail
+	}
+	c.JSON(200, common.SuccessResponse(c, sub.Id))
+	return
+Fail:
+	log.Error(err)
+	c.JSON(400, common.NewResponse(c, code, err.Error()))
+}
+
+// TestSubscription NotifyController godoc
+// @Summary 发送测试邮件
+// @Tags 通知
+// @Accept json
+// @Produce json
+// @Param id path string true "订阅 ID"
+// @Success 200 {string} string ""
+// @Router /notify/subscription/test/{id} [post]
+func (n *NotifyController) TestSubscription(c *gin.Context) {
+	var (
+		code     = common.Success
+		sub      *dao.NotifySubscription
+		delivery *dao.NotifyDelivery
+		err      error
+	)
+	if sub, err = dao.GetNotifySubscription(c, c.Param("id")); err != nil {
+		code = common.GetNotifySubscriptionFailed
+		err = errors.Wrap(err, code.GetMsg())
+		goto Fail
+	}
+	if delivery = sub.SendTest(); delivery.Status != dao.NotifyDeliverySuccess {
+		code = common.SendNotifyFailed
+		err = errors.Errorf("%s: %s", code.GetMsg(), delivery.Attempts[0].Error)
+		goto Fail
+	}
+	c.JSON(200, common.SuccessResponse(c, delivery))
+	return
+Fail:
+	log.Error(err)
+	c.JSON(400, common.NewResponse(c, code, err.Error()))
+}
+
+// ListDelivery NotifyController godoc
+// @Summary 任务的通知记录
+// @Tags 通知
+// @Accept json
+// @Produce json
+// @Param job_id path string true "任务 ID"
+// @Success 200 {string} string ""
+// @Router /notify/delivery/{job_id} [get]
+func (n *NotifyController) ListDelivery(c *gin.Context) {
+	var (
+		code       = common.Success
+		deliveries []*dao.NotifyDelivery
+		err        error
+	)
+	if deliveries, err = dao.ListNotifyDelivery(c, c.Param("job_id")); err != nil {
+		code = common.ListNotifyDeliveryFailed
+		err = errors.Wrap(err, code.GetMsg())
+		goto Fail
+	}
+	c.JSON(200, common.SuccessResponse(c, deliveries))
+	return
+Fail:
+	log.Error(err)
+	c.JSON(400, common.NewResponse(c, code, err.Error()))
+}
+
+func isSubscribeEvent(event string) bool {
+	for _, e := range dao.SubscribeEvents {
+		if e == event {
+			return true
+		}
+	}
+	return false
+}
+
+func isNotifyType(typ string) bool {
+	for _, t := range dao.NotifyTypes {
+		if t == typ {
+			return true
+		}
+	}
+	return false
+}
+
+// 通知目标的返回不包含密钥: 签名密钥只返回是否设置，url中的参数(钉钉access_token、企业微信key)和slack的token路径隐藏
+func notifyTargetOutput(t *dao.NotifyTarget) dto.NotifyTargetOutput {
+	return dto.NotifyTargetOutput{
+		Id:        t.Id,
+		AppId:     t.AppId,
+		Name:      t.Name,
+		Type:      t.Type,
+		Url:       maskNotifyUrl(t.Type, t.Url),
+		HasSecret: t.Secret != "",
+		Events:    t.Events,
+		CreatedBy: t.CreatedBy,
+		CreatedAt: t.CreatedAt.Format(time.RFC3339),
+	}
+}
+
+func maskNotifyUrl(typ, rawUrl string) string {
+	u, err := url.Parse(rawUrl)
+	if err != nil {
+		return dao.RedactMask
+	}
+	path := u.EscapedPath()
+	if typ == dao.NotifyTypeSlack {
+		// https://hooks.slack.com/services/T000/B000/XXXX，最后一段是token
+		if i := strings.LastIndex(path, "/"); i >= 0 {
+			path = path[:i+1] + dao.RedactMask
+		}
+	}
+	masked := u.Scheme + "://" + u.Host + path
+	if u.RawQuery != "" {
+		var params []string
+		for _, kv := range strings.Split(u.RawQuery, "&") {
+			params = append(params, strings.SplitN(kv, "=", 2)[0]+"="+dao.RedactMask)
+		}
+		masked += "?" + strings.Join(params, "&")
+	}
+	return masked
+}
+
+// 校验邮箱地址，只返回地址部分(不含显示名)，避免写入邮件头时被注入
+func parseEmail(email string) (string, error) {
+	addr, err := mail.ParseAddress(email)
+	if err != nil {
+		return "", errors.Wrapf(err, "email字段非法: %q", email)
+	}
+	return addr.Address, nil
+}
@@ -0,0 +1,235 @@
+package controller
+
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/pkg/errors"
+	"lyyops-cicd/dao"
+	"lyyops-cicd/dto"
+	"lyyops-cicd/pkg/common"
+	"lyyops-cicd/pkg/log"
+	"lyyops-cicd/pkg/scheduler"
+	"time"
+)
+
+// 定时发布任务
+
+const nextFireCount = 5 // 返回之后几次的触发时间
+
+type ScheduleController struct{}
+
+func ScheduleControllerGroupRegistry(group *gin.RouterGroup) {
+	controller := ScheduleController{}
+	group.GET(":id", controller.Get)
+	group.GET("/list", controller.List)
+	group.POST("/create", controller.Create)
+	group.POST("/pause/:id", controller.Pause)
+	group.POST("/resume/:id", controller.Resume)
+	group.POST("/delete/:id", controller.Delete)
+}
+
+// Get ScheduleController godoc
+// @Summary 获取定时任务
+// @Tags 定时任务管理
+// @Accept json
+// @Produce json
+// @Param id path string true "定时任务 ID"
+// @Success 200 {string} string ""
+// @Router /schedule/{id} [get]
+func (s *ScheduleController) Get(c *gin.Context) {
+	var (
+		code     = common.Success
+		schedule *dao.Schedule
+		err      error
+	)
+	if schedule, err = dao.GetSchedule(c, c.Param("id")); err != nil {
+		code = common.GetScheduleFailed
+		err = errors.Wrap(err, code.GetMsg())
+		goto Fail
+	}
+	c.JSON(200, common.SuccessResponse(c, scheduleOutput(schedule)))
+	return
+Fail:
+	log.Error(err)
+	c.JSON(400, common.NewResponse(c, code, err.Error()))
+}
+
+// List ScheduleController godoc
+// @Summary 定时任务列表
+// @Tags 定时任务管理
+// @Accept json
+// @Produce json
+// @Param app_id query string false "Application ID"
+// @Success 200 {string} string ""
+// @Router /schedule/list [get]
+func (s *ScheduleController) List(c *gin.Context) {
+	var (
+		code      = common.Success
+		schedules []*dao.Schedule
+		outputs   dto.ListScheduleOutput
+		err       error
+	)
+	if schedules, err = dao.ListSchedule(c, c.Query("app_id")); err != nil {
+		code = common.ListScheduleFailed
+		err = errors.Wrap(err, code.GetMsg())
+		goto Fail
+	}
+	outputs = make(dto.ListScheduleOutput, len(schedules))
+	for k, schedule := range schedules {
+		outputs[k] = scheduleOutput(schedule)
+	}
+	c.JSON(200, common.SuccessResponse(c, outputs))
+	return
+Fail:
+	log.Error(err)
+	c.JSON(400, common.NewResponse(c, code, err.Error()))
+}
+
+// Create ScheduleController godoc
+// @Summary 创建定时任务
+// @Tags 定时任务管理
+// @Accept json
+// @Produce json
+// @Param input body dto.CreateScheduleInput true "定时任务"
+// @Param user query string false "操作人"
+// @Success 200 {string} string ""
+// @Router /schedule/create [post]
+func (s *ScheduleController) Create(c *gin.Context) {
+	var (
+		code     = common.Success
+		input    = dto.CreateScheduleInput{}
+		schedule = dao.Schedule{Ctx: c}
+		err      error
+	)
+	if err = c.ShouldBindJSON(&input); err != nil {
+		code = common.InvalidParam
+		err = errors.Wrap(err, code.GetMsg())
+		goto Fail
+	}
+	if _, err = scheduler.ParseCron(input.Cron); err != nil {
+		code = common.InvalidParam
+		err = errors.Wrap(err, code.GetMsg())
+		goto Fail
+	}
+	// 检查应用和参数是否有效
+	if _, err = dao.NewJobFromApplication(c, input.AppId, input.Parameters); err != nil {
+		code = common.InvalidParameters
+		err = errors.Wrap(err, code.GetMsg())
+		goto Fail
+	}
+
+	schedule.AppId = input.AppId
+	schedule.Cron = input.Cron
+	schedule.Parameters = input.Parameters
+	schedule.CreatedBy = operator(c)
+	schedule.CreatedAt = time.Now()
+	if err = schedule.Save(); err != nil {
+		code = common.SaveScheduleFailed
+		err = errors.Wrap(err, code.GetMsg())
+		goto Fail
+	}
+	log.Infof("schedule created: %+v", schedule)
+	c.JSON(200, common.SuccessResponse(c, scheduleOutput(&schedule)))
+	return
+Fail:
+	log.Error(err)
+	c.JSON(400, common.NewResponse(c, code, err.Error()))
+}
+
+// Pause ScheduleController godoc
+// @Summary 暂停定时任务
+// @Tags 定时任务管理
+// @Accept json
+// @Produce json
+// @Param id path string true "定时任务 ID"
+// @Success 200 {string} string ""
+// @Router /schedule/pause/{id} [post]
+func (s *ScheduleController) Pause(c *gin.Context) {
+	s.setPaused(c, true)
+}
+
+// Resume ScheduleController godoc
+// @Summary 恢复定时任务
+// @Tags 定时任务管理
+// @Accept json
+// @Produce json
+// @Param id path string true "定时任务 ID"
+// @Success 200 {string} string ""
+// @Router /schedule/resume/{id} [post]
+func (s *ScheduleController) Resume(c *gin.Context) {
+	s.setPaused(c, false)
+}
+
+func (s *ScheduleController) setPaused(c *gin.Context, paused bool) {
+	var (
+		code     = common.Success
+		schedule *dao.Schedule
+		err      error
+	)
+	if schedule, err = dao.GetSchedule(c, c.Param("id")); err != nil {
+		code = common.GetScheduleFailed
+		err = errors.Wrap(err, code.GetMsg())
+		goto Fail
+	}
+	schedule.Paused = paused
+	if !paused {
+		schedule.LastFire = time.Now() // 恢复后不补暂停期间错过的触发
+	}
+	if err = schedule.Save(); err != nil {
+		code = common.SaveScheduleFailed
+		err = errors.Wrap(err, code.GetMsg())
+		goto Fail
+	}
+	c.JSON(200, common.SuccessResponse(c, scheduleOutput(schedule)))
+	return
+Fail:
+	log.Error(err)
+	c.JSON(400, common.NewResponse(c, code, err.Error()))
+}
+
+// Delete ScheduleController godoc
+// @Summary 删除定时任务
+// @Tags 定时任务管理
+// @Accept json
+// @Produce json
+// @Param id path string true "定时任务 ID"
+// @Success 200 {string} string ""
+// @Router /schedule/delete/{id} [post]
+func (s *ScheduleController) Delete(c *gin.Context) {
+	var (
+		code     = common.Success
+		schedule = dao.Schedule{Ctx: c, Id: c.Param("id")}
+		err      error
+	)
+	if err = schedule.Delete(); err != nil {
+		code = common.DeleteScheduleFailed
+		err = errors.Wrap(err, code.GetMsg())
+		goto Fail
+	}
+	c.JSON(200, common.SuccessResponse(c, schedule.Id))
+	return
+Fail:
+	log.Error(err)
+	c.JSON(400, common.NewResponse(c, code, err.Error()))
+}
+
+func scheduleOutput(s *dao.Schedule) dto.ScheduleOutput {
+	out := dto.ScheduleOutput{
+		Id:            s.Id,
+		AppId:         s.AppId,
+		Cron:          s.Cron,
+		Parameters:    s.Parameters,
+		Paused:        s.Paused,
+		CreatedBy:     s.CreatedBy,
+		CreatedAt:     s.CreatedAt.Format(time.RFC3339),
+		LastJob:       s.LastJob,
+		LastError:     s.LastError,
+		NextFireTimes: []string{},
+	}
+	if !s.LastFire.IsZero() {
+		out.LastFire = s.LastFire.Format(time.RFC3339)
+	}
+	for _, t := range scheduler.NextFireTimes(s, nextFireCount) {
+		out.NextFireTimes = append(out.NextFireTimes, t.Format(time.RFC3339))
+	}
+	return out
+}
@@ -0,0 +1,305 @@
+package controller
+
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/pkg/errors"
+	"lyyops-cicd/dao"
+	"lyyops-cicd/dto"
+	"lyyops-cicd/pkg/common"
+	"lyyops-cicd/pkg/log"
+	"lyyops-cicd/pkg/webhook"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// 接收代码仓库的push/tag事件，按触发规则创建发布任务
+
+type WebhookController struct{}
+
+func WebhookControllerGroupRegistry(group *gin.RouterGroup) {
+	controller := WebhookController{}
+	group.POST("/github", controller.Github)
+	group.POST("/gitlab", controller.Gitlab)
+	group.POST("/gitee", controller.Gitee)
+
+	group.GET("/trigger/list", controller.ListTrigger)
+	group.POST("/trigger/create", controller.CreateTrigger)
+	group.POST("/trigger/delete/:id", controller.DeleteTrigger)
+
+	group.GET("/delivery/list", controller.ListDelivery)
+	group.GET("/delivery/:id", controller.GetDelivery)
+	group.POST("/delivery/replay/:id", controller.ReplayDelivery)
+}
+
+// Github WebhookController godoc
+// @Summary 接收GitHub push/tag事件
+// @Tags Webhook
+// @Accept json
+// @Produce json
+// @Param content body string true "GitHub webhook内容"
+// @Success 200 {string} string ""
+// @Router /webhook/github [post]
+func (w *WebhookController) Github(c *gin.Context) {
+	w.receive(c, webhook.ProviderGithub)
+}
+
+// Gitlab WebhookController godoc
+// @Summary 接收GitLab push/tag事件
+// @Tags Webhook
+// @Accept json
+// @Produce json
+// @Param content body string true "GitLab webhook内容"
+// @Success 200 {string} string ""
+// @Router /webhook/gitlab [post]
+func (w *WebhookController) Gitlab(c *gin.Context) {
+	w.receive(c, webhook.ProviderGitlab)
+}
+
+// Gitee WebhookController godoc
+// @Summary 接收Gitee push/tag事件
+// @Tags Webhook
+// @Accept json
+// @Produce json
+// @Param content body string true "Gitee webhook内容"
+// @Success 200 {string} string ""
+// @Router /webhook/gitee [post]
+func (w *WebhookController) Gitee(c *gin.Context) {
+	w.receive(c, webhook.ProviderGitee)
+}
+
+func (w *WebhookController) receive(c *gin.Context, provider string) {
+	var (
+		code     = common.Success
+		body     []byte
+		delivery *dao.WebhookDelivery
+		err      error
+	)
+	if body, err = c.GetRawData(); err != nil {
+		code = common.InvalidParam
+		err = errors.Wrap(err, "GetRawData")
+		goto Fail
+	}
+	if err = webhook.Verify(provider, c.Request.Header, body); err != nil {
+		code = common.WebhookVerifyFailed
+		err = errors.Wrap(err, code.GetMsg())
+		goto Fail
+	}
+	if delivery, err = webhook.Handle(c, provider, c.Request.Header, body, ""); err != nil {
+		code = common.WebhookHandleFailed
+		err = errors.Wrap(err, code.GetMsg())
+		goto Fail
+	}
+	delivery.Body = ""
+	c.JSON(200, common.SuccessResponse(c, delivery))
+	return
+Fail:
+	log.Error(err)
+	c.JSON(400, common.NewResponse(c, code, err.Error()))
+}
+
+// ListTrigger WebhookController godoc
+// @Summary webhook触发规则列表
+// @Tags Webhook
+// @Accept json
+// @Produce json
+// @Param app_id query string false "Application ID"
+// @Success 200 {string} string ""
+// @Router /webhook/trigger/list [get]
+func (w *WebhookController) ListTrigger(c *gin.Context) {
+	var (
+		code     = common.Success
+		triggers []*dao.WebhookTrigger
+		err      error
+	)
+	if triggers, err = dao.ListWebhookTrigger(c, c.Query("app_id")); err != nil {
+		code = common.ListWebhookTriggerFailed
+		err = errors.Wrap(err, code.GetMsg())
+		goto Fail
+	}
+	c.JSON(200, common.SuccessResponse(c, triggers))
+	return
+Fail:
+	log.Error(err)
+	c.JSON(400, common.NewResponse(c, code, err.Error()))
+}
+
+// CreateTrigger WebhookController godoc
+// @Summary 创建webhook触发规则
+// @Tags Webhook
+// @Accept json
+// @Produce json
+// @Param input body dto.CreateWebhookTriggerInput true "触发规则"
+// @Param user query string false "操作人"
+// @Success 200 {string} string ""
+// @Router /webhook/trigger/create [post]
+func (w *WebhookController) CreateTrigger(c *gin.Context) {
+	var (
+		code    = common.Success
+		input   = dto.CreateWebhookTriggerInput{}
+		trigger = dao.WebhookTrigger{Ctx: c}
+		err     error
+	)
+	if err = c.ShouldBindJSON(&input); err != nil {
+		code = common.InvalidParam
+		err = errors.Wrap(err, code.GetMsg())
+		goto Fail
+	}
+	if input.AppId == "" || input.RepoUrl == "" {
+		code = common.InvalidParam
+		err = errors.New("app_id和repo_url不能为空")
+		goto Fail
+	}
+	for _, e := range input.Events {
+		if e != webhook.EventPush && e != webhook.EventTag {
+			code = common.InvalidParam
+			err = errors.Errorf("不支持的事件: %s", e)
+			goto Fail
+		}
+	}
+	if _, err = dao.GetApplication(c, input.AppId); err != nil {
+		code = common.ApplicationNotFound
+		err = errors.Wrap(err, code.GetMsg())
+		goto Fail
+	}
+
+	trigger.AppId = input.AppId
+	trigger.RepoUrl = input.RepoUrl
+	trigger.Events = input.Events
+	trigger.Branches = input.Branches
+	trigger.Parameters = input.Parameters
+	trigger.BranchParam = input.BranchParam
+	trigger.CommitParam = input.CommitParam
+	trigger.CreatedBy = operator(c)
+	trigger.CreatedAt = time.Now()
+	if err = trigger.Save(); err != nil {
+		code = common.SaveWebhookTriggerFailed
+		err = errors.Wrap(err, code.GetMsg())
+		goto Fail
+	}
+	c.JSON(200, common.SuccessResponse(c, trigger))
+	return
+Fail:
+	log.Error(err)
+	c.JSON(400, common.NewResponse(c, code, err.Error()))
+}
+
+// DeleteTrigger WebhookController godoc
+// @Summary 删除webhook触发规则
+// @Tags Webhook
+// @Accept json
+// @Produce json
+// @Param id path string true "触发规则 ID"
+// @Success 200 {string} string ""
+// @Router /webhook/trigger/delete/{id} [post]
+func (w *WebhookController) DeleteTrigger(c *gin.Context) {
+	var (
+		code    = common.Success
+		trigger = dao.WebhookTrigger{Ctx: c, Id: c.Param("id")}
+		err     error
+	)
+	if err = trigger.Delete(); err != nil {
+		code = common.DeleteWebhookTriggerFailed
+		err = errors.Wrap(err, code.GetMsg())
+		goto Fail
+	}
+	c.JSON(200, common.SuccessResponse(c, trigger.Id))
+	return
+Fail:
+	log.Error(err)
+	c.JSON(400, common.NewResponse(c, code, err.Error()))
+}
+
+// ListDelivery WebhookController godoc
+// @Summary webhook接收记录列表
+// @Tags Webhook
+// @Accept json
+// @Produce json
+// @Param size query int false "数量"
+// @Success 200 {string} string ""
+// @Router /webhook/delivery/list [get]
+func (w *WebhookController) ListDelivery(c *gin.Context) {
+	var (
+		code       = common.Success
+		size       int
+		deliveries []*dao.WebhookDelivery
+		err        error
+	)
+	size, err = strconv.Atoi(c.Query("size"))
+	if err != nil || size <= 0 {
+		size = 100 // 默认值
+	}
+	if deliveries, err = dao.ListWebhookDelivery(c, size); err != nil {
+		code = common.ListWebhookDeliveryFailed
+		err = errors.Wrap(err, code.GetMsg())
+		goto Fail
+	}
+	c.JSON(200, common.SuccessResponse(c, deliveries))
+	return
+Fail:
+	log.Error(err)
+	c.JSON(400, common.NewResponse(c, code, err.Error()))
+}
+
+// GetDelivery WebhookController godoc
+// @Summary 获取webhook接收记录(包含请求内容)
+// @Tags Webhook
+// @Accept json
+// @Produce json
+// @Param id path string true "接收记录 ID"
+// @Success 200 {string} string ""
+// @Router /webhook/delivery/{id} [get]
+func (w *WebhookController) GetDelivery(c *gin.Context) {
+	var (
+		code     = common.Success
+		delivery *dao.WebhookDelivery
+		err      error
+	)
+	if delivery, err = dao.GetWebhookDelivery(c, c.Param("id")); err != nil {
+		code = common.GetWebhookDeliveryFailed
+		err = errors.Wrap(err, code.GetMsg())
+		goto Fail
+	}
+	c.JSON(200, common.SuccessResponse(c, delivery))
+	return
+Fail:
+	log.Error(err)
+	c.JSON(400, common.NewResponse(c, code, err.Error()))
+}
+
+// ReplayDelivery WebhookController godoc
+// @Summary 重放webhook接收记录(不校验签名)
+// @Tags Webhook
+// @Accept json
+// @Produce json
+// @Param id path string true "接收记录 ID"
+// @Success 200 {string} string ""
+// @Router /webhook/delivery/replay/{id} [post]
+func (w *WebhookController) ReplayDelivery(c *gin.Context) {
+	var (
+		code     = common.Success
+		origin   *dao.WebhookDelivery
+		delivery *dao.WebhookDelivery
+		header   = http.Header{}
+		err      error
+	)
+	if origin, err = dao.GetWebhookDelivery(c, c.Param("id")); err != nil {
+		code = common.GetWebhookDeliveryFailed
+		err = errors.Wrap(err, code.GetMsg())
+		goto Fail
+	}
+	for k, v := range origin.Headers {
+		header.Set(k, v)
+	}
+	if delivery, err = webhook.Handle(c, origin.Provider, header, []byte(origin.Body), origin.Id); err != nil {
+		code = common.WebhookHandleFailed
+		err = errors.Wrap(err, code.GetMsg())
+		goto Fail
+	}
+	delivery.Body = ""
+	c.JSON(200, common.SuccessResponse(c, delivery))
+	return
+Fail:
+	log.Error(err)
+	c.JSON(400, common.NewResponse(c, code, err.Error()))
+}
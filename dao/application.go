@@ -2,6 +2,8 @@ package dao
 
 import (
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"github.com/pkg/errors"
 	"lyyops-cicd/pkg/log"
 	"strings"
@@ -32,6 +34,15 @@ func ListApplication(ctx context.Context) ([]string, error) {
 	return apps, nil
 }
 
+// 应用内容的版本，取内容sha256的前12位
+func (a *Application) Revision() string {
+	if len(a.Content) == 0 {
+		return ""
+	}
+	sum := sha256.Sum256(a.Content)
+	return hex.EncodeToString(sum[:])[:12]
+}
+
 func (a *Application) Save() error {
 	return RedisClient.Set(a.Ctx, ApplicationKey(a.Id), a.Content, -1).Err()
 }
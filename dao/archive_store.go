@@ -0,0 +1,203 @@
+package dao
+
+// 归档存储: 本地目录或S3兼容的对象存储(签名使用AWS Signature V4)
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"github.com/pkg/errors"
+	"io/ioutil"
+	"lyyops-cicd/config"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+const (
+	ArchiveTypeLocal = "local"
+	ArchiveTypeS3    = "s3"
+)
+
+var errArchiveNotFound = errors.New("archive not found")
+
+type archiveStore interface {
+	Put(ctx context.Context, key string, data []byte) error
+	Get(ctx context.Context, key string) ([]byte, error)
+	Delete(ctx context.Context, key string) error
+}
+
+func newArchiveStore(conf config.Archive) (archiveStore, error) {
+	switch conf.Type {
+	case "", ArchiveTypeLocal:
+		if conf.Dir == "" {
+			return nil, errors.New("archive dir is not configured")
+		}
+		return localStore{dir: conf.Dir}, nil
+	case ArchiveTypeS3:
+		if conf.S3.Endpoint == "" || conf.S3.Bucket == "" {
+			return nil, errors.New("archive s3 endpoint or bucket is not configured")
+		}
+		return s3Store{conf: conf.S3, client: &http.Client{Timeout: time.Minute}}, nil
+	}
+	return nil, errors.Errorf("unknown archive type: %s", conf.Type)
+}
+
+// 本地目录，key为相对路径
+type localStore struct {
+	dir string
+}
+
+func (s localStore) path(key string) string {
+	return filepath.Join(s.dir, filepath.FromSlash(key))
+}
+
+// 先写临时文件再重命名，避免读到不完整的归档
+func (s localStore) Put(_ context.Context, key string, data []byte) error {
+	name := s.path(key)
+	if err := os.MkdirAll(filepath.Dir(name), 0755); err != nil {
+		return err
+	}
+	if err := ioutil.WriteFile(name+".tmp", data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(name+".tmp", name)
+}
+
+func (s localStore) Get(_ context.Context, key string) ([]byte, error) {
+	data, err := ioutil.ReadFile(s.path(key))
+	if os.IsNotExist(err) {
+		return nil, errArchiveNotFound
+	}
+	return data, err
+}
+
+func (s localStore) Delete(_ context.Context, key string) error {
+	if err := os.Remove(s.path(key)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// S3兼容的对象存储，使用path-style访问: endpoint/bucket/prefix/key
+type s3Store struct {
+	conf   config.S3
+	client *http.Client
+}
+
+func (s s3Store) Put(ctx context.Context, key string, data []byte) error {
+	_, err := s.do(ctx, http.MethodPut, key, data)
+	return err
+}
+
+func (s s3Store) Get(ctx context.Context, key string) ([]byte, error) {
+	return s.do(ctx, http.MethodGet, key, nil)
+}
+
+func (s s3Store) Delete(ctx context.Context, key string) error {
+	_, err := s.do(ctx, http.MethodDelete, key, nil)
+	if err == errArchiveNotFound {
+		return nil
+	}
+	return err
+}
+
+func (s s3Store) do(ctx context.Context, method, key string, body []byte) ([]byte, error) {
+	endpoint, err := url.Parse(s.conf.Endpoint)
+	if err != nil {
+		return nil, errors.Wrap(err, "s3 endpoint")
+	}
+	endpoint.Path = "/" + path.Join(s.conf.Bucket, s.conf.Prefix, key)
+	req, err := http.NewRequestWithContext(ctx, method, endpoint.String(), bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	s.sign(req, body, time.Now().UTC())
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, errors.Wrapf(err, "s3 %s %s", method, key)
+	}
+	defer resp.Body.Close()
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, errArchiveNotFound
+	}
+	if resp.StatusCode >= 300 {
+		return nil, errors.Errorf("s3 %s %s: %s %s", method, key, resp.Status, data)
+	}
+	return data, nil
+}
+
+// AWS Signature V4，签名host、x-amz-content-sha256和x-amz-date
+func (s s3Store) sign(req *http.Request, body []byte, now time.Time) {
+	var (
+		region      = s.conf.Region
+		amzDate     = now.Format("20060102T150405Z")
+		date        = now.Format("20060102")
+		payloadHash = sha256Hex(body)
+	)
+	if region == "" {
+		region = "us-east-1"
+	}
+	req.Header.Set("x-amz-date", amzDate)
+	req.Header.Set("x-amz-content-sha256", payloadHash)
+	if s.conf.AccessKey == "" {
+		return // 匿名访问
+	}
+
+	signedHeaders := "host;x-amz-content-sha256;x-amz-date"
+	canonical := strings.Join([]string{
+		req.Method,
+		s3EscapePath(req.URL.Path),
+		req.URL.RawQuery,
+		fmt.Sprintf("host:%s\nx-amz-content-sha256:%s\nx-amz-date:%s\n", req.URL.Host, payloadHash, amzDate),
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+	scope := strings.Join([]string{date, region, "s3", "aws4_request"}, "/")
+	toSign := strings.Join([]string{"AWS4-HMAC-SHA256", amzDate, scope, sha256Hex([]byte(canonical))}, "\n")
+
+	key := hmacSHA256([]byte("AWS4"+s.conf.SecretKey), date)
+	for _, v := range []string{region, "s3", "aws4_request"} {
+		key = hmacSHA256(key, v)
+	}
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		s.conf.AccessKey, scope, signedHeaders, hex.EncodeToString(hmacSHA256(key, toSign))))
+}
+
+// 按S3的规则编码路径，只保留非保留字符和'/'
+func s3EscapePath(p string) string {
+	var b strings.Builder
+	for i := 0; i < len(p); i++ {
+		c := p[i]
+		if 'A' <= c && c <= 'Z' || 'a' <= c && c <= 'z' || '0' <= c && c <= '9' ||
+			c == '-' || c == '_' || c == '.' || c == '~' || c == '/' {
+			b.WriteByte(c)
+		} else {
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
+
+func sha256Hex(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(data))
+	return h.Sum(nil)
+}
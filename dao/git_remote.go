@@ -0,0 +1,97 @@
+package dao
+
+// 提交任务时把分支或tag解析成commit sha: 读取git smart HTTP协议的引用列表(与git ls-remote相同)
+// 只支持http(s)仓库地址，私有仓库需要在地址中带上认证信息
+
+import (
+	"bufio"
+	"context"
+	"encoding/hex"
+	"github.com/pkg/errors"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const gitRemoteTimeout = 10 * time.Second
+
+// 是否是完整的commit sha(sha1或sha256)
+func isCommitSha(s string) bool {
+	if len(s) != 40 && len(s) != 64 {
+		return false
+	}
+	_, err := hex.DecodeString(s)
+	return err == nil
+}
+
+// 远程仓库中分支或tag指向的commit，annotated tag返回其指向的commit
+func lsRemote(ctx context.Context, repoUrl, ref string) (string, error) {
+	if !strings.HasPrefix(repoUrl, "http://") && !strings.HasPrefix(repoUrl, "https://") {
+		return "", errors.Errorf("只支持http(s)仓库地址: %s", repoUrl)
+	}
+	ctx, cancel := context.WithTimeout(ctx, gitRemoteTimeout)
+	defer cancel()
+	u := strings.TrimSuffix(repoUrl, "/") + "/info/refs?service=git-upload-pack"
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return "", err
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return "", errors.Wrap(err, "git ls-remote")
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", errors.Errorf("git ls-remote: %s", resp.Status)
+	}
+	refs, err := parseAdvertisedRefs(resp.Body)
+	if err != nil {
+		return "", err
+	}
+	for _, name := range []string{"refs/tags/" + ref + "^{}", "refs/tags/" + ref, "refs/heads/" + ref, ref} {
+		if sha, ok := refs[name]; ok {
+			return sha, nil
+		}
+	}
+	return "", errors.Errorf("仓库中没有 %s", ref)
+}
+
+// 解析pkt-line格式的引用列表，返回引用名到sha的映射
+func parseAdvertisedRefs(r io.Reader) (map[string]string, error) {
+	var (
+		refs = map[string]string{}
+		br   = bufio.NewReader(r)
+	)
+	for {
+		var size [4]byte
+		if _, err := io.ReadFull(br, size[:]); err != nil {
+			if err == io.EOF {
+				return refs, nil
+			}
+			return nil, errors.Wrap(err, "git ls-remote")
+		}
+		n, err := strconv.ParseUint(string(size[:]), 16, 16)
+		if err != nil {
+			return nil, errors.Errorf("git ls-remote: 非法的pkt-line %q", size)
+		}
+		if n < 4 {
+			continue // flush-pkt
+		}
+		line := make([]byte, n-4)
+		if _, err := io.ReadFull(br, line); err != nil {
+			return nil, errors.Wrap(err, "git ls-remote")
+		}
+		text := strings.TrimSuffix(string(line), "\n")
+		if strings.HasPrefix(text, "#") {
+			continue // # service=git-upload-pack
+		}
+		if i := strings.IndexByte(text, 0); i >= 0 {
+			text = text[:i] // 第一行之后是服务端支持的功能
+		}
+		if parts := strings.SplitN(text, " ", 2); len(parts) == 2 && isCommitSha(parts[0]) {
+			refs[parts[1]] = parts[0]
+		}
+	}
+}
@@ -0,0 +1,67 @@
+package dao
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+const (
+	testMainSha = "7e1c3f2a9b8d4e5f6a7b8c9d0e1f2a3b4c5d6e7f"
+	testTagSha  = "1111111111111111111111111111111111111111"
+	testPeeled  = "2222222222222222222222222222222222222222"
+)
+
+func pktLine(s string) string {
+	return fmt.Sprintf("%04x%s", len(s)+4, s)
+}
+
+func TestLsRemote(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/org/app.git/info/refs" || r.URL.Query().Get("service") != "git-upload-pack" {
+			http.NotFound(w, r)
+			return
+		}
+		fmt.Fprint(w, pktLine("# service=git-upload-pack\n"), "0000",
+			pktLine(testMainSha+" HEAD\x00multi_ack side-band-64k\n"),
+			pktLine(testMainSha+" refs/heads/main\n"),
+			pktLine(testTagSha+" refs/tags/v1.0\n"),
+			pktLine(testPeeled+" refs/tags/v1.0^{}\n"),
+			"0000")
+	}))
+	defer srv.Close()
+
+	for ref, want := range map[string]string{"main": testMainSha, "v1.0": testPeeled, "HEAD": testMainSha} {
+		got, err := lsRemote(context.Background(), srv.URL+"/org/app.git", ref)
+		if err != nil || got != want {
+			t.Errorf("lsRemote(%s) = %s, %v, want %s", ref, got, err, want)
+		}
+	}
+	if _, err := lsRemote(context.Background(), srv.URL+"/org/app.git", "missing"); err == nil {
+		t.Error("expected error for missing ref")
+	}
+	if _, err := lsRemote(context.Background(), "git@example.com:org/app.git", "main"); err == nil || !strings.Contains(err.Error(), "http") {
+		t.Errorf("ssh url error = %v", err)
+	}
+}
+
+func TestSetSource(t *testing.T) {
+	j := &Job{Parameters: map[string]string{ParamBranch: "main", ParamCommit: "main"}}
+	j.setSource()
+	if j.Branch != "main" || j.Commit != "" {
+		t.Errorf("branch = %s, commit = %s", j.Branch, j.Commit)
+	}
+	j = &Job{Parameters: map[string]string{ParamCommit: testMainSha}}
+	j.setSource()
+	if j.Commit != testMainSha {
+		t.Errorf("commit = %s", j.Commit)
+	}
+	j = &Job{Tag: "v1.0.0", Parameters: map[string]string{ParamBranch: "v1.0.0"}}
+	j.setSource()
+	if j.Branch != "" {
+		t.Errorf("tag job branch = %s", j.Branch)
+	}
+}
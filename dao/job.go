@@ -2,7 +2,6 @@ package dao
 
 import (
 	"context"
-	"encoding/json"
 	"github.com/argoproj/argo-workflows/v3/pkg/apiclient/workflow"
 	wfv1 "github.com/argoproj/argo-workflows/v3/pkg/apis/workflow/v1alpha1"
 	"github.com/argoproj/argo-workflows/v3/util"
@@ -17,23 +16,47 @@ import (
 	"lyyops-cicd/config"
 	common2 "lyyops-cicd/pkg/common"
 	"lyyops-cicd/pkg/log"
-	"sort"
-	"strings"
+	"sync"
 	"time"
 )
 
 const DefaultCostDuration = time.Second
 
 type Job struct {
-	Ctx        context.Context            `json:"-"`
-	Id         string                     `json:"id"`
-	StartTime  time.Time                  `json:"start_time"`
-	EndTime    time.Time                  `json:"end_time"`
-	Cost       string                     `json:"cost"`
-	Status     string                     `json:"status"`
-	Workflow   *wfv1.Workflow             `json:"-"`
-	PhaseNames []string                   `json:"phase_names"`
-	Phases     map[string]*JobPhaseStatus `json:"phases"`
+	Ctx          context.Context            `json:"-"`
+	Id           string                     `json:"id"`
+	StartTime    time.Time                  `json:"start_time"`
+	EndTime      time.Time                  `json:"end_time"`
+	Cost         string                     `json:"cost"`
+	Status       string                     `json:"status"`
+	CancelledBy  string                     `json:"cancelled_by"`
+	CancelledAt  time.Time                  `json:"cancelled_at"`
+	AppId        string                     `json:"app_id"`
+	WorkflowName string                     `json:"workflow"`
+	Parameters   map[string]string          `json:"parameters"`
+	Overrides    map[string]string          `json:"overrides"`     // 调用方传入的参数，重新提交时只使用这些参数
+	OriginJob    string                     `json:"origin_job"`    // 重新提交/重试的原任务
+	OriginAction string                     `json:"origin_action"` // resubmit, retry
+	RetryCount   int                        `json:"retry_count"`
+	ScheduleId   string                     `json:"schedule_id"`   // 触发任务的定时任务
+	Trigger      string                     `json:"trigger"`       // 触发方式: api, webhook, schedule, retry, resubmit
+	CreatedBy    string                     `json:"created_by"`    // 调用者: api的操作人、webhook的推送人、定时任务的创建人
+	Commit       string                     `json:"commit"`        // 提交时解析的代码commit sha，无法解析时为空
+	Branch       string                     `json:"branch"`        // 代码分支
+	Tag          string                     `json:"tag"`           // 推送tag触发的任务，分支为空
+	Revision     string                     `json:"revision"`      // 创建任务时应用内容的版本
+	ApprovalGate string                     `json:"approval_gate"` // 等待审批的节点
+	LogTime      time.Time                  `json:"log_time"`      // 最后一次接收日志的服务端时间，恢复采集使用pod中的日志时间
+	Redactions   int                        `json:"redactions"`    // 日志中隐藏敏感内容的次数
+	ArchivedAt   time.Time                  `json:"archived_at"`   // 日志归档的时间
+	LogsDoneAt   time.Time                  `json:"logs_done_at"`  // 任务结束后日志采集完成的时间
+	Workflow     *wfv1.Workflow             `json:"-"`
+	PhaseNames   []string                   `json:"phase_names"`
+	Phases       map[string]*JobPhaseStatus `json:"phases"`
+	lastEvent    string                     // 最后发布的事件，用于去重
+	notified     string                     // 最后通知的状态变化
+	redact       *redactor                  // 日志、阶段信息、事件和通知的脱敏规则
+	unwatch      func()                     // 释放监听锁
 }
 
 func (j Job) Validate() error {
@@ -69,6 +92,7 @@ func (j *Job) Create() error {
 	}
 
 	j.Id = created.GetName() // workflow name
+	j.WorkflowName = j.Id
 	log.Debugf("namespace: %s, name: %s, serviceaccount: %s", created.GetNamespace(), j.Id, j.Workflow.Spec.ServiceAccountName)
 
 	// 创建任务
@@ -76,11 +100,39 @@ func (j *Job) Create() error {
 		return err
 	}
 
-	go waitWatchOrLog(ctx, svcCli, inReq.Namespace, created.Name, j, true, true)
-
+	j.startWatch(ctx, svcCli)
 	return nil
 }
 
+// 根据应用的workflow和参数生成任务
+func NewJobFromApplication(ctx context.Context, appId string, params map[string]string) (*Job, error) {
+	app, err := GetApplication(ctx, appId)
+	if err != nil {
+		return nil, errors.Wrapf(err, "GetApplication %s", appId)
+	}
+	wf, err := UnmarshalWorkflow(app.Content)
+	if err != nil {
+		return nil, err
+	}
+	resolved, err := ResolveParameters(wf, params)
+	if err != nil {
+		return nil, err
+	}
+	job := &Job{Ctx: ctx, AppId: appId, Workflow: wf, Parameters: resolved, Overrides: params, Revision: app.Revision()}
+	job.PhaseNames = job.GetPhaseNames()
+	return job, nil
+}
+
+// 后台监听workflow状态和日志，已有其他进程在监听时返回false
+func (j *Job) startWatch(ctx context.Context, svcCli workflow.WorkflowServiceClient) bool {
+	j.Ctx = ctx // 后台goroutine不能使用请求的context
+	if !j.acquireWatch() {
+		return false
+	}
+	go waitWatchOrLog(ctx, svcCli, getNamespace(ctx), j.workflowName(), j, true, true)
+	return true
+}
+
 // 任务耗时
 func (j *Job) setCost() {
 	dur := j.EndTime.Sub(j.StartTime)
@@ -91,20 +143,53 @@ func (j *Job) setCost() {
 }
 
 func waitWatchOrLog(ctx context.Context, serviceClient workflow.WorkflowServiceClient, namespace string, workflowName string, job *Job, ignoreNotFound, saveLog bool) {
+	var logs sync.WaitGroup // 日志采集的goroutine
 	defer func() {
+		job.releaseWatch()
 		job.EndTime = time.Now() // 更新job结束时间
+		job.loadCancelled()      // 已被取消的任务保持Cancelled状态
 		log.Debugf("start save job status: %+v", job)
 		if err := job.statusSave(); err != nil {
 			log.Errorf("save job status: %+v", job)
 		} // 结束后更新job状态
-		ctx.Done()
+		job.notifyStatus()
+		if IsJobFinished(job.Status) {
+			job.publishEvent(JobEventEnd)
+			job.recordMetrics()  // 交付效能指标
+			job.afterLogs(&logs) // 日志采集结束后记录完成并归档
+		}
+		if err := DispatchQueue(context.Background()); err != nil {
+			log.Errorf("dispatch job queue: %+v", err)
+		} // 启动排队中的任务
 	}()
 
-	go logWorkflow(ctx, serviceClient, job, namespace, workflowName, "", &corev1.PodLogOptions{
-		Container: common.MainContainerName,
-		Follow:    true,
-		Previous:  false,
-	})
+	logOptions := &corev1.PodLogOptions{
+		Container:  common.MainContainerName,
+		Follow:     true,
+		Previous:   false,
+		Timestamps: true, // 使用pod输出日志的时间
+	}
+	wf := job.Workflow
+	if wf == nil {
+		// 恢复监听时没有workflow，从argo获取引用的secret
+		if got, err := serviceClient.GetWorkflow(ctx, &workflow.WorkflowGetRequest{Name: workflowName, Namespace: namespace}); err != nil {
+			log.Warningf("workflow[%s] get for redact: %v", job.Id, err)
+		} else {
+			wf = got
+		}
+	}
+	redact := newRedactor(ctx, job, wf)
+	job.redact = redact
+	for _, container := range LogContainers(job.AppId) {
+		opts := *logOptions
+		opts.Container = container // 每个容器单独采集
+		opts.SinceTime = job.logSinceTime(ctx, container)
+		logs.Add(1)
+		go func() {
+			defer logs.Done()
+			logWorkflow(ctx, serviceClient, job, namespace, workflowName, "", &opts, redact)
+		}()
+	}
 
 	req := &workflow.WatchWorkflowsRequest{
 		Namespace: namespace,
@@ -127,7 +212,6 @@ func waitWatchOrLog(ctx context.Context, serviceClient workflow.WorkflowServiceC
 	log.Debugf("workflow[%s] start watch stream......", job.Id)
 	for {
 		event, err := stream.Recv()
-		log.Debugf("recv job event: %s", event.Object.Name)
 		if err == io.EOF {
 			log.Debugf("Re-establishing workflow[%s] watch", job.Id)
 			stream, err = serviceClient.WatchWorkflows(ctx, req)
@@ -145,6 +229,7 @@ func waitWatchOrLog(ctx context.Context, serviceClient workflow.WorkflowServiceC
 		if event == nil {
 			continue
 		}
+		log.Debugf("recv job event: %s", event.Object.Name)
 
 		eventWf := event.Object
 		log.Debugf("event workflow: %s", common2.ParseJsonStr(eventWf))
@@ -154,7 +239,13 @@ func waitWatchOrLog(ctx context.Context, serviceClient workflow.WorkflowServiceC
 			continue
 		}
 
-		job.Status = string(eventWf.Status.Phase) // 更新job状态
+		// 更新job状态，等待审批等中间状态立即保存
+		if status, gate := workflowStatus(eventWf), approvalGate(eventWf); status != job.Status || gate != job.ApprovalGate {
+			job.Status, job.ApprovalGate = status, gate
+			job.saveStatus()
+		}
+		job.publishEvent(JobEventStatus)
+		job.notifyStatus()
 		log.Infof("job status phase save: %s", common2.ParseJsonStr(job))
 
 		// 完成后退出
@@ -172,49 +263,13 @@ func (j *Job) Delete() error {
 	if err := j.deletePhase(); err != nil {
 		return errors.Wrap(err, "job.deletePhase")
 	}
-	return nil
-}
-
-// 发布任务列表
-func ListJob(ctx context.Context, keyword string, size int) ([]*Job, error) {
-	var jobs Jobs
-
-	jobNames, err := RedisClient.Keys(ctx, JobStatusKey("*")).Result()
-	if err != nil {
-		err = errors.Wrap(err, "ListJob")
-		return jobs, err
-	}
-	if len(jobNames) == 0 {
-		return jobs, nil
-	}
-
-	for _, name := range jobNames {
-		objMap, err := RedisClient.HGetAll(ctx, name).Result()
-		if err != nil {
-			continue
-		}
-
-		// 过滤搜索关键字
-		if keyword != "" {
-			if !strings.Contains(strings.ToLower(name), strings.ToLower(keyword)) {
-				continue
-			}
-		}
-
-		log.Debugf("fetch job %s map: %+v", name, objMap)
-		obj := Job{Id: ExtractJobStatusName(name)}
-		bs, _ := json.Marshal(objMap)
-		json.Unmarshal(bs, &obj)
-		jobs = append(jobs, &obj)
+	if err := j.deleteEvents(); err != nil {
+		return errors.Wrap(err, "job.deleteEvents")
 	}
-
-	// 排序
-	sort.Sort(jobs)
-	if size > len(jobs) {
-		size = len(jobs)
+	if err := j.deleteApprovals(); err != nil {
+		return errors.Wrap(err, "job.deleteApprovals")
 	}
-	log.Infof("jobs list: %+v", jobs[:size])
-	return jobs[:size], nil
+	return nil
 }
 
 type Jobs []*Job
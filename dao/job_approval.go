@@ -0,0 +1,180 @@
+package dao
+
+// 人工审批: workflow运行到没有设置duration的suspend节点时，任务状态为AwaitingApproval
+// 审批通过时恢复workflow，拒绝时停止workflow，审批记录保存在任务上
+// 等待审批的任务不占用并发数，审批通过后重新计入，此时可能暂时超过并发上限
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/argoproj/argo-workflows/v3/pkg/apiclient/workflow"
+	wfv1 "github.com/argoproj/argo-workflows/v3/pkg/apis/workflow/v1alpha1"
+	"github.com/pkg/errors"
+	"k8s.io/apimachinery/pkg/fields"
+	"lyyops-cicd/pkg/common"
+	"lyyops-cicd/pkg/log"
+	"sort"
+	"strings"
+	"time"
+)
+
+const JobStatusAwaitingApproval = "AwaitingApproval"
+
+const (
+	ApprovalApproved = "approved"
+	ApprovalRejected = "rejected"
+)
+
+// 一次审批记录
+type JobApproval struct {
+	Gate     string    `json:"gate"`
+	Action   string    `json:"action"` // approved, rejected
+	Approver string    `json:"approver"`
+	Comment  string    `json:"comment"`
+	Time     time.Time `json:"time"`
+}
+
+// 等待审批的节点名，多个节点同时等待时返回最早开始的节点，没有时返回空
+// 设置了duration的suspend节点会自动恢复，不是审批节点
+func approvalGate(wf *wfv1.Workflow) string {
+	if wf == nil || wf.Status.Phase != wfv1.WorkflowRunning {
+		return ""
+	}
+	var gates []wfv1.NodeStatus
+	for _, node := range wf.Status.Nodes {
+		if !node.IsActiveSuspendNode() {
+			continue
+		}
+		if tmpl := wf.GetTemplateByName(node.TemplateName); tmpl != nil && tmpl.Suspend != nil && tmpl.Suspend.Duration != "" {
+			continue
+		}
+		gates = append(gates, node)
+	}
+	if len(gates) == 0 {
+		return ""
+	}
+	sort.Slice(gates, func(i, j int) bool {
+		return gates[i].StartedAt.Before(&gates[j].StartedAt)
+	})
+	return gates[0].DisplayName
+}
+
+// 审批通过，恢复workflow
+func (j *Job) Approve(gate, user, comment string) error {
+	return j.approval(ApprovalApproved, gate, user, comment)
+}
+
+// 审批拒绝，停止workflow
+func (j *Job) Reject(gate, user, comment string) error {
+	return j.approval(ApprovalRejected, gate, user, comment)
+}
+
+func (j *Job) approval(action, gate, user, comment string) error {
+	hres, err := RedisClient.HGetAll(j.Ctx, JobStatusKey(j.Id)).Result()
+	if err != nil {
+		return err
+	}
+	if len(hres) == 0 {
+		return errors.Errorf("任务 %s 不存在", j.Id)
+	}
+	if err = j.loadStatus(hres); err != nil {
+		return err
+	}
+	if j.Status != JobStatusAwaitingApproval {
+		return errors.Errorf("任务 %s 不在等待审批: %s", j.Id, j.Status)
+	}
+	if gate == "" {
+		gate = j.ApprovalGate
+	}
+	if gate != j.ApprovalGate {
+		return errors.Errorf("任务 %s 等待审批的节点是 %s，不是 %s", j.Id, j.ApprovalGate, gate)
+	}
+
+	ctx, cli, err := NewArgoClient()
+	if err != nil {
+		return err
+	}
+	svcCli := cli.NewWorkflowServiceClient()
+	selector := approvalSelector(gate)
+	if action == ApprovalApproved {
+		_, err = svcCli.ResumeWorkflow(ctx, &workflow.WorkflowResumeRequest{
+			Name:              j.workflowName(),
+			Namespace:         getNamespace(ctx),
+			NodeFieldSelector: selector,
+		})
+		err = errors.Wrap(err, "svcCli.ResumeWorkflow")
+	} else {
+		message := "rejected by " + user
+		if comment != "" {
+			message += ": " + comment
+		}
+		_, err = svcCli.StopWorkflow(ctx, &workflow.WorkflowStopRequest{
+			Name:              j.workflowName(),
+			Namespace:         getNamespace(ctx),
+			NodeFieldSelector: selector,
+			Message:           message,
+		})
+		err = errors.Wrap(err, "svcCli.StopWorkflow")
+	}
+	if err != nil {
+		return err
+	}
+
+	approval := JobApproval{Gate: gate, Action: action, Approver: user, Comment: comment, Time: time.Now()}
+	pipe := RedisClient.Pipeline()
+	pipe.RPush(j.Ctx, JobApprovalKey(j.Id), common.ParseJsonStr(approval))
+	pipe.Expire(j.Ctx, JobApprovalKey(j.Id), defaultExpired)
+	if _, err := pipe.Exec(j.Ctx); err != nil {
+		return err
+	}
+	log.Infof("job[%s] gate %s %s by %s", j.Id, gate, action, user)
+	return nil
+}
+
+// 按节点名选择审批节点，节点名中的特殊字符需要转义
+func approvalSelector(gate string) string {
+	return "displayName=" + fields.EscapeValue(gate)
+}
+
+// 任务的审批记录，按时间排序
+func GetJobApprovals(ctx context.Context, id string) ([]JobApproval, error) {
+	var approvals = []JobApproval{}
+	res, err := RedisClient.LRange(ctx, JobApprovalKey(id), 0, -1).Result()
+	if err != nil {
+		return nil, err
+	}
+	for _, data := range res {
+		a := JobApproval{}
+		if err := json.Unmarshal([]byte(data), &a); err != nil {
+			continue
+		}
+		approvals = append(approvals, a)
+	}
+	return approvals, nil
+}
+
+// 运行中的状态变化(如等待审批)立即保存，结束时间和耗时在watch结束后保存
+func (j *Job) saveStatus() {
+	if err := RedisClient.HMSet(j.Ctx, JobStatusKey(j.Id),
+		"status", j.Status,
+		"approval_gate", j.ApprovalGate,
+	).Err(); err != nil {
+		log.Errorf("job[%s] save status: %v", j.Id, err)
+		return
+	}
+	j.updateActive()
+	j.updateIndex()
+	if j.Status == JobStatusAwaitingApproval {
+		if err := DispatchQueue(context.Background()); err != nil {
+			log.Errorf("dispatch job queue: %+v", err)
+		} // 等待审批时释放并发数，启动排队中的任务
+	}
+}
+
+func (j *Job) deleteApprovals() error {
+	return RedisClient.Del(j.Ctx, JobApprovalKey(j.Id)).Err()
+}
+
+func JobApprovalKey(id string) string {
+	return strings.Join([]string{"cicd", "job-approval", id}, sep)
+}
@@ -0,0 +1,21 @@
+package dao
+
+import (
+	"k8s.io/apimachinery/pkg/fields"
+	"testing"
+)
+
+func TestApprovalSelector(t *testing.T) {
+	for _, gate := range []string{"approve-production", "approve,displayName=deploy", "a=b!c\\d"} {
+		selector, err := fields.ParseSelector(approvalSelector(gate))
+		if err != nil {
+			t.Fatalf("%s: %v", gate, err)
+		}
+		if !selector.Matches(fields.Set{"displayName": gate}) {
+			t.Errorf("%s: selector %s does not match", gate, selector)
+		}
+		if selector.Matches(fields.Set{"displayName": "deploy"}) {
+			t.Errorf("%s: selector %s matches other node", gate, selector)
+		}
+	}
+}
@@ -0,0 +1,93 @@
+package dao
+
+import (
+	"github.com/argoproj/argo-workflows/v3/pkg/apiclient/workflow"
+	"github.com/pkg/errors"
+	"lyyops-cicd/pkg/log"
+	"time"
+)
+
+const (
+	JobCancelStop      = "stop"      // 停止任务，会执行exit handler
+	JobCancelTerminate = "terminate" // 立即终止任务
+)
+
+// 取消任务: 停止argo workflow，并记录取消人和时间
+func (j *Job) Cancel(strategy, user string) error {
+	hres, err := RedisClient.HGetAll(j.Ctx, JobStatusKey(j.Id)).Result()
+	if err != nil {
+		return err
+	}
+	if len(hres) == 0 {
+		return errors.Errorf("任务 %s 不存在", j.Id)
+	}
+	if IsJobFinished(hres["status"]) {
+		return errors.Errorf("任务 %s 已结束: %s", j.Id, hres["status"])
+	}
+	if err = j.loadStatus(hres); err != nil {
+		return err
+	}
+	if j.Status == JobStatusQueued {
+		j.dequeue() // 排队中的任务还没有创建workflow
+		return j.saveCancelled(user)
+	}
+
+	ctx, cli, err := NewArgoClient()
+	if err != nil {
+		return err
+	}
+	svcCli := cli.NewWorkflowServiceClient()
+
+	switch strategy {
+	case JobCancelStop:
+		_, err = svcCli.StopWorkflow(ctx, &workflow.WorkflowStopRequest{
+			Name:      j.workflowName(),
+			Namespace: getNamespace(ctx),
+			Message:   "cancelled by " + user,
+		})
+		err = errors.Wrap(err, "svcCli.StopWorkflow")
+	case JobCancelTerminate:
+		_, err = svcCli.TerminateWorkflow(ctx, &workflow.WorkflowTerminateRequest{
+			Name:      j.workflowName(),
+			Namespace: getNamespace(ctx),
+		})
+		err = errors.Wrap(err, "svcCli.TerminateWorkflow")
+	default:
+		err = errors.Errorf("不支持的取消方式: %s", strategy)
+	}
+	if err != nil {
+		return err
+	}
+
+	log.Infof("job[%s] %s by %s", j.Id, strategy, user)
+	return j.saveCancelled(user)
+}
+
+// 记录取消人和时间，结束时间先设为取消时间，watch结束后会再次更新
+func (j *Job) saveCancelled(user string) error {
+	j.Status = JobStatusCancelled
+	j.CancelledBy = user
+	j.CancelledAt = time.Now()
+	if err := RedisClient.HMSet(j.Ctx, JobStatusKey(j.Id),
+		"status", j.Status,
+		"cancelled_by", j.CancelledBy,
+		"cancelled_at", j.CancelledAt.Format(time.RFC3339),
+		"end_time", j.CancelledAt,
+	).Err(); err != nil {
+		return err
+	}
+	j.updateActive()
+	j.updateIndex()
+	return nil
+}
+
+// 从DB读取取消信息，已取消的任务状态保持为Cancelled
+func (j *Job) loadCancelled() {
+	res, err := RedisClient.HMGet(j.Ctx, JobStatusKey(j.Id), "cancelled_by", "cancelled_at").Result()
+	if err != nil || res[1] == nil {
+		return
+	}
+	j.CancelledBy, _ = res[0].(string)
+	j.CancelledAt, _ = time.Parse(time.RFC3339, res[1].(string))
+	j.Status = JobStatusCancelled
+}
@@ -0,0 +1,149 @@
+package dao
+
+// 任务事件: watch收到的状态和阶段变化写入redis stream，多个副本的订阅者都可以读取，
+// stream的id作为事件id，断线重连时从上次的id继续读取
+
+import (
+	"context"
+	"github.com/go-redis/redis/v8"
+	"github.com/pkg/errors"
+	"lyyops-cicd/dto"
+	"lyyops-cicd/pkg/common"
+	"lyyops-cicd/pkg/log"
+	"strings"
+	"time"
+)
+
+const (
+	JobEventStatus = "status" // 任务状态或阶段变化
+	JobEventEnd    = "end"    // 任务已结束，之后不会再有事件
+)
+
+const (
+	jobEventMaxLen = 1000
+	jobEventTTL    = 24 * time.Hour
+)
+
+type JobEvent struct {
+	Id   string // stream id，快照事件为空
+	Type string
+	Data string // dto.GetJobOutput 的json
+}
+
+// 发布任务事件，状态和阶段都没有变化时不发布
+func (j *Job) publishEvent(typ string) {
+	phases := phaseTree(j.Phases)
+	out := dto.GetJobOutput{
+		Id:           j.Id,
+		AppId:        j.AppId,
+		Status:       j.Status,
+		ApprovalGate: j.ApprovalGate,
+		Parameters:   RedactParameters(j.Parameters),
+		PhaseList:    phases,
+	}
+	out.RunningPhases, out.FailedPhase, out.FailedMessage = phaseSummaryOutput(phases)
+	data := common.ParseJsonStr(out)
+	if typ == JobEventStatus && data == j.lastEvent {
+		return
+	}
+	j.lastEvent = data
+
+	end := j.EndTime
+	if end.IsZero() || typ != JobEventEnd {
+		end = time.Now()
+	}
+	out.Cost = end.Sub(j.StartTime).Truncate(time.Second).String()
+	if err := RedisClient.XAdd(j.Ctx, &redis.XAddArgs{
+		Stream: JobEventKey(j.Id),
+		MaxLen: jobEventMaxLen,
+		Approx: true,
+		Values: map[string]interface{}{"type": typ, "data": common.ParseJsonStr(out)},
+	}).Err(); err != nil {
+		log.Errorf("job[%s] publish event: %v", j.Id, err)
+		return
+	}
+	RedisClient.Expire(j.Ctx, JobEventKey(j.Id), jobEventTTL)
+}
+
+// 读取lastId之后的事件，没有新事件时最多阻塞block，超时返回空列表
+func ReadJobEvents(ctx context.Context, id, lastId string, block time.Duration) ([]JobEvent, error) {
+	if lastId == "" {
+		lastId = "0"
+	}
+	res, err := RedisClient.XRead(ctx, &redis.XReadArgs{
+		Streams: []string{JobEventKey(id), lastId},
+		Count:   100,
+		Block:   block,
+	}).Result()
+	if err == redis.Nil {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "ReadJobEvents")
+	}
+	var events []JobEvent
+	for _, stream := range res {
+		for _, msg := range stream.Messages {
+			events = append(events, jobEventFromMessage(msg))
+		}
+	}
+	return events, nil
+}
+
+// 最近的一个事件，没有事件时返回nil
+func LatestJobEvent(ctx context.Context, id string) (*JobEvent, error) {
+	res, err := RedisClient.XRevRangeN(ctx, JobEventKey(id), "+", "-", 1).Result()
+	if err != nil {
+		return nil, errors.Wrap(err, "LatestJobEvent")
+	}
+	if len(res) == 0 {
+		return nil, nil
+	}
+	event := jobEventFromMessage(res[0])
+	return &event, nil
+}
+
+// 根据DB中的任务记录生成快照事件，用于没有事件的任务(排队中或旧的任务)和补发结束事件
+func JobEventSnapshot(ctx context.Context, id string) (*JobEvent, error) {
+	var (
+		job = Job{Ctx: ctx, Id: id}
+		out = dto.GetJobOutput{}
+	)
+	if err := job.GetStatusAndPhase(&out); err != nil {
+		return nil, err
+	}
+	out.Id = job.Id
+	out.AppId = job.AppId
+	out.Status = job.Status
+	out.ApprovalGate = job.ApprovalGate
+	out.Cost = job.Cost
+	out.Parameters = RedactParameters(job.Parameters)
+	event := &JobEvent{Type: JobEventStatus, Data: common.ParseJsonStr(out)}
+	if IsJobFinished(job.Status) {
+		event.Type = JobEventEnd
+	}
+	return event, nil
+}
+
+func jobEventFromMessage(msg redis.XMessage) JobEvent {
+	event := JobEvent{Id: msg.ID}
+	event.Type, _ = msg.Values["type"].(string)
+	event.Data, _ = msg.Values["data"].(string)
+	return event
+}
+
+func phaseSummaryOutput(phases []*JobPhaseStatus) ([]string, string, string) {
+	running, failed := PhaseSummary(phases)
+	if failed == nil {
+		return running, "", ""
+	}
+	return running, failed.Name, failed.Message
+}
+
+func (j *Job) deleteEvents() error {
+	return RedisClient.Del(j.Ctx, JobEventKey(j.Id)).Err()
+}
+
+func JobEventKey(id string) string {
+	return strings.Join([]string{"cicd", "job-events", id}, sep)
+}
@@ -0,0 +1,390 @@
+package dao
+
+// 任务索引: 按开始时间排序的有序集合，分为全部任务、应用、状态、分支、触发方式、调用者、commit和应用版本，用于分页查询
+
+import (
+	"context"
+	"github.com/go-redis/redis/v8"
+	"github.com/pkg/errors"
+	utilrand "k8s.io/apimachinery/pkg/util/rand"
+	"lyyops-cicd/pkg/log"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	JobIndexApp      = "app"
+	JobIndexStatus   = "status"
+	JobIndexBranch   = "branch"
+	JobIndexTrigger  = "trigger"
+	JobIndexUser     = "user"
+	JobIndexCommit   = "commit"
+	JobIndexRevision = "revision"
+)
+
+const (
+	jobIndexTmpTTL   = 30 * time.Second // 多条件查询的临时交集，查询结束后删除
+	jobIndexBatch    = 200              // 关键字过滤时每次读取的数量
+	jobIndexMaxScan  = 5000             // 关键字过滤时单次查询最多扫描的数量
+	DefaultQuerySize = 100
+)
+
+// 所有的任务状态，更新状态索引时需要从其他状态中移除
+var jobStatuses = []string{
+	DefaultJobStatus, JobStatusQueued, JobStatusAwaitingApproval, JobStatusSucceeded,
+	JobStatusFailed, JobStatusError, JobStatusCancelled, JobStatusLost,
+}
+
+// 任务查询条件，为空的条件不做过滤
+type JobQuery struct {
+	AppId    string
+	Status   string
+	Branch   string
+	Trigger  string
+	User     string    // 调用者
+	Commit   string    // 完整的commit sha
+	Revision string    // 应用内容的版本
+	Keyword  string    // 任务Id包含的关键字
+	Since    time.Time // 开始时间范围
+	Until    time.Time
+	Cursor   string // 上一页返回的游标
+	Size     int
+}
+
+// 按开始时间倒序分页查询任务，返回下一页的游标，没有更多数据时游标为空
+func QueryJobs(ctx context.Context, q JobQuery) ([]*Job, string, error) {
+	var jobs []*Job
+	if q.Size <= 0 {
+		q.Size = DefaultQuerySize
+	}
+	keys := q.indexKeys()
+	key, cleanup, err := jobIndexIntersect(ctx, keys)
+	if err != nil {
+		return nil, "", err
+	}
+	defer cleanup()
+
+	max, min := "+inf", "-inf"
+	if !q.Until.IsZero() {
+		max = formatJobScore(jobScore(q.Until))
+	}
+	if !q.Since.IsZero() {
+		min = formatJobScore(jobScore(q.Since))
+	}
+	var cursor pageCursor
+	if q.Cursor != "" {
+		if cursor, err = parsePageCursor(q.Cursor); err != nil {
+			return nil, "", err
+		}
+		if q.Until.IsZero() || cursor.score <= jobScore(q.Until) {
+			max = cursor.max()
+		}
+	}
+
+	batch := q.Size
+	if q.Keyword != "" {
+		batch = jobIndexBatch
+	}
+	var offset int64 // 开始时间相同的任务超过batch时跳过已读取的部分
+	for scanned := 0; ; {
+		res, err := RedisClient.ZRevRangeByScoreWithScores(ctx, key, &redis.ZRangeBy{
+			Max: max, Min: min, Offset: offset, Count: int64(batch),
+		}).Result()
+		if err != nil {
+			return nil, "", errors.Wrap(err, "QueryJobs")
+		}
+		progressed := false
+		for _, z := range res {
+			id, _ := z.Member.(string)
+			if cursor.before(z.Score, id) {
+				continue // 上一页已返回
+			}
+			progressed = true
+			cursor = pageCursor{score: z.Score, id: id}
+			max = cursor.max()
+			if q.Keyword != "" && !strings.Contains(strings.ToLower(id), strings.ToLower(q.Keyword)) {
+				continue
+			}
+			job, err := GetJob(ctx, id)
+			if err != nil {
+				log.Warningf("job index %s: %v", id, err)
+				if errors.Cause(err) == ErrJobNotFound {
+					removeJobIndex(ctx, id, keys...) // 任务记录已过期或删除，顺便清理索引
+				}
+				continue
+			}
+			jobs = append(jobs, job)
+			if len(jobs) == q.Size {
+				return jobs, cursor.String(), nil
+			}
+		}
+		scanned += len(res)
+		if len(res) < batch {
+			return jobs, "", nil
+		}
+		if progressed {
+			offset = 0
+		} else {
+			offset += int64(len(res))
+		}
+		if scanned >= jobIndexMaxScan {
+			return jobs, cursor.String(), nil // 扫描数量过多，由调用方继续翻页
+		}
+	}
+}
+
+// 分页游标: 上一页最后一个任务的分数和Id，开始时间相同的任务按Id倒序排列
+type pageCursor struct {
+	score float64
+	id    string
+}
+
+// 游标格式为 分数_任务Id，旧版本的游标只有分数
+func parsePageCursor(s string) (pageCursor, error) {
+	var c pageCursor
+	parts := strings.SplitN(s, "_", 2)
+	score, err := strconv.ParseFloat(parts[0], 64)
+	if err != nil {
+		return c, errors.Errorf("非法的游标: %s", s)
+	}
+	c.score = score
+	if len(parts) == 2 {
+		c.id = parts[1]
+	}
+	return c, nil
+}
+
+func (c pageCursor) String() string {
+	return formatJobScore(c.score) + "_" + c.id
+}
+
+// 查询的分数上限，有任务Id时包含相同的分数
+func (c pageCursor) max() string {
+	if c.id == "" {
+		return "(" + formatJobScore(c.score)
+	}
+	return formatJobScore(c.score)
+}
+
+// 任务是否在游标之前(已返回)，分数相同时redis按成员倒序返回
+func (c pageCursor) before(score float64, id string) bool {
+	return c.id != "" && score == c.score && id >= c.id
+}
+
+// 查询条件对应的索引
+func (q JobQuery) indexKeys() []string {
+	var keys []string
+	if q.AppId != "" {
+		keys = append(keys, JobIndexKey(JobIndexApp, q.AppId))
+	}
+	if q.Status != "" {
+		keys = append(keys, JobIndexKey(JobIndexStatus, q.Status))
+	}
+	if q.Branch != "" {
+		keys = append(keys, JobIndexKey(JobIndexBranch, q.Branch))
+	}
+	if q.Trigger != "" {
+		keys = append(keys, JobIndexKey(JobIndexTrigger, q.Trigger))
+	}
+	if q.User != "" {
+		keys = append(keys, JobIndexKey(JobIndexUser, q.User))
+	}
+	if q.Commit != "" {
+		keys = append(keys, JobIndexKey(JobIndexCommit, q.Commit))
+	}
+	if q.Revision != "" {
+		keys = append(keys, JobIndexKey(JobIndexRevision, q.Revision))
+	}
+	if len(keys) == 0 {
+		keys = append(keys, JobIndexKey("", ""))
+	}
+	return keys
+}
+
+// 多个索引时取交集，保存到本次查询的临时有序集合，查询结束后删除
+// 每次查询重新计算交集，避免返回已离开该状态的任务
+func jobIndexIntersect(ctx context.Context, keys []string) (string, func(), error) {
+	if len(keys) == 1 {
+		return keys[0], func() {}, nil
+	}
+	dest := strings.Join([]string{"cicd", "job-index-tmp", utilrand.String(16)}, sep)
+	pipe := RedisClient.TxPipeline()
+	pipe.ZInterStore(ctx, dest, &redis.ZStore{Keys: keys, Aggregate: "MIN"})
+	pipe.Expire(ctx, dest, jobIndexTmpTTL) // 查询异常退出时自动删除
+	if _, err := pipe.Exec(ctx); err != nil {
+		return "", nil, errors.Wrap(err, "ZInterStore")
+	}
+	return dest, func() { RedisClient.Del(context.Background(), dest) }, nil
+}
+
+// 所有未结束的任务
+func ListUnfinishedJobs(ctx context.Context) ([]*Job, error) {
+	var jobs []*Job
+	for _, status := range []string{DefaultJobStatus, JobStatusQueued, JobStatusAwaitingApproval} {
+		key := JobIndexKey(JobIndexStatus, status)
+		ids, err := RedisClient.ZRange(ctx, key, 0, -1).Result()
+		if err != nil {
+			return nil, errors.Wrap(err, "ListUnfinishedJobs")
+		}
+		for _, id := range ids {
+			job, err := GetJob(ctx, id)
+			if err != nil {
+				log.Warningf("load job %s: %v", id, err)
+				if errors.Cause(err) == ErrJobNotFound {
+					removeJobIndex(ctx, id, key)
+				}
+				continue
+			}
+			if IsJobFinished(job.Status) {
+				continue
+			}
+			jobs = append(jobs, job)
+		}
+	}
+	return jobs, nil
+}
+
+// 根据任务记录更新索引
+func (j *Job) updateIndex() {
+	score := jobScore(j.StartTime)
+	if j.StartTime.IsZero() {
+		s, err := RedisClient.ZScore(j.Ctx, JobIndexKey("", ""), j.Id).Result()
+		if err != nil {
+			return
+		}
+		score = s
+	}
+	z := &redis.Z{Score: score, Member: j.Id}
+	pipe := RedisClient.Pipeline()
+	pipe.ZAdd(j.Ctx, JobIndexKey("", ""), z)
+	pipe.ZAdd(j.Ctx, JobIndexKey(JobIndexApp, j.AppId), z)
+	for _, status := range jobStatuses {
+		if status != j.Status {
+			pipe.ZRem(j.Ctx, JobIndexKey(JobIndexStatus, status), j.Id)
+		}
+	}
+	pipe.ZAdd(j.Ctx, JobIndexKey(JobIndexStatus, j.Status), z)
+	for _, key := range j.valueIndexKeys() {
+		pipe.ZAdd(j.Ctx, key, z)
+	}
+	if _, err := pipe.Exec(j.Ctx); err != nil {
+		log.Errorf("job[%s] update index: %v", j.Id, err)
+	}
+}
+
+// 从任务的所有索引中移除
+func (j *Job) deleteIndex() {
+	keys := []string{JobIndexKey("", ""), JobIndexKey(JobIndexApp, j.AppId)}
+	for _, status := range jobStatuses {
+		keys = append(keys, JobIndexKey(JobIndexStatus, status))
+	}
+	keys = append(keys, j.valueIndexKeys()...)
+	removeJobIndex(j.Ctx, j.Id, keys...)
+}
+
+// 创建后不再变化的字段的索引，值为空时不索引
+func (j *Job) valueIndexKeys() []string {
+	var keys []string
+	for _, kv := range [][2]string{
+		{JobIndexBranch, j.Branch},
+		{JobIndexTrigger, j.Trigger},
+		{JobIndexUser, j.CreatedBy},
+		{JobIndexCommit, j.Commit},
+		{JobIndexRevision, j.Revision},
+	} {
+		if kv[1] != "" {
+			keys = append(keys, JobIndexKey(kv[0], kv[1]))
+		}
+	}
+	return keys
+}
+
+func removeJobIndex(ctx context.Context, id string, keys ...string) {
+	pipe := RedisClient.Pipeline()
+	pipe.ZRem(ctx, JobIndexKey("", ""), id)
+	for _, key := range keys {
+		pipe.ZRem(ctx, key, id)
+	}
+	if _, err := pipe.Exec(ctx); err != nil {
+		log.Errorf("job[%s] remove index: %v", id, err)
+	}
+}
+
+// 全局索引为空时(旧版本升级)，根据任务记录重建索引
+func EnsureJobIndex(ctx context.Context) error {
+	n, err := RedisClient.ZCard(ctx, JobIndexKey("", "")).Result()
+	if err != nil || n > 0 {
+		return err
+	}
+	_, err = RebuildJobIndex(ctx)
+	return err
+}
+
+// 用SCAN遍历任务记录重建索引，返回索引的任务数量
+func RebuildJobIndex(ctx context.Context) (int, error) {
+	var (
+		count  int
+		cursor uint64
+	)
+	for {
+		keys, next, err := RedisClient.Scan(ctx, cursor, JobStatusKey("*"), 500).Result()
+		if err != nil {
+			return count, errors.Wrap(err, "RebuildJobIndex")
+		}
+		for _, key := range keys {
+			job, err := GetJob(ctx, ExtractJobStatusName(key))
+			if err != nil {
+				log.Warningf("rebuild index %s: %v", key, err)
+				continue
+			}
+			job.updateIndex()
+			count++
+		}
+		if cursor = next; cursor == 0 {
+			break
+		}
+	}
+	log.Infof("rebuild job index: %d jobs", count)
+	return count, nil
+}
+
+// 清理索引中已过期的任务
+func PruneJobIndex(ctx context.Context) error {
+	var cursor uint64
+	max := "(" + formatJobScore(jobScore(time.Now().Add(-defaultExpired)))
+	for {
+		keys, next, err := RedisClient.Scan(ctx, cursor, JobIndexKey("*", "*"), 500).Result()
+		if err != nil {
+			return errors.Wrap(err, "PruneJobIndex")
+		}
+		keys = append(keys, JobIndexKey("", ""))
+		for _, key := range keys {
+			RedisClient.ZRemRangeByScore(ctx, key, "-inf", max)
+		}
+		if cursor = next; cursor == 0 {
+			return nil
+		}
+	}
+}
+
+// 分页游标，下一页从该任务之后开始
+func jobCursor(j *Job) string {
+	return pageCursor{score: jobScore(j.StartTime), id: j.Id}.String()
+}
+
+func jobScore(t time.Time) float64 {
+	return float64(t.UnixNano()) / 1e9
+}
+
+func formatJobScore(score float64) string {
+	return strconv.FormatFloat(score, 'f', -1, 64)
+}
+
+// 任务索引，field为空时为全部任务
+func JobIndexKey(field, value string) string {
+	if field == "" {
+		return strings.Join([]string{"cicd", "job-index"}, sep)
+	}
+	return strings.Join([]string{"cicd", "job-index", field, value}, sep)
+}
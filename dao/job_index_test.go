@@ -0,0 +1,43 @@
+package dao
+
+import "testing"
+
+func TestPageCursor(t *testing.T) {
+	c, err := parsePageCursor("1634540000.25_app-build-x2k9")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.id != "app-build-x2k9" || c.max() != "1634540000.25" {
+		t.Errorf("cursor = %+v, max = %s", c, c.max())
+	}
+	if got := c.String(); got != "1634540000.25_app-build-x2k9" {
+		t.Errorf("String() = %s", got)
+	}
+	// 相同开始时间的任务按Id倒序返回，Id不小于游标的已在上一页
+	for _, tc := range []struct {
+		score float64
+		id    string
+		want  bool
+	}{
+		{c.score, "app-build-x2k9", true},
+		{c.score, "app-build-z000", true},
+		{c.score, "app-build-a000", false},
+		{c.score - 1, "app-build-z000", false},
+	} {
+		if got := c.before(tc.score, tc.id); got != tc.want {
+			t.Errorf("before(%v, %s) = %v, want %v", tc.score, tc.id, got, tc.want)
+		}
+	}
+
+	// 旧版本的游标只有分数，不包含该分数
+	old, err := parsePageCursor("1634540000.5")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if old.max() != "(1634540000.5" || old.before(old.score, "any") {
+		t.Errorf("legacy cursor = %+v, max = %s", old, old.max())
+	}
+	if _, err := parsePageCursor("abc"); err == nil {
+		t.Error("expected error for invalid cursor")
+	}
+}
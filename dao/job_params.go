@@ -0,0 +1,183 @@
+package dao
+
+import (
+	"context"
+	wfv1 "github.com/argoproj/argo-workflows/v3/pkg/apis/workflow/v1alpha1"
+	"github.com/pkg/errors"
+	"lyyops-cicd/pkg/log"
+	"sort"
+	"strings"
+)
+
+// 根据参数名修改workflow的参数，返回解析后的全部参数
+// 参数名优先匹配 spec.arguments.parameters，其次匹配各个step/task的arguments
+func ResolveParameters(wf *wfv1.Workflow, params map[string]string) (map[string]string, error) {
+	var (
+		resolved = map[string]string{}
+		unknown  []string
+		missing  []string
+	)
+
+	// 1. workflow 全局参数
+	global := map[string]bool{}
+	for i := range wf.Spec.Arguments.Parameters {
+		p := &wf.Spec.Arguments.Parameters[i]
+		global[p.Name] = true
+		if val, ok := params[p.Name]; ok {
+			if err := checkParameterEnum(p, val); err != nil {
+				return nil, err
+			}
+			p.Value = wfv1.AnyStringPtr(val)
+		}
+		if v, ok := parameterValue(p); ok {
+			resolved[p.Name] = v
+		} else if p.ValueFrom == nil {
+			missing = append(missing, p.Name)
+		}
+	}
+
+	// 2. step/task 参数
+	found := map[string]bool{}
+	for _, p := range stepParameters(wf) {
+		if global[p.Name] {
+			continue // 同名的全局参数已处理
+		}
+		found[p.Name] = true
+		if val, ok := params[p.Name]; ok {
+			if err := checkParameterEnum(p, val); err != nil {
+				return nil, err
+			}
+			p.Value = wfv1.AnyStringPtr(val)
+		}
+		if v, ok := parameterValue(p); ok {
+			if _, ok := resolved[p.Name]; !ok {
+				resolved[p.Name] = v
+			}
+		} else if p.ValueFrom == nil {
+			missing = append(missing, p.Name)
+		}
+	}
+
+	for name := range params {
+		if !global[name] && !found[name] {
+			unknown = append(unknown, name)
+		}
+	}
+	if len(unknown) > 0 {
+		sort.Strings(unknown)
+		return nil, errors.Errorf("未知的参数: %s", strings.Join(unknown, ","))
+	}
+	if len(missing) > 0 {
+		return nil, errors.Errorf("缺少必填参数: %s", strings.Join(uniqueStrings(missing), ","))
+	}
+	log.Debugf("resolved parameters: %v", resolved)
+	return resolved, nil
+}
+
+// 所有step和dag task的参数(指针，可直接修改)
+func stepParameters(wf *wfv1.Workflow) []*wfv1.Parameter {
+	var params []*wfv1.Parameter
+	for i := range wf.Spec.Templates {
+		tmpl := &wf.Spec.Templates[i]
+		for j := range tmpl.Steps {
+			for k := range tmpl.Steps[j].Steps {
+				args := &tmpl.Steps[j].Steps[k].Arguments
+				for n := range args.Parameters {
+					params = append(params, &args.Parameters[n])
+				}
+			}
+		}
+		if tmpl.DAG == nil {
+			continue
+		}
+		for j := range tmpl.DAG.Tasks {
+			args := &tmpl.DAG.Tasks[j].Arguments
+			for n := range args.Parameters {
+				params = append(params, &args.Parameters[n])
+			}
+		}
+	}
+	return params
+}
+
+func parameterValue(p *wfv1.Parameter) (string, bool) {
+	if p.Value != nil {
+		return p.Value.String(), true
+	}
+	if p.Default != nil {
+		return p.Default.String(), true
+	}
+	return "", false
+}
+
+func checkParameterEnum(p *wfv1.Parameter, val string) error {
+	if len(p.Enum) == 0 {
+		return nil
+	}
+	for _, e := range p.Enum {
+		if e.String() == val {
+			return nil
+		}
+	}
+	return errors.Errorf("参数 %s 的值 %s 不在可选范围内", p.Name, val)
+}
+
+func uniqueStrings(list []string) []string {
+	var (
+		res  []string
+		seen = map[string]bool{}
+	)
+	for _, v := range list {
+		if !seen[v] {
+			seen[v] = true
+			res = append(res, v)
+		}
+	}
+	return res
+}
+
+func containsString(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+// 只保留应用中存在的参数，返回保留的参数和被忽略的参数名
+func knownParameters(params map[string]string, names map[string]bool) (map[string]string, []string) {
+	var (
+		known   = map[string]string{}
+		dropped []string
+	)
+	for k, v := range params {
+		if names[k] {
+			known[k] = v
+		} else {
+			dropped = append(dropped, k)
+		}
+	}
+	sort.Strings(dropped)
+	return known, dropped
+}
+
+// 应用的workflow中可以设置的参数名
+func ApplicationParameterNames(ctx context.Context, appId string) (map[string]bool, error) {
+	app, err := GetApplication(ctx, appId)
+	if err != nil {
+		return nil, errors.Wrapf(err, "GetApplication %s", appId)
+	}
+	wf, err := UnmarshalWorkflow(app.Content)
+	if err != nil {
+		return nil, err
+	}
+	names := map[string]bool{}
+	for _, p := range wf.Spec.Arguments.Parameters {
+		names[p.Name] = true
+	}
+	for _, p := range stepParameters(wf) {
+		names[p.Name] = true
+	}
+	return names, nil
+}
@@ -0,0 +1,105 @@
+package dao
+
+import (
+	"lyyops-cicd/config"
+	"lyyops-cicd/pkg/log"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+const resolveParamsWorkflow = `
+apiVersion: argoproj.io/v1alpha1
+kind: Workflow
+metadata:
+  generateName: app-
+spec:
+  entrypoint: main
+  arguments:
+    parameters:
+    - name: branch
+      value: master
+    - name: env
+      enum: [dev, prod]
+      value: dev
+    - name: image
+  templates:
+  - name: main
+    steps:
+    - - name: build
+        template: build
+        arguments:
+          parameters:
+          - name: branch
+            value: "{{workflow.parameters.branch}}"
+          - name: go_version
+            value: "1.16"
+          - name: output
+            valueFrom:
+              supplied: {}
+  - name: build
+    container:
+      image: golang
+`
+
+func TestKnownParameters(t *testing.T) {
+	known, dropped := knownParameters(
+		map[string]string{"branch": "main", "image": "v1", "old": "x", "removed": "y"},
+		map[string]bool{"branch": true, "image": true},
+	)
+	if want := map[string]string{"branch": "main", "image": "v1"}; !reflect.DeepEqual(known, want) {
+		t.Errorf("known = %v, want %v", known, want)
+	}
+	if want := []string{"old", "removed"}; !reflect.DeepEqual(dropped, want) {
+		t.Errorf("dropped = %v, want %v", dropped, want)
+	}
+}
+
+func TestResolveParameters(t *testing.T) {
+	log.NewLogger(config.Log{FilePath: filepath.Join(t.TempDir(), "test.log")})
+	tests := []struct {
+		name   string
+		params map[string]string
+		want   map[string]string
+		err    string
+	}{
+		{
+			name:   "defaults and overrides",
+			params: map[string]string{"image": "v1", "go_version": "1.17"},
+			want:   map[string]string{"branch": "master", "env": "dev", "image": "v1", "go_version": "1.17"},
+		},
+		{
+			name:   "global parameter wins over step parameter",
+			params: map[string]string{"image": "v1", "branch": "main", "env": "prod"},
+			want:   map[string]string{"branch": "main", "env": "prod", "image": "v1", "go_version": "1.16"},
+		},
+		{name: "missing required", params: map[string]string{}, err: "缺少必填参数: image"},
+		{name: "unknown", params: map[string]string{"image": "v1", "tag": "x", "arch": "y"}, err: "未知的参数: arch,tag"},
+		{name: "not in enum", params: map[string]string{"image": "v1", "env": "test"}, err: "参数 env 的值 test 不在可选范围内"},
+	}
+	for _, tt := range tests {
+		wf, err := UnmarshalWorkflow([]byte(resolveParamsWorkflow))
+		if err != nil {
+			t.Fatalf("UnmarshalWorkflow: %v", err)
+		}
+		got, err := ResolveParameters(wf, tt.params)
+		if tt.err != "" {
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Errorf("%s: err = %v, want %q", tt.name, err, tt.err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: ResolveParameters: %v", tt.name, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: ResolveParameters = %v, want %v", tt.name, got, tt.want)
+		}
+		// 参数写回workflow
+		if v := wf.Spec.Arguments.Parameters[2].Value; v == nil || v.String() != tt.params["image"] {
+			t.Errorf("%s: workflow image parameter = %v", tt.name, v)
+		}
+	}
+}
@@ -6,97 +6,290 @@ import (
 	"github.com/pkg/errors"
 	"lyyops-cicd/pkg/common"
 	"lyyops-cicd/pkg/log"
+	"sort"
 	"strings"
+	"time"
 )
 
 //const DefaultJobPhaseStatus string = "NotReady"
 
+// 任务阶段，对应workflow status中的一个节点
+// 保存时是扁平的(通过Parent关联)，查询时组装成树形结构
 type JobPhaseStatus struct {
-	Name    string `json:"name"`
-	PodName string `json:"pod_name"`
-	Status  string `json:"status"`
+	Id       string            `json:"id"`
+	Name     string            `json:"name"`
+	Type     string            `json:"type"` // Pod, Steps, DAG, TaskGroup, Retry ...
+	Template string            `json:"template,omitempty"`
+	PodName  string            `json:"pod_name"`
+	Status   string            `json:"status"`
+	Message  string            `json:"message,omitempty"` // 失败原因
+	ExitCode string            `json:"exit_code,omitempty"`
+	Retries  int               `json:"retries"` // 重试次数
+	Started  time.Time         `json:"started"`
+	Finished time.Time         `json:"finished"`
+	Duration string            `json:"duration"`
+	Parent   string            `json:"parent,omitempty"`
+	Index    int               `json:"index"` // 同级阶段的顺序
+	Children []*JobPhaseStatus `json:"children,omitempty"`
 }
 
-// 获取每个阶段的状态
-func (j *Job) GetPhases() ([]JobPhaseStatus, error) {
-	var phases = []JobPhaseStatus{}
+// 获取每个阶段的状态，返回顶层阶段，子阶段在Children中
+func (j *Job) GetPhases() ([]*JobPhaseStatus, error) {
+	all := map[string]*JobPhaseStatus{}
 	res, err := RedisClient.HGetAll(j.Ctx, JobPhaseKey(j.Id)).Result()
 	if err != nil {
-		return phases, err
+		return []*JobPhaseStatus{}, err
 	}
 	log.Debugf("fetch data: %+v", res)
-	if len(j.PhaseNames) == 0 {
-		return phases, errors.New("job.PhaseNames 不能为空")
-	}
-	for _, k := range j.PhaseNames {
-		phase := JobPhaseStatus{}
-		if data, ok := res[k]; ok {
-			if err := json.Unmarshal([]byte(data), &phase); err != nil {
-				log.Warning("phase status unmarshal: %v", err)
-				continue
-			}
+	for k, data := range res {
+		phase := &JobPhaseStatus{}
+		if err := json.Unmarshal([]byte(data), phase); err != nil {
+			log.Warningf("phase status unmarshal: %v", err)
+			continue
+		}
+		if phase.Id == "" {
+			phase.Id = k // 旧的阶段记录以阶段名为key，没有层级
+			phase.Index = indexOf(j.PhaseNames, k)
+		}
+		phase.setDuration()
+		all[phase.Id] = phase
+	}
+
+	return phaseTree(all), nil
+}
+
+// 按Parent把扁平的阶段组装成树形结构，返回顶层阶段(复制一份，不修改传入的阶段)
+func phaseTree(all map[string]*JobPhaseStatus) []*JobPhaseStatus {
+	var (
+		phases = []*JobPhaseStatus{}
+		copied = make(map[string]*JobPhaseStatus, len(all))
+	)
+	for id, phase := range all {
+		p := *phase
+		p.Children = nil
+		copied[id] = &p
+	}
+	for _, phase := range copied {
+		if parent, ok := copied[phase.Parent]; ok {
+			parent.Children = append(parent.Children, phase)
+		} else {
 			phases = append(phases, phase)
-			log.Debugf("add phase: %v", phase)
 		}
 	}
-	return phases, nil
+	sortPhases(phases)
+	return phases
 }
 
 // 保存每个阶段的信息
 func (j *Job) phaseSave(wf *wfv1.Workflow) error {
 	log.Debugf("phase info: %s, %s", wf.Name, wf.Status.Phase)
-	//log.Debugf("workflow: %s", common.ParseJsonStr(wf))
-
-	// 从workflow中提出阶段的信息
-	j.Phases = make(map[string]*JobPhaseStatus)
-	steps := j.Workflow.Spec.Templates[0].Steps // templates steps
-	log.Debugf("steps: %+v", steps)
-	for _, step := range steps {
-		name := step.Steps[0].Name
-		j.Phases[name] = &JobPhaseStatus{
-			Name:    name,
-			PodName: getPodnameFromWorkflow(&wf.Status.Nodes, name),
-			Status:  string(wf.Status.Phase)}
-		log.Debugf("phase: %+v", j.Phases[name])
+
+	// 从workflow status nodes中提出阶段的信息，失败信息中可能有敏感内容
+	j.Phases = workflowPhases(wf)
+	for _, phase := range j.Phases {
+		phase.Message, _ = j.redactor().Redact(phase.Message)
 	}
 	log.Infof("job.Phases %v", j.Phases)
+	if len(j.Phases) == 0 {
+		return nil // workflow还没有开始调度
+	}
 
-	// 保存每个阶段的信息
-	if len(j.PhaseNames) == 0 {
-		return errors.New("job.PhaseNames 不能为空")
+	// 保存每个阶段的信息，节点会变化，先删除旧的记录
+	pipe := RedisClient.TxPipeline()
+	pipe.Del(j.Ctx, JobPhaseKey(j.Id))
+	for id, phase := range j.Phases {
+		pipe.HSet(j.Ctx, JobPhaseKey(j.Id), id, phase.String())
 	}
-	for _, name := range j.PhaseNames {
-		if err := RedisClient.HSet(j.Ctx, JobPhaseKey(wf.Name), name, j.Phases[name].String()).Err(); err != nil {
-			return errors.Wrapf(err, "保存 %s 失败", name)
-		}
+	pipe.Expire(j.Ctx, JobPhaseKey(j.Id), defaultExpired) // 设定过期时间
+	if _, err := pipe.Exec(j.Ctx); err != nil {
+		return errors.Wrap(err, "保存阶段信息失败")
 	}
-	log.Infof("phases: %v save: %+v", j.PhaseNames, j.Phases)
-	return RedisClient.Expire(j.Ctx, JobPhaseKey(j.Id), defaultExpired).Err() // 设定过期时间
+	return nil
 }
 
-func (j *Job) GetPhaseNames() []string {
+// 遍历workflow的节点，生成扁平的阶段列表(不包含workflow根节点)
+// TaskGroup/Retry 的子节点属于该节点(withItems展开的任务、重试)，
+// 其他节点属于所在的 Steps/DAG 模板节点(BoundaryID)，嵌套调用的模板同样处理
+// StepGroup(名字为[0], [1]...)不作为阶段，其中的步骤直接属于Steps节点，与之前的阶段列表保持一致
+func workflowPhases(wf *wfv1.Workflow) map[string]*JobPhaseStatus {
 	var (
-		names []string
-		steps = j.Workflow.Spec.Templates[0].Steps
+		nodes  = wf.Status.Nodes
+		phases = map[string]*JobPhaseStatus{}
+		parent = map[string]string{}
+		root   = wf.ObjectMeta.Name
 	)
-	for _, step := range steps {
-		names = append(names, step.Steps[0].Name)
+	for id, node := range nodes {
+		switch node.Type {
+		case wfv1.NodeTypeTaskGroup, wfv1.NodeTypeRetry:
+			for _, child := range node.Children {
+				parent[child] = id
+			}
+		}
+	}
+	for id, node := range nodes {
+		if _, ok := parent[id]; !ok && node.BoundaryID != "" {
+			parent[id] = node.BoundaryID
+		}
+	}
+
+	for id, node := range nodes {
+		// 只有一个节点时(入口模板是container/script)，根节点就是唯一的阶段
+		if id == root && len(nodes) > 1 || node.Type == wfv1.NodeTypeStepGroup {
+			continue
+		}
+		phase := &JobPhaseStatus{
+			Id:       id,
+			Name:     node.DisplayName,
+			Type:     string(node.Type),
+			Template: node.TemplateName,
+			Status:   string(node.Phase),
+			Message:  node.Message,
+			Started:  node.StartedAt.Time,
+			Finished: node.FinishedAt.Time,
+			Parent:   parent[id],
+		}
+		if phase.Parent == root {
+			phase.Parent = ""
+		}
+		if node.Type == wfv1.NodeTypePod {
+			phase.PodName = id
+		}
+		if node.Outputs != nil && node.Outputs.ExitCode != nil {
+			phase.ExitCode = *node.Outputs.ExitCode
+		}
+		if node.Type == wfv1.NodeTypeRetry && len(node.Children) > 1 {
+			phase.Retries = len(node.Children) - 1 // 每次重试是一个子节点
+		}
+		phases[id] = phase
+	}
+	setPhaseIndex(wf, phases)
+	return phases
+}
+
+// 同级阶段按开始时间排序，没有开始的排在最后
+func setPhaseIndex(wf *wfv1.Workflow, phases map[string]*JobPhaseStatus) {
+	siblings := map[string][]*JobPhaseStatus{}
+	for _, phase := range phases {
+		siblings[phase.Parent] = append(siblings[phase.Parent], phase)
+	}
+	for _, list := range siblings {
+		sort.Slice(list, func(a, b int) bool {
+			na, nb := wf.Status.Nodes[list[a].Id], wf.Status.Nodes[list[b].Id]
+			switch {
+			case na.StartedAt.IsZero() != nb.StartedAt.IsZero():
+				return nb.StartedAt.IsZero()
+			case !na.StartedAt.Equal(&nb.StartedAt):
+				return na.StartedAt.Before(&nb.StartedAt)
+			}
+			return list[a].Name < list[b].Name
+		})
+		for i, phase := range list {
+			phase.Index = i
+		}
+	}
+}
+
+func sortPhases(phases []*JobPhaseStatus) {
+	sort.Slice(phases, func(a, b int) bool {
+		if phases[a].Index != phases[b].Index {
+			return phases[a].Index < phases[b].Index
+		}
+		return phases[a].Name < phases[b].Name
+	})
+	for _, phase := range phases {
+		sortPhases(phase.Children)
+	}
+}
+
+// 阶段耗时，没有结束时计算到当前时间
+func (p *JobPhaseStatus) setDuration() {
+	if p.Started.IsZero() {
+		return
+	}
+	end := p.Finished
+	if end.IsZero() {
+		end = time.Now()
+	}
+	p.Duration = end.Sub(p.Started).Truncate(time.Second).String()
+}
+
+// 正在运行的阶段和第一个失败的阶段(只看最底层的阶段)
+// 重试节点只看最后一次尝试，之前失败的尝试已被取代
+func PhaseSummary(phases []*JobPhaseStatus) (running []string, failed *JobPhaseStatus) {
+	for _, phase := range phases {
+		if len(phase.Children) > 0 {
+			children := phase.Children
+			if phase.Type == string(wfv1.NodeTypeRetry) {
+				children = []*JobPhaseStatus{lastAttempt(phase.Children)}
+			}
+			r, f := PhaseSummary(children)
+			running = append(running, r...)
+			if f == nil && phase.Type == string(wfv1.NodeTypeRetry) && isPhaseFailed(phase.Status) {
+				f = phase // 重试次数用完，失败原因在重试节点上
+			}
+			if failed == nil {
+				failed = f
+			}
+			continue
+		}
+		switch {
+		case phase.Status == string(wfv1.NodeRunning):
+			running = append(running, phase.Name)
+		case isPhaseFailed(phase.Status):
+			if failed == nil {
+				failed = phase
+			}
+		}
+	}
+	return running, failed
+}
+
+// 最后一次尝试(同级顺序最大)
+func lastAttempt(attempts []*JobPhaseStatus) *JobPhaseStatus {
+	last := attempts[0]
+	for _, attempt := range attempts[1:] {
+		if attempt.Index > last.Index {
+			last = attempt
+		}
+	}
+	return last
+}
+
+func isPhaseFailed(status string) bool {
+	return status == string(wfv1.NodeFailed) || status == string(wfv1.NodeError)
+}
+
+// 入口模板中的步骤和任务名字(包括并行的步骤)，workflow创建前用于预览阶段
+func (j *Job) GetPhaseNames() []string {
+	var names []string
+	tmpl := j.Workflow.GetTemplateByName(j.Workflow.Spec.Entrypoint)
+	if tmpl == nil && len(j.Workflow.Spec.Templates) > 0 {
+		tmpl = &j.Workflow.Spec.Templates[0]
+	}
+	if tmpl == nil {
+		return names
+	}
+	for _, group := range tmpl.Steps {
+		for _, step := range group.Steps {
+			names = append(names, step.Name)
+		}
+	}
+	if tmpl.DAG != nil {
+		for _, task := range tmpl.DAG.Tasks {
+			names = append(names, task.Name)
+		}
 	}
 	log.Infof("job.GetPhaseNames %s", names)
 	return names
 }
 
-// 从workflow status nodes中获取podname
-func getPodnameFromWorkflow(nodes *wfv1.Nodes, phaseName string) string {
-	var PodName string
-	for k, v := range *nodes {
-		log.Debugf("node info key[%s]: %s=%s,%s", k, phaseName, v.DisplayName, v.String())
-		if phaseName == v.DisplayName {
-			PodName = k
-			return PodName
+func indexOf(list []string, s string) int {
+	for i, v := range list {
+		if v == s {
+			return i
 		}
 	}
-	return PodName
+	return len(list)
 }
 
 func (p *JobPhaseStatus) String() string {
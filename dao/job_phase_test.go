@@ -0,0 +1,100 @@
+package dao
+
+import (
+	wfv1 "github.com/argoproj/argo-workflows/v3/pkg/apis/workflow/v1alpha1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestPhaseSummaryRetry(t *testing.T) {
+	attempt := func(name, status string, index int) *JobPhaseStatus {
+		return &JobPhaseStatus{Name: name, Type: "Pod", Status: status, Index: index, Message: name + " failed"}
+	}
+	tests := []struct {
+		name    string
+		phases  []*JobPhaseStatus
+		running []string
+		failed  string
+	}{
+		{
+			name: "retry succeeded",
+			phases: []*JobPhaseStatus{
+				{Name: "build", Type: "Retry", Status: "Succeeded", Children: []*JobPhaseStatus{
+					attempt("build(1)", "Succeeded", 1), attempt("build(0)", "Failed", 0),
+				}},
+				attempt("deploy", "Running", 1),
+			},
+			running: []string{"deploy"},
+		},
+		{
+			name: "retry running",
+			phases: []*JobPhaseStatus{
+				{Name: "build", Type: "Retry", Status: "Running", Children: []*JobPhaseStatus{
+					attempt("build(0)", "Failed", 0), attempt("build(1)", "Running", 1),
+				}},
+			},
+			running: []string{"build(1)"},
+		},
+		{
+			name: "retry exhausted",
+			phases: []*JobPhaseStatus{
+				{Name: "build", Type: "Retry", Status: "Failed", Children: []*JobPhaseStatus{
+					attempt("build(0)", "Failed", 0), attempt("build(1)", "Error", 1),
+				}},
+			},
+			failed: "build(1)",
+		},
+		{
+			name: "steps",
+			phases: []*JobPhaseStatus{
+				{Name: "main", Type: "Steps", Status: "Failed", Children: []*JobPhaseStatus{
+					attempt("test", "Failed", 0), attempt("lint", "Failed", 1),
+				}},
+			},
+			failed: "test",
+		},
+	}
+	for _, tt := range tests {
+		running, failed := PhaseSummary(tt.phases)
+		if len(running) != len(tt.running) || (len(running) > 0 && running[0] != tt.running[0]) {
+			t.Errorf("%s: running = %v, want %v", tt.name, running, tt.running)
+		}
+		var name string
+		if failed != nil {
+			name = failed.Name
+		}
+		if name != tt.failed {
+			t.Errorf("%s: failed = %q, want %q", tt.name, name, tt.failed)
+		}
+	}
+}
+
+func TestWorkflowPhasesFlattenStepGroups(t *testing.T) {
+	at := func(sec int) metav1.Time { return metav1.NewTime(time.Date(2021, 10, 18, 0, 0, sec, 0, time.UTC)) }
+	wf := &wfv1.Workflow{
+		ObjectMeta: metav1.ObjectMeta{Name: "app-x"},
+		Status: wfv1.WorkflowStatus{Nodes: wfv1.Nodes{
+			"app-x":   {ID: "app-x", DisplayName: "app-x", Type: wfv1.NodeTypeSteps, Children: []string{"app-x-0"}, StartedAt: at(0)},
+			"app-x-0": {ID: "app-x-0", DisplayName: "[0]", Type: wfv1.NodeTypeStepGroup, BoundaryID: "app-x", Children: []string{"app-x-1"}, StartedAt: at(0)},
+			"app-x-1": {ID: "app-x-1", DisplayName: "build", Type: wfv1.NodeTypeRetry, BoundaryID: "app-x", Children: []string{"app-x-2", "app-x-3"}, StartedAt: at(0)},
+			"app-x-2": {ID: "app-x-2", DisplayName: "build(0)", Type: wfv1.NodeTypePod, BoundaryID: "app-x", StartedAt: at(0)},
+			"app-x-3": {ID: "app-x-3", DisplayName: "build(1)", Type: wfv1.NodeTypePod, BoundaryID: "app-x", StartedAt: at(10)},
+			"app-x-4": {ID: "app-x-4", DisplayName: "[1]", Type: wfv1.NodeTypeStepGroup, BoundaryID: "app-x", Children: []string{"app-x-5", "app-x-6"}, StartedAt: at(20)},
+			"app-x-5": {ID: "app-x-5", DisplayName: "test", Type: wfv1.NodeTypePod, BoundaryID: "app-x", StartedAt: at(20)},
+			"app-x-6": {ID: "app-x-6", DisplayName: "lint", Type: wfv1.NodeTypePod, BoundaryID: "app-x", StartedAt: at(20)},
+		}},
+	}
+	phases := phaseTree(workflowPhases(wf))
+	var names []string
+	for _, phase := range phases {
+		names = append(names, phase.Name)
+	}
+	if want := []string{"build", "lint", "test"}; !reflect.DeepEqual(names, want) {
+		t.Fatalf("top level phases = %v, want %v", names, want)
+	}
+	if build := phases[0]; len(build.Children) != 2 || build.Retries != 1 || build.Children[1].Name != "build(1)" {
+		t.Errorf("build = %+v", build)
+	}
+}
@@ -0,0 +1,103 @@
+package dao
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/argoproj/argo-workflows/v3/pkg/apiclient/workflow"
+	"github.com/pkg/errors"
+	"lyyops-cicd/pkg/log"
+	"strings"
+	"time"
+)
+
+const jobCleanLockTTL = time.Minute
+
+// 重试任务还在时不能删除原任务，重试任务和原任务共用workflow
+var ErrJobHasRetries = errors.New("job has retry jobs")
+
+// 任务用到的所有pod(从阶段信息中获取)
+func (j *Job) PodNames() ([]string, error) {
+	var pods []string
+	res, err := RedisClient.HGetAll(j.Ctx, JobPhaseKey(j.Id)).Result()
+	if err != nil {
+		return nil, err
+	}
+	for _, data := range res {
+		phase := JobPhaseStatus{}
+		if err := json.Unmarshal([]byte(data), &phase); err != nil {
+			continue
+		}
+		if phase.PodName != "" {
+			pods = append(pods, phase.PodName)
+		}
+	}
+	return uniqueStrings(pods), nil
+}
+
+// 还没有删除的重试任务
+func (j *Job) RetryJobs() ([]string, error) {
+	var ids []string
+	for i := 1; i <= j.RetryCount; i++ {
+		id := fmt.Sprintf("%s-retry%d", j.Id, i)
+		n, err := RedisClient.Exists(j.Ctx, JobStatusKey(id)).Result()
+		if err != nil {
+			return nil, err
+		}
+		if n > 0 {
+			ids = append(ids, id)
+		}
+	}
+	return ids, nil
+}
+
+// 彻底删除任务: 任务状态、阶段信息、pod日志和argo workflow
+// 重试任务和原任务共用workflow，重试任务删除后才能删除原任务和workflow
+// 先删除workflow，失败时保留redis中的记录，下次可以继续清理
+func (j *Job) Purge() error {
+	retries, err := j.RetryJobs()
+	if err != nil {
+		return errors.Wrap(err, "job.RetryJobs")
+	}
+	if len(retries) > 0 {
+		return errors.Wrapf(ErrJobHasRetries, "%v", retries)
+	}
+	pods, err := j.PodNames()
+	if err != nil {
+		return errors.Wrap(err, "job.PodNames")
+	}
+	if j.workflowName() == j.Id {
+		ctx, cli, err := NewArgoClient()
+		if err != nil {
+			return err
+		}
+		_, err = cli.NewWorkflowServiceClient().DeleteWorkflow(ctx, &workflow.WorkflowDeleteRequest{
+			Name:      j.workflowName(),
+			Namespace: getNamespace(ctx),
+		})
+		if err != nil && !isWorkflowNotFound(err) {
+			return errors.Wrap(err, "svcCli.DeleteWorkflow")
+		}
+	}
+	if err := deleteLogs(j.Ctx, pods); err != nil {
+		return errors.Wrap(err, "deleteLogs")
+	}
+	if err := j.Delete(); err != nil {
+		return err
+	}
+	log.Infof("job[%s] purged, pods: %v", j.Id, pods)
+	return nil
+}
+
+// 多个副本之间的任务清理锁，没有获取到时返回错误，持有期间自动续期
+func LockJobClean(ctx context.Context) (func(), error) {
+	unlock, err := acquireLock(ctx, JobCleanLockKey(), jobCleanLockTTL, 0)
+	if err != nil {
+		return nil, errors.Wrap(err, "其他副本正在清理任务")
+	}
+	return unlock, nil
+}
+
+func JobCleanLockKey() string {
+	return strings.Join([]string{"cicd", "job-clean-lock"}, sep)
+}
@@ -0,0 +1,318 @@
+package dao
+
+// 任务并发控制: 每个应用和全局的运行中任务数有上限，超出时按策略排队、拒绝或取消旧任务
+
+import (
+	"context"
+	"encoding/json"
+	wfv1 "github.com/argoproj/argo-workflows/v3/pkg/apis/workflow/v1alpha1"
+	"github.com/go-redis/redis/v8"
+	"github.com/pkg/errors"
+	utilrand "k8s.io/apimachinery/pkg/util/rand"
+	"lyyops-cicd/config"
+	"lyyops-cicd/pkg/common"
+	"lyyops-cicd/pkg/log"
+	"strings"
+	"time"
+)
+
+const JobStatusQueued = "Queued"
+
+const (
+	QueuePolicyQueue  = "queue"  // 排队等待
+	QueuePolicyReject = "reject" // 直接拒绝
+	QueuePolicyCancel = "cancel" // 取消应用最早的运行中任务
+)
+
+const queueLockTTL = 10 * time.Second
+
+// 提交任务: 有空闲的并发数时直接创建，否则按策略处理
+func (j *Job) Submit(policy string) error {
+	if policy == "" {
+		policy = config.Config.Queue.Policy
+	}
+	switch policy {
+	case "", QueuePolicyQueue, QueuePolicyReject, QueuePolicyCancel:
+	default:
+		return errors.Errorf("不支持的排队策略: %s", policy)
+	}
+	j.resolveCommit() // 记录提交时代码的版本，在获取队列锁之前访问远程仓库
+
+	unlock, err := lockQueue(j.Ctx)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	appFree, globalFree, err := freeSlots(j.Ctx, j.AppId)
+	if err != nil {
+		return err
+	}
+	if appFree && globalFree {
+		// 有排队的任务时也加入队列，按全局队列的顺序启动
+		queued, err := RedisClient.ZCard(j.Ctx, JobQueueKey("")).Result()
+		if err != nil {
+			return err
+		}
+		if queued == 0 {
+			return j.Create()
+		}
+		return j.enqueueAndDispatch()
+	}
+
+	switch policy {
+	case QueuePolicyReject:
+		if !appFree {
+			return errors.Errorf("应用 %s 的运行中任务数已达上限", j.AppId)
+		}
+		return errors.New("运行中任务总数已达上限")
+	case QueuePolicyCancel:
+		// 只有应用自身的并发数已满时，取消旧任务才有意义，全局已满时排队
+		if !appFree {
+			if _, err := j.cancelOldest(); err != nil {
+				return err
+			}
+		}
+	}
+	return j.enqueueAndDispatch()
+}
+
+// 加入队列后按顺序启动有空闲并发数的任务，返回任务最新的状态
+func (j *Job) enqueueAndDispatch() error {
+	if err := j.enqueue(); err != nil {
+		return err
+	}
+	if err := dispatchQueue(context.Background()); err != nil {
+		return err
+	}
+	if job, err := GetJob(j.Ctx, j.Id); err == nil {
+		j.Status = job.Status
+	}
+	return nil
+}
+
+// 加入队列，保存workflow等待空闲时创建
+func (j *Job) enqueue() error {
+	// 预先生成workflow名字作为任务Id，模板设置了固定的名字时直接使用
+	if j.Workflow.Name == "" {
+		if j.Workflow.GenerateName == "" {
+			return errors.New("workflow必须设置metadata.name或metadata.generateName")
+		}
+		j.Workflow.Name = j.Workflow.GenerateName + utilrand.String(5)
+		j.Workflow.GenerateName = ""
+	}
+	j.Id = j.Workflow.Name
+	j.WorkflowName = j.Id
+	j.StartTime = time.Now()
+	j.Status = JobStatusQueued
+
+	if err := RedisClient.Set(j.Ctx, JobSpecKey(j.Id), []byte(common.ParseJsonStr(j.Workflow)), defaultExpired).Err(); err != nil {
+		return err
+	}
+	if err := j.statusSave(); err != nil {
+		return err
+	}
+	score := float64(j.StartTime.UnixNano()) / 1e9
+	if err := RedisClient.ZAdd(j.Ctx, JobQueueKey(j.AppId), &redis.Z{Score: score, Member: j.Id}).Err(); err != nil {
+		return err
+	}
+	if err := RedisClient.ZAdd(j.Ctx, JobQueueKey(""), &redis.Z{Score: score, Member: j.Id}).Err(); err != nil {
+		return err
+	}
+	log.Infof("job[%s] queued", j.Id)
+	return nil
+}
+
+// 取消应用最早开始的运行中任务
+func (j *Job) cancelOldest() (bool, error) {
+	ids, err := RedisClient.SMembers(j.Ctx, JobActiveKey(j.AppId)).Result()
+	if err != nil {
+		return false, err
+	}
+	var oldest *Job
+	for _, id := range ids {
+		job, err := GetJob(j.Ctx, id)
+		if err != nil {
+			continue
+		}
+		if oldest == nil || job.StartTime.Before(oldest.StartTime) {
+			oldest = job
+		}
+	}
+	if oldest == nil {
+		return false, nil
+	}
+	if err := oldest.Cancel(JobCancelTerminate, "queue"); err != nil {
+		return false, errors.Wrapf(err, "cancel job %s", oldest.Id)
+	}
+	return true, nil
+}
+
+// 按顺序启动排队中的任务，直到没有空闲的并发数
+func DispatchQueue(ctx context.Context) error {
+	unlock, err := lockQueue(ctx)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	return dispatchQueue(ctx)
+}
+
+// 需要持有队列锁
+func dispatchQueue(ctx context.Context) error {
+	ids, err := RedisClient.ZRange(ctx, JobQueueKey(""), 0, -1).Result()
+	if err != nil {
+		return err
+	}
+	for _, id := range ids {
+		job, err := GetJob(ctx, id)
+		if err != nil || job.Status != JobStatusQueued {
+			job = &Job{Ctx: ctx, Id: id, AppId: appIdFromJobId(id)}
+			job.dequeue() // 任务已被删除或取消
+			continue
+		}
+		appFree, globalFree, err := freeSlots(ctx, job.AppId)
+		if err != nil {
+			return err
+		}
+		if !globalFree {
+			break
+		}
+		if !appFree {
+			continue // 应用并发数已满时，继续启动其他应用的任务
+		}
+		if err := job.startQueued(); err != nil {
+			log.Errorf("start queued job[%s]: %+v", job.Id, err)
+			job.Status = JobStatusError
+			job.statusSave()
+		}
+		job.dequeue()
+	}
+	return nil
+}
+
+func (j *Job) startQueued() error {
+	bs, err := RedisClient.Get(j.Ctx, JobSpecKey(j.Id)).Bytes()
+	if err != nil {
+		return errors.Wrap(err, "get queued workflow")
+	}
+	j.Workflow = &wfv1.Workflow{}
+	if err := json.Unmarshal(bs, j.Workflow); err != nil {
+		return err
+	}
+	log.Infof("start queued job[%s]", j.Id)
+	return j.Create()
+}
+
+// 从队列中移除
+func (j *Job) dequeue() {
+	RedisClient.ZRem(j.Ctx, JobQueueKey(""), j.Id)
+	RedisClient.ZRem(j.Ctx, JobQueueKey(j.AppId), j.Id)
+	RedisClient.Del(j.Ctx, JobSpecKey(j.Id))
+}
+
+// 排队的位置，从1开始，不在队列中时返回0
+func (j *Job) QueuePosition() int64 {
+	rank, err := RedisClient.ZRank(j.Ctx, JobQueueKey(j.AppId), j.Id).Result()
+	if err != nil {
+		return 0
+	}
+	return rank + 1
+}
+
+// 应用和全局是否还有空闲的并发数
+func freeSlots(ctx context.Context, appId string) (appFree, globalFree bool, err error) {
+	conf := config.Config.Queue
+	limit := conf.MaxPerApp
+	if l, ok := conf.AppLimits[appId]; ok {
+		limit = l
+	}
+	if appFree, err = belowLimit(ctx, JobActiveKey(appId), limit); err != nil {
+		return false, false, err
+	}
+	if globalFree, err = belowLimit(ctx, JobActiveKey(""), conf.MaxGlobal); err != nil {
+		return false, false, err
+	}
+	return appFree, globalFree, nil
+}
+
+// 集合中的任务数是否小于上限，上限为0表示不限制
+func belowLimit(ctx context.Context, key string, limit int) (bool, error) {
+	if limit <= 0 {
+		return true, nil
+	}
+	n, err := RedisClient.SCard(ctx, key).Result()
+	if err != nil {
+		return false, err
+	}
+	return n < int64(limit), nil
+}
+
+// 根据任务状态维护运行中任务的集合
+// 等待审批的时间不确定，不占用并发数，避免阻塞同一应用的其他任务
+func (j *Job) updateActive() {
+	if j.Status == JobStatusQueued {
+		return
+	}
+	if IsJobFinished(j.Status) || j.Status == JobStatusAwaitingApproval {
+		RedisClient.SRem(j.Ctx, JobActiveKey(""), j.Id)
+		RedisClient.SRem(j.Ctx, JobActiveKey(j.AppId), j.Id)
+		return
+	}
+	RedisClient.SAdd(j.Ctx, JobActiveKey(""), j.Id)
+	RedisClient.SAdd(j.Ctx, JobActiveKey(j.AppId), j.Id)
+}
+
+// 清理运行中集合里已结束、等待审批或已删除的任务
+func PruneActiveJobs(ctx context.Context) error {
+	ids, err := RedisClient.SMembers(ctx, JobActiveKey("")).Result()
+	if err != nil {
+		return err
+	}
+	for _, id := range ids {
+		job, err := GetJob(ctx, id)
+		if err == nil && !IsJobFinished(job.Status) && job.Status != JobStatusAwaitingApproval {
+			continue
+		}
+		RedisClient.SRem(ctx, JobActiveKey(""), id)
+		RedisClient.SRem(ctx, JobActiveKey(appIdFromJobId(id)), id)
+		if job != nil {
+			RedisClient.SRem(ctx, JobActiveKey(job.AppId), id)
+		}
+	}
+	return nil
+}
+
+// 多个副本之间的队列锁，持有期间自动续期
+func lockQueue(ctx context.Context) (func(), error) {
+	unlock, err := acquireLock(ctx, JobQueueLockKey(), queueLockTTL, queueLockTTL)
+	if err != nil {
+		return nil, errors.Wrap(err, "获取任务队列锁失败")
+	}
+	return unlock, nil
+}
+
+// 排队任务，appId为空时为全局队列
+func JobQueueKey(appId string) string {
+	if appId == "" {
+		return strings.Join([]string{"cicd", "job-queue"}, sep)
+	}
+	return strings.Join([]string{"cicd", "job-queue", appId}, sep)
+}
+
+// 运行中的任务，appId为空时为全部应用
+func JobActiveKey(appId string) string {
+	if appId == "" {
+		return strings.Join([]string{"cicd", "job-active"}, sep)
+	}
+	return strings.Join([]string{"cicd", "job-active", appId}, sep)
+}
+
+// 排队任务的workflow
+func JobSpecKey(id string) string {
+	return strings.Join([]string{"cicd", "job-spec", id}, sep)
+}
+
+func JobQueueLockKey() string {
+	return strings.Join([]string{"cicd", "job-queue-lock"}, sep)
+}
@@ -0,0 +1,75 @@
+package dao
+
+import (
+	"lyyops-cicd/pkg/log"
+	"time"
+)
+
+const (
+	ReconcileActionSync    = "sync"    // 按workflow最终状态修复
+	ReconcileActionLost    = "lost"    // workflow已不存在
+	ReconcileActionRewatch = "rewatch" // 重新监听运行中的workflow
+)
+
+// 任务记录的修复信息
+type JobCorrection struct {
+	JobId     string `json:"job_id"`
+	Action    string `json:"action"`
+	OldStatus string `json:"old_status"`
+	NewStatus string `json:"new_status"`
+}
+
+// 对比未结束任务和argo workflow的实际状态，修复任务状态、结束时间、耗时和阶段信息
+// 返回检查的任务数量和修复记录
+func ReconcileJobs() (int, []JobCorrection, error) {
+	var corrections = []JobCorrection{}
+	ctx, cli, err := NewArgoClient()
+	if err != nil {
+		return 0, corrections, err
+	}
+	svcCli := cli.NewWorkflowServiceClient()
+
+	jobs, err := ListUnfinishedJobs(ctx)
+	if err != nil {
+		return 0, corrections, err
+	}
+	for _, job := range jobs {
+		if job.Status == JobStatusQueued {
+			continue // 排队中的任务还没有workflow
+		}
+		correction := JobCorrection{JobId: job.Id, OldStatus: job.Status}
+		wf, err := getWorkflow(ctx, svcCli, job.workflowName())
+		switch {
+		case isWorkflowNotFound(err):
+			job.Status = JobStatusLost
+			job.EndTime = time.Now()
+			if err := job.statusSave(); err != nil {
+				log.Errorf("reconcile job[%s] save: %+v", job.Id, err)
+				continue
+			}
+			correction.Action = ReconcileActionLost
+		case err != nil:
+			log.Errorf("reconcile job[%s] get workflow: %+v", job.Id, err)
+			continue
+		case !wf.Status.FinishedAt.IsZero():
+			job.Workflow = wf
+			if err := job.syncWorkflow(wf); err != nil {
+				log.Errorf("reconcile job[%s] sync: %+v", job.Id, err)
+				continue
+			}
+			correction.Action = ReconcileActionSync
+		default:
+			// 运行中的workflow，没有进程在监听时重新监听
+			job.Workflow = wf
+			if !job.startWatch(ctx, svcCli) {
+				continue
+			}
+			correction.Action = ReconcileActionRewatch
+			job.Status = workflowStatus(wf)
+		}
+		correction.NewStatus = job.Status
+		log.Infof("reconcile job: %+v", correction)
+		corrections = append(corrections, correction)
+	}
+	return len(jobs), corrections, nil
+}
@@ -0,0 +1,94 @@
+package dao
+
+import (
+	"context"
+	"github.com/argoproj/argo-workflows/v3/pkg/apiclient/workflow"
+	wfv1 "github.com/argoproj/argo-workflows/v3/pkg/apis/workflow/v1alpha1"
+	"github.com/pkg/errors"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"lyyops-cicd/pkg/log"
+)
+
+// 服务启动时恢复未结束任务的监听
+// 已结束的workflow直接同步最终状态和阶段信息
+func ResumeJobs() error {
+	ctx, cli, err := NewArgoClient()
+	if err != nil {
+		return err
+	}
+	svcCli := cli.NewWorkflowServiceClient()
+
+	jobs, err := ListUnfinishedJobs(ctx)
+	if err != nil {
+		return err
+	}
+	log.Infof("resume %d unfinished jobs", len(jobs))
+	for _, job := range jobs {
+		if job.Status == JobStatusQueued {
+			continue // 排队中的任务由DispatchQueue启动
+		}
+		wf, err := getWorkflow(ctx, svcCli, job.workflowName())
+		if isWorkflowNotFound(err) {
+			log.Warningf("resume job[%s] workflow not found", job.Id)
+			continue
+		}
+		if err != nil {
+			log.Errorf("resume job[%s] get workflow: %+v", job.Id, err)
+			continue
+		}
+		job.Workflow = wf
+		if wf.Status.FinishedAt.IsZero() {
+			if job.startWatch(ctx, svcCli) {
+				log.Infof("resume job[%s] watch", job.Id)
+			}
+			continue
+		}
+		if err := job.syncWorkflow(wf); err != nil {
+			log.Errorf("resume job[%s] sync: %+v", job.Id, err)
+			continue
+		}
+		log.Infof("resume job[%s] finished: %s", job.Id, job.Status)
+	}
+	return nil
+}
+
+// 根据workflow的最终状态更新任务状态、结束时间和阶段信息
+func (j *Job) syncWorkflow(wf *wfv1.Workflow) error {
+	if err := j.phaseSave(wf); err != nil {
+		return err
+	}
+	j.Status = workflowStatus(wf)
+	if !wf.Status.FinishedAt.IsZero() {
+		j.EndTime = wf.Status.FinishedAt.Time
+	}
+	j.loadCancelled()
+	return j.statusSave()
+}
+
+func getWorkflow(ctx context.Context, svcCli workflow.WorkflowServiceClient, name string) (*wfv1.Workflow, error) {
+	wf, err := svcCli.GetWorkflow(ctx, &workflow.WorkflowGetRequest{
+		Name:      name,
+		Namespace: getNamespace(ctx),
+	})
+	if err != nil {
+		return nil, errors.Wrapf(err, "svcCli.GetWorkflow %s", name)
+	}
+	return wf, nil
+}
+
+// workflow不存在(已被删除)
+func isWorkflowNotFound(err error) bool {
+	return status.Code(errors.Cause(err)) == codes.NotFound
+}
+
+// workflow状态转换为任务状态，未开始的workflow视为Running
+func workflowStatus(wf *wfv1.Workflow) string {
+	if wf.Status.Phase == "" || wf.Status.Phase == wfv1.WorkflowPending {
+		return DefaultJobStatus
+	}
+	if approvalGate(wf) != "" {
+		return JobStatusAwaitingApproval
+	}
+	return string(wf.Status.Phase)
+}
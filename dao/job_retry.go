@@ -0,0 +1,104 @@
+package dao
+
+import (
+	"fmt"
+	"github.com/argoproj/argo-workflows/v3/pkg/apiclient/workflow"
+	"github.com/pkg/errors"
+	"lyyops-cicd/pkg/log"
+	"time"
+)
+
+const (
+	JobActionResubmit = "resubmit"
+	JobActionRetry    = "retry"
+)
+
+// 重新提交任务: 使用原任务传入的参数和代码版本创建新的workflow，调用者为j.CreatedBy
+// 只重放调用方传入的参数，其他参数使用应用当前的默认值；应用中已删除的参数会被忽略
+func (j *Job) Resubmit() (*Job, error) {
+	orig, err := GetJob(j.Ctx, j.Id)
+	if err != nil {
+		return nil, err
+	}
+	params := orig.Overrides
+	if params == nil {
+		params = orig.Parameters // 旧的任务记录没有保存传入的参数
+	}
+	names, err := ApplicationParameterNames(j.Ctx, orig.AppId)
+	if err != nil {
+		return nil, err
+	}
+	params, dropped := knownParameters(params, names)
+	if len(dropped) > 0 {
+		log.Warningf("job[%s] resubmit: parameters removed from application: %v", orig.Id, dropped)
+	}
+	job, err := NewJobFromApplication(j.Ctx, orig.AppId, params)
+	if err != nil {
+		return nil, err
+	}
+	job.OriginJob = orig.Id
+	job.OriginAction = JobActionResubmit
+	job.CreatedBy = j.CreatedBy
+	job.Branch = orig.Branch // commit在提交时重新解析，分支的最新commit可能已经变化
+	job.Tag = orig.Tag
+	if err := job.Submit(""); err != nil {
+		return nil, err
+	}
+	log.Infof("job[%s] resubmitted as %s", orig.Id, job.Id)
+	return job, nil
+}
+
+// 重试任务: 调用argo RetryWorkflow，只重新执行失败的节点，调用者为j.CreatedBy
+func (j *Job) Retry() (*Job, error) {
+	orig, err := GetJob(j.Ctx, j.Id)
+	if err != nil {
+		return nil, err
+	}
+	if orig.Status != JobStatusFailed && orig.Status != JobStatusError {
+		return nil, errors.Errorf("任务 %s 状态为 %s，只能重试失败的任务", orig.Id, orig.Status)
+	}
+
+	ctx, cli, err := NewArgoClient()
+	if err != nil {
+		return nil, err
+	}
+	svcCli := cli.NewWorkflowServiceClient()
+	wf, err := svcCli.RetryWorkflow(ctx, &workflow.WorkflowRetryRequest{
+		Name:      orig.workflowName(),
+		Namespace: getNamespace(ctx),
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "svcCli.RetryWorkflow")
+	}
+
+	// 重试后workflow名字不变，用重试次数生成新的任务Id
+	count, err := RedisClient.HIncrBy(j.Ctx, JobStatusKey(orig.Id), "retry_count", 1).Result()
+	if err != nil {
+		return nil, err
+	}
+	job := &Job{
+		Ctx:          j.Ctx,
+		Id:           fmt.Sprintf("%s-retry%d", orig.Id, count),
+		StartTime:    time.Now(),
+		Status:       DefaultJobStatus,
+		AppId:        orig.AppId,
+		WorkflowName: orig.workflowName(),
+		Parameters:   orig.Parameters,
+		Overrides:    orig.Overrides,
+		OriginJob:    orig.Id,
+		OriginAction: JobActionRetry,
+		CreatedBy:    j.CreatedBy,
+		Commit:       orig.Commit,
+		Branch:       orig.Branch,
+		Tag:          orig.Tag,
+		Revision:     orig.Revision,
+		Workflow:     wf,
+		PhaseNames:   orig.PhaseNames,
+	}
+	if err := job.statusSave(); err != nil {
+		return nil, err
+	}
+	job.startWatch(ctx, svcCli)
+	log.Infof("job[%s] retried as %s", orig.Id, job.Id)
+	return job, nil
+}
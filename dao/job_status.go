@@ -1,51 +1,151 @@
 package dao
 
 import (
+	"context"
+	"encoding/json"
 	"github.com/pkg/errors"
 	"lyyops-cicd/dto"
+	"lyyops-cicd/pkg/common"
 	"lyyops-cicd/pkg/log"
+	"strconv"
 	"strings"
 	"time"
 )
 
 const DefaultJobStatus string = "Running"
 
+const (
+	JobStatusSucceeded = "Succeeded"
+	JobStatusFailed    = "Failed"
+	JobStatusError     = "Error"
+	JobStatusCancelled = "Cancelled"
+	JobStatusLost      = "Lost" // workflow已不存在
+)
+
+const (
+	JobTriggerApi      = "api"
+	JobTriggerWebhook  = "webhook"
+	JobTriggerSchedule = "schedule"
+)
+
+// 分支、commit和仓库地址对应的workflow参数名
+const (
+	ParamBranch  = "branch"
+	ParamCommit  = "commit"
+	ParamRepoUrl = "repo_url"
+)
+
+// 任务记录不存在(已过期或删除)
+var ErrJobNotFound = errors.New("job not found")
+
+// 任务是否已结束
+func IsJobFinished(status string) bool {
+	switch status {
+	case JobStatusSucceeded, JobStatusFailed, JobStatusError, JobStatusCancelled, JobStatusLost:
+		return true
+	}
+	return false
+}
+
 func (j *Job) GetStatusAndPhase(out *dto.GetJobOutput) (err error) {
-	var ok bool
 	hres, err := RedisClient.HGetAll(j.Ctx, JobStatusKey(j.Id)).Result()
 	if err != nil {
 		return err
 	}
-	if j.StartTime, err = time.Parse(time.RFC3339, hres["start_time"]); err != nil {
+	if err = j.loadStatus(hres); err != nil {
 		return err
 	}
-	// 如果没有结束时间，则根据当前时间来计算耗时
-	j.EndTime, err = time.Parse(time.RFC3339, hres["end_time"])
-	j.setCost()
-
-	if j.Status, ok = hres["status"]; !ok {
-		j.Status = DefaultJobStatus // DB 查不到，给默认值
-	}
 
 	// 处理阶段名字和状态
-	fetch_phase_names, ok := hres["phase_names"]
-	if !ok {
+	if _, ok := hres["phase_names"]; !ok {
 		return errors.New("key phase_names not found")
 	}
-	names := strings.Split(fetch_phase_names, ",") // 获取阶段的顺序名字
-	j.PhaseNames = names
 	phases, err := j.GetPhases()
 	if err != nil {
 		return err
 	}
-	log.Debugf("phase name: %v, %v", names, phases)
+	log.Debugf("phase name: %v, %v", j.PhaseNames, phases)
 	out.PhaseList = phases
+	out.RunningPhases, out.FailedPhase, out.FailedMessage = phaseSummaryOutput(phases)
 	return
 }
 
+// 获取任务记录(不包含阶段信息)
+func GetJob(ctx context.Context, id string) (*Job, error) {
+	hres, err := RedisClient.HGetAll(ctx, JobStatusKey(id)).Result()
+	if err != nil {
+		return nil, err
+	}
+	if len(hres) == 0 {
+		return nil, errors.Wrapf(ErrJobNotFound, "任务 %s 不存在", id)
+	}
+	j := &Job{Ctx: ctx, Id: id}
+	return j, j.loadStatus(hres)
+}
+
+// 从DB的hash记录中解析任务信息
+func (j *Job) loadStatus(hres map[string]string) (err error) {
+	var ok bool
+	if j.StartTime, err = time.Parse(time.RFC3339, hres["start_time"]); err != nil {
+		return err
+	}
+	// 如果没有结束时间，则根据当前时间来计算耗时
+	j.EndTime, _ = time.Parse(time.RFC3339, hres["end_time"])
+	j.setCost()
+
+	if j.Status, ok = hres["status"]; !ok {
+		j.Status = DefaultJobStatus // DB 查不到，给默认值
+	}
+	j.CancelledBy = hres["cancelled_by"]
+	j.CancelledAt, _ = time.Parse(time.RFC3339, hres["cancelled_at"])
+
+	j.AppId = hres["app_id"]
+	if j.AppId == "" {
+		j.AppId = appIdFromJobId(j.Id) // 兼容旧的任务记录
+	}
+	j.WorkflowName = hres["workflow"]
+	j.OriginJob = hres["origin_job"]
+	j.OriginAction = hres["origin_action"]
+	j.RetryCount, _ = strconv.Atoi(hres["retry_count"])
+	j.ScheduleId = hres["schedule_id"]
+	j.Trigger = hres["trigger"]
+	if j.Trigger == "" {
+		j.Trigger = j.defaultTrigger() // 兼容旧的任务记录
+	}
+	j.ApprovalGate = hres["approval_gate"]
+	j.CreatedBy = hres["created_by"]
+	j.Commit = hres["commit"]
+	j.Branch = hres["branch"]
+	j.Tag = hres["tag"]
+	j.Revision = hres["revision"]
+	j.LogTime, _ = time.Parse(time.RFC3339Nano, hres["log_time"]) // 兼容旧的秒级时间
+	j.Redactions, _ = strconv.Atoi(hres["redactions"])
+	j.ArchivedAt, _ = time.Parse(time.RFC3339, hres["archived_at"])
+	j.LogsDoneAt, _ = time.Parse(time.RFC3339Nano, hres["logs_done_at"])
+	if params := hres["parameters"]; params != "" {
+		if err := json.Unmarshal([]byte(params), &j.Parameters); err != nil {
+			log.Warningf("job[%s] parameters unmarshal: %v", j.Id, err)
+		}
+	}
+	if overrides := hres["overrides"]; overrides != "" {
+		if err := json.Unmarshal([]byte(overrides), &j.Overrides); err != nil {
+			log.Warningf("job[%s] overrides unmarshal: %v", j.Id, err)
+		}
+	}
+	if names := hres["phase_names"]; names != "" {
+		j.PhaseNames = strings.Split(names, ",") // 获取阶段的顺序名字
+	}
+	j.setSource() // 兼容旧的任务记录
+	return nil
+}
+
 func (j *Job) statusSave() error {
 	var err error
 	j.setCost() // 保存前先计算时间
+	if j.Trigger == "" {
+		j.Trigger = j.defaultTrigger()
+	}
+	j.setSource()
 	log.Debug(j.Id, j.Status, j.PhaseNames)
 	names := strings.Join(j.PhaseNames, ",") // 逗号拼接名字列表
 	if err = RedisClient.HMSet(j.Ctx, JobStatusKey(j.Id),
@@ -54,15 +154,117 @@ func (j *Job) statusSave() error {
 		"status", j.Status,
 		"phase_names", names,
 		"cost", j.Cost,
+		"app_id", j.AppId,
+		"workflow", j.workflowName(),
+		"parameters", common.ParseJsonStr(j.Parameters),
+		"overrides", common.ParseJsonStr(j.Overrides),
+		"origin_job", j.OriginJob,
+		"origin_action", j.OriginAction,
+		"schedule_id", j.ScheduleId,
+		"trigger", j.Trigger,
+		"approval_gate", j.ApprovalGate,
+		"created_by", j.CreatedBy,
+		"commit", j.Commit,
+		"branch", j.Branch,
+		"tag", j.Tag,
+		"revision", j.Revision,
 	).Err(); err != nil {
 		return err
 	}
+	j.updateActive()
+	j.updateIndex()
 
 	err = RedisClient.Expire(j.Ctx, JobStatusKey(j.Id), defaultExpired).Err() // 设定过期时间
 	return err
 }
 
+// 任务对应的argo workflow名字，重试任务和原任务共用一个workflow
+func (j *Job) workflowName() string {
+	if j.WorkflowName != "" {
+		return j.WorkflowName
+	}
+	return j.Id
+}
+
+// 没有记录触发方式时，根据原任务和定时任务推断
+func (j *Job) defaultTrigger() string {
+	switch {
+	case j.OriginAction != "":
+		return j.OriginAction
+	case j.ScheduleId != "":
+		return JobTriggerSchedule
+	}
+	return JobTriggerApi
+}
+
+// 没有记录分支和commit时，从workflow参数中获取，commit参数不是完整的sha时不作为commit
+func (j *Job) setSource() {
+	if j.Branch == "" && j.Tag == "" {
+		j.Branch = j.Parameters[ParamBranch] // tag触发时分支参数是tag名，不作为分支
+	}
+	if j.Commit == "" && isCommitSha(j.Parameters[ParamCommit]) {
+		j.Commit = j.Parameters[ParamCommit]
+	}
+}
+
+// 提交时把commit参数(分支、tag)或分支解析成commit sha，无法解析时commit为空
+// 仓库地址取workflow参数repo_url，没有时取应用的webhook触发规则
+func (j *Job) resolveCommit() {
+	j.setSource()
+	if j.Commit != "" {
+		return
+	}
+	ref := j.Parameters[ParamCommit]
+	if ref == "" {
+		ref = j.Branch
+	}
+	repo := j.repoUrl()
+	if ref == "" || repo == "" {
+		return
+	}
+	sha, err := lsRemote(j.Ctx, repo, ref)
+	if err != nil {
+		log.Warningf("app[%s] resolve commit %s: %v", j.AppId, ref, err)
+		return
+	}
+	j.Commit = sha
+}
+
+func (j *Job) repoUrl() string {
+	if u := j.Parameters[ParamRepoUrl]; u != "" {
+		return u
+	}
+	triggers, err := ListWebhookTrigger(j.Ctx, j.AppId)
+	if err != nil {
+		return ""
+	}
+	for _, t := range triggers {
+		if t.RepoUrl != "" {
+			return t.RepoUrl
+		}
+	}
+	return ""
+}
+
+// workflow名字由应用名(GenerateName)和随机后缀组成
+func appIdFromJobId(id string) string {
+	if i := strings.LastIndex(id, "-"); i > 0 {
+		return id[:i]
+	}
+	return id
+}
+
 func (j *Job) deleteStatus() error {
+	if hres, err := RedisClient.HGetAll(j.Ctx, JobStatusKey(j.Id)).Result(); err == nil && len(hres) > 0 {
+		j.loadStatus(hres) // 需要应用、分支等信息来清理索引
+	}
+	if j.AppId == "" {
+		j.AppId = appIdFromJobId(j.Id)
+	}
+	j.deleteIndex()
+	j.dequeue()
+	RedisClient.SRem(j.Ctx, JobActiveKey(""), j.Id)
+	RedisClient.SRem(j.Ctx, JobActiveKey(j.AppId), j.Id)
 	return RedisClient.Del(j.Ctx, JobStatusKey(j.Id)).Err()
 }
 
@@ -0,0 +1,30 @@
+package dao
+
+import (
+	"strings"
+	"time"
+)
+
+// 任务监听锁: 同一个任务只允许一个进程监听workflow和采集日志
+// 使用acquireLock的随机token，持有期间自动续期，释放时只删除自己持有的锁
+const watchLockTTL = 90 * time.Second
+
+// 获取监听锁，已被其他进程持有时返回false
+func (j *Job) acquireWatch() bool {
+	unlock, err := acquireLock(j.Ctx, JobWatchKey(j.Id), watchLockTTL, 0)
+	if err != nil {
+		return false
+	}
+	j.unwatch = unlock
+	return true
+}
+
+func (j *Job) releaseWatch() {
+	if j.unwatch != nil {
+		j.unwatch()
+	}
+}
+
+func JobWatchKey(id string) string {
+	return strings.Join([]string{"cicd", "job-watch", id}, sep)
+}
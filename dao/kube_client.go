@@ -0,0 +1,16 @@
+package dao
+
+import (
+	"github.com/argoproj/argo-workflows/v3/cmd/argo/commands/client"
+	"github.com/pkg/errors"
+	"k8s.io/client-go/kubernetes"
+)
+
+// k8s client，与argo client使用相同的kubeconfig
+func NewKubeClient() (kubernetes.Interface, error) {
+	restConfig, err := client.GetConfig().ClientConfig()
+	if err != nil {
+		return nil, errors.Wrap(err, "kubeconfig")
+	}
+	return kubernetes.NewForConfig(restConfig)
+}
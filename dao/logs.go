@@ -2,33 +2,201 @@ package dao
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
 	"github.com/argoproj/argo-workflows/v3/pkg/apiclient/workflow"
+	"github.com/argoproj/argo-workflows/v3/workflow/common"
+	"github.com/go-redis/redis/v8"
 	"io"
 	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"lyyops-cicd/config"
 	"lyyops-cicd/pkg/log"
+	"sort"
 	"strings"
 	"time"
 )
 
 const defaultExpired = time.Hour * 24 * 100 // 100天
 
-// 获取pod日志
-func GetLog(ctx context.Context, podName string) ([]string, error) {
-	log.Debugf("log key: %s", LogsKey(podName))
-	str, err := RedisClient.LRange(ctx, LogsKey(podName), 0, -1).Result()
-	return str, err
+// 合并所有容器的日志
+const AllContainers = "all"
+
+// 获取pod日志(main容器)
+func GetLog(ctx context.Context, podName string) ([]LogRecord, error) {
+	return GetContainerLog(ctx, podName, common.MainContainerName)
+}
+
+// 获取pod中一个容器的日志
+func GetContainerLog(ctx context.Context, podName, container string) ([]LogRecord, error) {
+	return GetLogRange(ctx, podName, container, 0, -1)
+}
+
+// 按时间合并多个容器的日志
+func MergeContainerLogs(ctx context.Context, podName string, containers []string) ([]LogRecord, error) {
+	var records = []LogRecord{}
+	for _, container := range containers {
+		res, err := GetContainerLog(ctx, podName, container)
+		if err != nil {
+			return nil, err
+		}
+		records = append(records, res...)
+	}
+	sort.SliceStable(records, func(i, j int) bool {
+		return records[i].Time.Before(records[j].Time)
+	})
+	return records, nil
 }
 
 // 创建pod日志
-func LogsSave(ctx context.Context, podName, line string) error {
-	if err := RedisClient.RPush(ctx, LogsKey(podName), line).Err(); err != nil {
+func LogsSave(ctx context.Context, record *LogRecord) error {
+	key := ContainerLogsKey(record.Pod, record.Container)
+	if err := RedisClient.RPush(ctx, key, record.String()).Err(); err != nil {
 		return err
 	}
-	return RedisClient.Expire(ctx, LogsKey(podName), defaultExpired).Err()
+	return RedisClient.Expire(ctx, key, defaultExpired).Err()
+}
+
+// 读取pod日志的[start, stop]行，stop为-1时读到最后
+func GetLogRange(ctx context.Context, podName, container string, start, stop int64) ([]LogRecord, error) {
+	log.Debugf("log key: %s", ContainerLogsKey(podName, container))
+	raws, err := getLogLines(ctx, podName, container, start, stop)
+	if err != nil {
+		return nil, err
+	}
+	return parseLogRecords(raws, podName, container, start), nil
+}
+
+// pod日志的行数
+func LogLength(ctx context.Context, podName, container string) (int64, error) {
+	lines, _, err := archivedLogs(ctx, podName, container)
+	if err != nil {
+		return 0, err
+	}
+	n, err := RedisClient.LLen(ctx, ContainerLogsKey(podName, container)).Result()
+	return int64(len(lines)) + n, err // 归档后继续采集的日志接在归档之后
+}
+
+// 第一行时间不早于since的行号，日志按采集时间顺序保存，二分查找
+func LogOffsetSince(ctx context.Context, podName, container string, since time.Time) (int64, error) {
+	hi, err := LogLength(ctx, podName, container)
+	if err != nil {
+		return 0, err
+	}
+	var lo int64
+	for lo < hi {
+		mid := (lo + hi) / 2
+		raws, err := getLogLines(ctx, podName, container, mid, mid)
+		if err != nil {
+			return 0, err
+		}
+		if len(raws) == 0 {
+			return mid, nil
+		}
+		raw := raws[0]
+		if ParseLogRecord(raw, podName, container, mid+1).Time.Before(since) {
+			lo = mid + 1
+		} else {
+			hi = mid
+		}
+	}
+	return lo, nil
+}
+
+// pod所属的任务，用于判断日志是否还会继续输出
+// 还没有采集到日志或旧版本的pod没有记录时，从任务的阶段中查找，找不到时返回空
+func LogJobId(ctx context.Context, podName string) string {
+	if id, _ := RedisClient.Get(ctx, LogJobKey(podName)).Result(); id != "" {
+		return id
+	}
+	return jobIdFromPhases(ctx, podName)
 }
 
-func logWorkflow(ctx context.Context, serviceClient workflow.WorkflowServiceClient, job *Job, namespace, workflowName, podName string, logOptions *corev1.PodLogOptions) {
+// pod名字以workflow名字开头，依次尝试每个前缀，阶段中包含该pod的任务即为所属任务
+// 重试的任务和原任务共用pod，返回最后一次重试的任务
+func jobIdFromPhases(ctx context.Context, podName string) string {
+	for i := strings.LastIndex(podName, "-"); i > 0; i = strings.LastIndex(podName[:i], "-") {
+		job, err := GetJob(ctx, podName[:i])
+		if err != nil {
+			continue
+		}
+		ids := []string{job.Id}
+		if job.RetryCount > 0 {
+			ids = append([]string{fmt.Sprintf("%s-retry%d", job.Id, job.RetryCount)}, ids...)
+		}
+		for _, id := range ids {
+			pods, err := (&Job{Ctx: ctx, Id: id}).PodNames()
+			if err == nil && containsString(pods, podName) {
+				return id
+			}
+		}
+	}
+	return ""
+}
+
+// 任务的日志是否已完整: 结束后日志采集完成或已归档
+// 旧的任务和服务重启时没有完成记录，结束超过等待日志采集的时间后视为完整
+func (j *Job) LogsComplete() bool {
+	if !j.LogsDoneAt.IsZero() || !j.ArchivedAt.IsZero() {
+		return true
+	}
+	return IsJobFinished(j.Status) && time.Since(j.EndTime) >= archiveWaitLogs
+}
+
+// pod中已采集日志的容器，main容器排在最前
+func PodContainers(ctx context.Context, podName string) ([]string, error) {
+	containers, err := RedisClient.SMembers(ctx, LogContainersKey(podName)).Result()
+	if err != nil {
+		return nil, err
+	}
+	if len(containers) == 0 {
+		return []string{common.MainContainerName}, nil // 旧的日志只有main容器
+	}
+	sort.Slice(containers, func(i, j int) bool {
+		if (containers[i] == common.MainContainerName) != (containers[j] == common.MainContainerName) {
+			return containers[i] == common.MainContainerName
+		}
+		return containers[i] < containers[j]
+	})
+	return containers, nil
+}
+
+// 应用需要采集日志的容器
+func LogContainers(appId string) []string {
+	conf := config.Config.Logs
+	if containers, ok := conf.AppContainers[appId]; ok && len(containers) > 0 {
+		return containers
+	}
+	if len(conf.Containers) > 0 {
+		return conf.Containers
+	}
+	return []string{common.MainContainerName}
+}
+
+// 删除pod日志
+func deleteLogs(ctx context.Context, podNames []string) error {
+	if len(podNames) == 0 {
+		return nil
+	}
+	var keys []string
+	for _, pod := range podNames {
+		containers, err := PodContainers(ctx, pod)
+		if err != nil {
+			return err
+		}
+		for _, container := range containers {
+			keys = append(keys, ContainerLogsKey(pod, container))
+		}
+		if err := deleteArchives(ctx, pod); err != nil {
+			return err
+		}
+		keys = append(keys, LogsKey(pod), LogJobKey(pod), LogContainersKey(pod), LogArchiveKey(pod))
+	}
+	return RedisClient.Del(ctx, keys...).Err()
+}
+
+// 采集workflow中所有pod的一个容器(logOptions.Container)的日志
+func logWorkflow(ctx context.Context, serviceClient workflow.WorkflowServiceClient, job *Job, namespace, workflowName, podName string, logOptions *corev1.PodLogOptions, redact *redactor) {
 	// logs
 	stream, err := serviceClient.WorkflowLogs(ctx, &workflow.WorkflowLogRequest{
 		Name:       workflowName,
@@ -42,7 +210,11 @@ func logWorkflow(ctx context.Context, serviceClient workflow.WorkflowServiceClie
 	}
 
 	// loop on log lines
-	log.Debugf("workflow[%s] start recv log stream......", job.Id)
+	log.Debugf("workflow[%s] start recv %s log stream......", job.Id, logOptions.Container)
+	var (
+		logTime time.Time
+		pods    = map[string]*podLog{}
+	)
 	for {
 		event, err := stream.Recv()
 
@@ -53,19 +225,136 @@ func logWorkflow(ctx context.Context, serviceClient workflow.WorkflowServiceClie
 			log.Errorf("log workflow[%s] stream recv error: %+v", job.Id, err)
 			return
 		}
-		// add now time
-		text := fmt.Sprintf("%s %s", time.Now().Format(time.RFC3339), event.Content)
-		if err := LogsSave(ctx, event.PodName, text); err != nil {
+		pod, ok := pods[event.PodName]
+		if !ok {
+			pod = newPodLog(ctx, job.Id, event.PodName, logOptions.Container)
+			pods[event.PodName] = pod
+		}
+		if pod.step == "" && time.Since(pod.lookup) >= time.Second {
+			pod.lookup = time.Now()
+			pod.step = podStepName(ctx, job.Id, event.PodName) // 阶段信息可能晚于日志保存
+		}
+		record := newLogRecord(event.PodName, logOptions.Container, event.Content)
+		if !pod.since.IsZero() {
+			if !record.Time.After(pod.since) {
+				continue // 恢复采集时跳过已保存的日志
+			}
+			pod.since = time.Time{}
+		}
+		pod.seq++
+		record.Seq = pod.seq
+		record.Step = pod.step
+		content, n := redact.Redact(record.Content) // 保存前隐藏敏感内容
+		record.Content = content
+		job.addRedactions(n)
+		if err := LogsSave(ctx, record); err != nil {
+			pod.seq--
 			log.Errorf("logs workflow[%s] save: %+v", job.Id, err)
 			continue
 		}
+		// 记录接收日志的时间(每秒最多一次)
+		if now := time.Now(); now.Sub(logTime) >= time.Second {
+			logTime = now
+			if err := hsetIfExists(ctx, JobStatusKey(job.Id), "log_time", now.Format(time.RFC3339Nano)); err != nil {
+				log.Warningf("logs workflow[%s] save log_time: %v", job.Id, err)
+			}
+		}
 	}
 }
 
+// 只更新已存在的hash，任务已被清理时不重新创建没有过期时间的记录
+var hsetIfExistsScript = redis.NewScript(`
+if redis.call("exists", KEYS[1]) == 1 then
+	return redis.call("hset", KEYS[1], ARGV[1], ARGV[2])
+end
+return 0`)
+
+func hsetIfExists(ctx context.Context, key, field, value string) error {
+	return hsetIfExistsScript.Run(ctx, RedisClient, []string{key}, field, value).Err()
+}
+
+// 一个pod容器的采集状态
+type podLog struct {
+	seq    int64     // 已保存的行数
+	since  time.Time // 已保存的最后一行在pod中的时间
+	step   string
+	lookup time.Time // 上次查询步骤名的时间
+}
+
+// 第一次收到pod的日志时，记录所属任务和容器，恢复监听时从已保存的行数继续编号
+func newPodLog(ctx context.Context, jobId, podName, container string) *podLog {
+	RedisClient.Set(ctx, LogJobKey(podName), jobId, defaultExpired)
+	RedisClient.SAdd(ctx, LogContainersKey(podName), container)
+	RedisClient.Expire(ctx, LogContainersKey(podName), defaultExpired)
+	seq, _ := LogLength(ctx, podName, container)
+	return &podLog{seq: seq, since: lastLogTime(ctx, podName, container)}
+}
+
+// 已保存的最后一行日志在pod中的时间，没有日志时为零值
+func lastLogTime(ctx context.Context, podName, container string) time.Time {
+	raws, err := getLogLines(ctx, podName, container, -1, -1)
+	if err != nil || len(raws) == 0 {
+		return time.Time{}
+	}
+	return ParseLogRecord(raws[0], podName, container, 0).Time
+}
+
+// 恢复采集时一个容器日志的开始时间: 所有pod已保存的最后一行中最早的时间
+// 有pod还没有保存日志时从头采集，已保存的行由podLog.since跳过
+func (j *Job) logSinceTime(ctx context.Context, container string) *metav1.Time {
+	pods, err := (&Job{Ctx: ctx, Id: j.Id}).PodNames()
+	if err != nil || len(pods) == 0 {
+		return nil
+	}
+	var since time.Time
+	for _, pod := range pods {
+		t := lastLogTime(ctx, pod, container)
+		if t.IsZero() {
+			return nil
+		}
+		if since.IsZero() || t.Before(since) {
+			since = t
+		}
+	}
+	t := metav1.NewTime(since)
+	return &t
+}
+
+// pod对应的步骤名
+func podStepName(ctx context.Context, jobId, podName string) string {
+	data, err := RedisClient.HGet(ctx, JobPhaseKey(jobId), podName).Result()
+	if err != nil {
+		return ""
+	}
+	phase := JobPhaseStatus{}
+	if err := json.Unmarshal([]byte(data), &phase); err != nil {
+		return ""
+	}
+	return phase.Name
+}
+
 func LogsKey(id string) string {
 	return strings.Join([]string{"cicd", "logs", id}, sep)
 }
 
+// 容器的日志，main容器与旧的日志共用LogsKey
+func ContainerLogsKey(podName, container string) string {
+	if container == "" || container == common.MainContainerName {
+		return LogsKey(podName)
+	}
+	return strings.Join([]string{"cicd", "logs", podName, container}, sep)
+}
+
+// pod中已采集日志的容器
+func LogContainersKey(podName string) string {
+	return strings.Join([]string{"cicd", "log-containers", podName}, sep)
+}
+
+// pod对应的任务Id
+func LogJobKey(podName string) string {
+	return strings.Join([]string{"cicd", "log-job", podName}, sep)
+}
+
 func ExtractLogsName(fullname string) string {
 	names := strings.Split(fullname, sep)
 	return names[2]
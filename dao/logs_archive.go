@@ -0,0 +1,416 @@
+package dao
+
+// 日志归档: 任务结束后把每个pod容器的日志压缩成gzip保存到归档存储，并删除redis中的日志
+// 读取日志时，已归档的容器从归档中读取
+// 重试任务和原任务共用pod，原任务归档后重试任务新采集的日志接在归档之后，重试任务归档时与之前的归档合并
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"github.com/go-redis/redis/v8"
+	"github.com/pkg/errors"
+	"io/ioutil"
+	"lyyops-cicd/config"
+	"lyyops-cicd/pkg/log"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	archiveWaitLogs   = 5 * time.Minute  // 任务结束后等待日志采集完成的最长时间
+	archiveRetryDelay = 10 * time.Minute // 结束超过该时间仍未归档的任务，由定时任务重新归档
+	archiveCacheTTL   = time.Minute
+	archiveCacheSize  = 32
+)
+
+var (
+	archiveMu           sync.Mutex
+	defaultArchiveStore archiveStore
+)
+
+// 日志条数和之前的归档都没有变化时记录归档并删除redis中的日志，否则返回0
+// KEYS: 容器日志, pod的归档, 归档索引; ARGV: 归档的日志条数, 容器名, 归档名, 过期时间, 之前的归档名
+var archiveScript = redis.NewScript(`
+if redis.call("llen", KEYS[1]) ~= tonumber(ARGV[1]) then
+	return 0
+end
+if (redis.call("hget", KEYS[2], ARGV[2]) or "") ~= ARGV[5] then
+	return 0
+end
+redis.call("hset", KEYS[2], ARGV[2], ARGV[3])
+redis.call("zadd", KEYS[3], ARGV[4], ARGV[3])
+redis.call("del", KEYS[1])
+return 1`)
+
+// 归档存储，未开启归档时返回nil
+func getArchiveStore() (archiveStore, error) {
+	conf := config.Config.Logs.Archive
+	if !conf.Enabled {
+		return nil, nil
+	}
+	archiveMu.Lock()
+	defer archiveMu.Unlock()
+	if defaultArchiveStore == nil {
+		store, err := newArchiveStore(conf)
+		if err != nil {
+			return nil, err
+		}
+		defaultArchiveStore = store
+	}
+	return defaultArchiveStore, nil
+}
+
+// 应用日志归档的保留时间
+func LogRetention(appId string) time.Duration {
+	conf := config.Config.Logs.Archive
+	if d, ok := conf.AppRetention[appId]; ok && d > 0 {
+		return d
+	}
+	if conf.Retention > 0 {
+		return conf.Retention
+	}
+	return defaultExpired
+}
+
+// 等待日志采集结束后记录日志已完整(持续输出的日志据此结束)，再归档
+// 归档失败的任务保留在待归档集合中，由定时任务重试
+func (j *Job) afterLogs(wg *sync.WaitGroup) {
+	ctx := context.Background()
+	archive := config.Config.Logs.Archive.Enabled
+	if archive {
+		if err := RedisClient.SAdd(ctx, LogArchivePendingKey(), j.Id).Err(); err != nil {
+			log.Errorf("job[%s] archive pending: %v", j.Id, err)
+		}
+	}
+	go func() {
+		done := make(chan struct{})
+		go func() {
+			wg.Wait()
+			close(done)
+		}()
+		select {
+		case <-done:
+		case <-time.After(archiveWaitLogs):
+			log.Warningf("job[%s] archive: wait logs timeout", j.Id)
+		}
+		if err := hsetIfExists(ctx, JobStatusKey(j.Id), "logs_done_at", time.Now().Format(time.RFC3339Nano)); err != nil {
+			log.Errorf("job[%s] save logs_done_at: %v", j.Id, err)
+		}
+		if !archive {
+			return
+		}
+		if err := j.ArchiveLogs(ctx); err != nil {
+			log.Errorf("job[%s] archive logs: %+v", j.Id, err)
+		}
+	}()
+}
+
+// 归档任务所有pod容器的日志
+func (j *Job) ArchiveLogs(ctx context.Context) error {
+	store, err := getArchiveStore()
+	if err != nil || store == nil {
+		return err
+	}
+	pods, err := j.PodNames()
+	if err != nil {
+		return errors.Wrap(err, "job.PodNames")
+	}
+	retention := LogRetention(j.AppId)
+	for _, pod := range pods {
+		containers, err := PodContainers(ctx, pod)
+		if err != nil {
+			return err
+		}
+		for _, container := range containers {
+			if err := j.archiveContainer(ctx, store, pod, container, retention); err != nil {
+				return errors.Wrapf(err, "archive %s/%s", pod, container)
+			}
+		}
+		// 归档后日志的元数据按保留时间过期
+		pipe := RedisClient.Pipeline()
+		pipe.Expire(ctx, LogJobKey(pod), retention)
+		pipe.Expire(ctx, LogContainersKey(pod), retention)
+		pipe.Expire(ctx, LogArchiveKey(pod), retention)
+		if _, err := pipe.Exec(ctx); err != nil {
+			return err
+		}
+	}
+
+	if err := hsetIfExists(ctx, JobStatusKey(j.Id), "archived_at", time.Now().Format(time.RFC3339)); err != nil {
+		return err
+	}
+	if err := RedisClient.SRem(ctx, LogArchivePendingKey(), j.Id).Err(); err != nil {
+		return err
+	}
+	log.Infof("job[%s] logs archived, pods: %v", j.Id, pods)
+	return nil
+}
+
+// 压缩保存一个容器的日志，保存成功后删除redis中的日志
+func (j *Job) archiveContainer(ctx context.Context, store archiveStore, pod, container string, retention time.Duration) error {
+	key := ContainerLogsKey(pod, container)
+	raws, err := RedisClient.LRange(ctx, key, 0, -1).Result()
+	if err != nil || len(raws) == 0 {
+		return err // 没有日志或已归档
+	}
+	// 已有归档(原任务已归档)时合并到新的归档中
+	old, err := RedisClient.HGet(ctx, LogArchiveKey(pod), container).Result()
+	if err != nil && err != redis.Nil {
+		return err
+	}
+	lines := raws
+	name := path.Join(j.AppId, j.Id, pod, container+".log.gz")
+	if old != "" {
+		archived, _, err := archivedLogs(ctx, pod, container)
+		if err != nil {
+			return err
+		}
+		lines = append(archived[:len(archived):len(archived)], raws...)
+		name = path.Join(j.AppId, j.Id, pod, container+"-"+strconv.FormatInt(time.Now().UnixNano(), 10)+".log.gz")
+	}
+
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	for _, raw := range lines {
+		zw.Write([]byte(raw))
+		zw.Write([]byte{'\n'})
+	}
+	if err := zw.Close(); err != nil {
+		return err
+	}
+	if err := store.Put(ctx, name, buf.Bytes()); err != nil {
+		return err
+	}
+
+	// 归档期间又有新的日志时不删除，下次重新归档，检查和删除在同一个脚本中执行
+	expire := time.Now().Add(retention)
+	ok, err := archiveScript.Run(ctx, RedisClient,
+		[]string{key, LogArchiveKey(pod), LogArchiveIndexKey()},
+		len(raws), container, name, expire.Unix(), old,
+	).Int()
+	if err != nil {
+		return errors.Wrap(err, "archiveScript")
+	}
+	if ok == 0 {
+		return errors.Errorf("logs changed while archiving: %d lines archived", len(raws))
+	}
+	if old != "" {
+		// 删除失败时保留在索引中，过期后由PruneLogArchives删除
+		if err := store.Delete(ctx, old); err != nil {
+			log.Warningf("delete merged archive %s: %v", old, err)
+		} else {
+			RedisClient.ZRem(ctx, LogArchiveIndexKey(), old)
+		}
+	}
+	return nil
+}
+
+// 重新归档结束超过一段时间仍未归档的任务(归档失败或服务重启)
+func ArchivePendingJobs(ctx context.Context) error {
+	ids, err := RedisClient.SMembers(ctx, LogArchivePendingKey()).Result()
+	if err != nil {
+		return errors.Wrap(err, "ArchivePendingJobs")
+	}
+	for _, id := range ids {
+		job, err := GetJob(ctx, id)
+		if err != nil {
+			log.Warningf("archive pending job[%s]: %v", id, err)
+			if errors.Cause(err) == ErrJobNotFound {
+				RedisClient.SRem(ctx, LogArchivePendingKey(), id) // 任务已删除，其他错误保留在集合中下次重试
+			}
+			continue
+		}
+		if !IsJobFinished(job.Status) || time.Since(job.EndTime) < archiveRetryDelay {
+			continue
+		}
+		if err := job.ArchiveLogs(ctx); err != nil {
+			log.Errorf("job[%s] archive logs: %+v", id, err)
+		}
+	}
+	return nil
+}
+
+// 删除已过期的归档，返回删除的数量
+func PruneLogArchives(ctx context.Context) (int, error) {
+	store, err := getArchiveStore()
+	if err != nil || store == nil {
+		return 0, err
+	}
+	names, err := RedisClient.ZRangeByScore(ctx, LogArchiveIndexKey(), &redis.ZRangeBy{
+		Min: "-inf", Max: strconv.FormatInt(time.Now().Unix(), 10),
+	}).Result()
+	if err != nil {
+		return 0, errors.Wrap(err, "PruneLogArchives")
+	}
+	var count int
+	for _, name := range names {
+		if err := store.Delete(ctx, name); err != nil {
+			log.Errorf("delete archive %s: %v", name, err)
+			continue
+		}
+		RedisClient.ZRem(ctx, LogArchiveIndexKey(), name)
+		count++
+	}
+	return count, nil
+}
+
+// 删除pod所有容器的归档
+func deleteArchives(ctx context.Context, podName string) error {
+	names, err := RedisClient.HGetAll(ctx, LogArchiveKey(podName)).Result()
+	if err != nil || len(names) == 0 {
+		return err
+	}
+	store, err := getArchiveStore()
+	if err != nil {
+		return err
+	}
+	for _, name := range names {
+		if store != nil {
+			if err := store.Delete(ctx, name); err != nil {
+				return err
+			}
+		}
+		RedisClient.ZRem(ctx, LogArchiveIndexKey(), name)
+	}
+	return nil
+}
+
+// 已归档的容器日志，未归档时返回false
+func archivedLogs(ctx context.Context, podName, container string) ([]string, bool, error) {
+	name, err := RedisClient.HGet(ctx, LogArchiveKey(podName), container).Result()
+	if err == redis.Nil {
+		return nil, false, nil
+	}
+	if err != nil {
+		return nil, false, err
+	}
+	if lines, ok := archiveCache.get(name); ok {
+		return lines, true, nil
+	}
+	store, err := getArchiveStore()
+	if err != nil {
+		return nil, false, err
+	}
+	if store == nil {
+		return nil, false, errors.New("log archive is disabled")
+	}
+	data, err := store.Get(ctx, name)
+	if err == errArchiveNotFound {
+		return []string{}, true, nil // 归档已过期
+	}
+	if err != nil {
+		return nil, false, err
+	}
+	zr, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, false, err
+	}
+	content, err := ioutil.ReadAll(zr)
+	if err != nil {
+		return nil, false, err
+	}
+	lines := []string{}
+	if len(content) > 0 {
+		lines = strings.Split(strings.TrimSuffix(string(content), "\n"), "\n")
+	}
+	archiveCache.set(name, lines)
+	return lines, true, nil
+}
+
+// 读取日志的[start, stop]行，与LRANGE相同，负数表示从最后开始
+func getLogLines(ctx context.Context, podName, container string, start, stop int64) ([]string, error) {
+	lines, ok, err := archivedLogs(ctx, podName, container)
+	if err != nil {
+		return nil, err
+	}
+	key := ContainerLogsKey(podName, container)
+	if !ok {
+		return RedisClient.LRange(ctx, key, start, stop).Result()
+	}
+	// 归档后继续采集的日志(重试任务)接在归档之后
+	live, err := RedisClient.LRange(ctx, key, 0, -1).Result()
+	if err != nil {
+		return nil, err
+	}
+	if len(live) > 0 {
+		lines = append(lines[:len(lines):len(lines)], live...)
+	}
+	n := int64(len(lines))
+	if start < 0 {
+		start += n
+	}
+	if stop < 0 {
+		stop += n
+	}
+	if start < 0 {
+		start = 0
+	}
+	if stop >= n {
+		stop = n - 1
+	}
+	if start > stop {
+		return []string{}, nil
+	}
+	return lines[start : stop+1], nil
+}
+
+// 最近读取的归档，tail和分页读取时避免重复下载解压
+var archiveCache = &linesCache{items: map[string]*linesCacheItem{}}
+
+type linesCache struct {
+	mu    sync.Mutex
+	items map[string]*linesCacheItem
+}
+
+type linesCacheItem struct {
+	lines  []string
+	loaded time.Time
+}
+
+func (c *linesCache) get(name string) ([]string, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	item, ok := c.items[name]
+	if !ok || time.Since(item.loaded) > archiveCacheTTL {
+		return nil, false
+	}
+	return item.lines, true
+}
+
+func (c *linesCache) set(name string, lines []string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if len(c.items) >= archiveCacheSize {
+		var oldest string
+		for k, item := range c.items {
+			if time.Since(item.loaded) > archiveCacheTTL {
+				delete(c.items, k)
+			} else if oldest == "" || item.loaded.Before(c.items[oldest].loaded) {
+				oldest = k
+			}
+		}
+		if len(c.items) >= archiveCacheSize {
+			delete(c.items, oldest)
+		}
+	}
+	c.items[name] = &linesCacheItem{lines: lines, loaded: time.Now()}
+}
+
+// pod中已归档的容器和归档名
+func LogArchiveKey(podName string) string {
+	return strings.Join([]string{"cicd", "log-archive", podName}, sep)
+}
+
+// 所有归档，按过期时间排序
+func LogArchiveIndexKey() string {
+	return strings.Join([]string{"cicd", "log-archive-index"}, sep)
+}
+
+// 已结束还未归档的任务
+func LogArchivePendingKey() string {
+	return strings.Join([]string{"cicd", "log-archive-pending"}, sep)
+}
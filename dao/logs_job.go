@@ -0,0 +1,85 @@
+package dao
+
+import (
+	"fmt"
+	"io"
+)
+
+// 按阶段顺序返回任务中运行过pod的阶段
+func (j *Job) LogPhases() ([]*JobPhaseStatus, error) {
+	phases, err := j.GetPhases()
+	if err != nil {
+		return nil, err
+	}
+	return podPhases(phases), nil
+}
+
+// 先序遍历阶段树，父阶段在前，子阶段按顺序排列
+func podPhases(phases []*JobPhaseStatus) []*JobPhaseStatus {
+	var pods []*JobPhaseStatus
+	for _, phase := range phases {
+		if phase.PodName != "" {
+			pods = append(pods, phase)
+		}
+		pods = append(pods, podPhases(phase.Children)...)
+	}
+	return pods
+}
+
+// 任务所有pod的日志，按阶段顺序排列，container为空时包括所有容器
+func (j *Job) Logs(container string) ([]LogRecord, error) {
+	var records = []LogRecord{}
+	err := j.eachLog(container, func(phase *JobPhaseStatus, container string, logs []LogRecord) error {
+		records = append(records, logs...)
+		return nil
+	})
+	return records, err
+}
+
+// 以纯文本写出任务的全部日志，每个容器前有一行标题
+func (j *Job) WriteLogs(w io.Writer, container string) error {
+	return j.eachLog(container, func(phase *JobPhaseStatus, container string, logs []LogRecord) error {
+		if _, err := fmt.Fprintf(w, "===== step: %s, pod: %s, container: %s =====\n", phase.Name, phase.PodName, container); err != nil {
+			return err
+		}
+		for i := range logs {
+			if _, err := fmt.Fprintln(w, logs[i].Text()); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (j *Job) eachLog(container string, fn func(phase *JobPhaseStatus, container string, logs []LogRecord) error) error {
+	phases, err := j.LogPhases()
+	if err != nil {
+		return err
+	}
+	for _, phase := range phases {
+		containers := []string{container}
+		if container == "" || container == AllContainers {
+			if containers, err = PodContainers(j.Ctx, phase.PodName); err != nil {
+				return err
+			}
+		}
+		for _, c := range containers {
+			logs, err := GetContainerLog(j.Ctx, phase.PodName, c)
+			if err != nil {
+				return err
+			}
+			if len(logs) == 0 && len(containers) > 1 {
+				continue
+			}
+			for i := range logs {
+				if logs[i].Step == "" {
+					logs[i].Step = phase.Name // 旧的日志没有步骤名
+				}
+			}
+			if err := fn(phase, c, logs); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
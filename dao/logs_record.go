@@ -0,0 +1,67 @@
+package dao
+
+import (
+	"encoding/json"
+	"fmt"
+	"lyyops-cicd/pkg/common"
+	"strings"
+	"time"
+)
+
+// 一行日志，时间为pod输出日志的时间(PodLogOptions.Timestamps)
+// 序号从1开始，是该行在容器日志中的位置
+type LogRecord struct {
+	Seq       int64     `json:"seq"`
+	Time      time.Time `json:"time"`
+	Pod       string    `json:"pod"`
+	Container string    `json:"container"`
+	Step      string    `json:"step,omitempty"`
+	Content   string    `json:"content"`
+}
+
+// 纯文本格式: 时间 内容
+func (r *LogRecord) Text() string {
+	return fmt.Sprintf("%s %s", r.Time.Format(time.RFC3339Nano), r.Content)
+}
+
+func (r *LogRecord) String() string {
+	return common.ParseJsonStr(r)
+}
+
+// 解析argo返回的一行日志，开启Timestamps后行首是pod输出日志的时间
+func newLogRecord(pod, container, content string) *LogRecord {
+	r := &LogRecord{Pod: pod, Container: container, Content: content}
+	if i := strings.IndexByte(content, ' '); i > 0 {
+		if t, err := time.Parse(time.RFC3339Nano, content[:i]); err == nil {
+			r.Time, r.Content = t, content[i+1:]
+		}
+	}
+	if r.Time.IsZero() {
+		r.Time = time.Now() // 没有时间戳时使用接收的时间
+	}
+	return r
+}
+
+// 解析DB中保存的一行日志，兼容旧的"时间 内容"格式，seq为该行的位置
+func ParseLogRecord(raw, pod, container string, seq int64) LogRecord {
+	r := LogRecord{}
+	if strings.HasPrefix(raw, "{") && json.Unmarshal([]byte(raw), &r) == nil && r.Pod != "" {
+		return r
+	}
+	r = LogRecord{Seq: seq, Pod: pod, Container: container, Content: raw}
+	if i := strings.IndexByte(raw, ' '); i > 0 {
+		if t, err := time.Parse(time.RFC3339, raw[:i]); err == nil {
+			r.Time, r.Content = t, raw[i+1:]
+		}
+	}
+	return r
+}
+
+// 解析从第start行(从0开始)读取的多行日志
+func parseLogRecords(raws []string, pod, container string, start int64) []LogRecord {
+	records := make([]LogRecord, len(raws))
+	for i, raw := range raws {
+		records[i] = ParseLogRecord(raw, pod, container, start+int64(i)+1)
+	}
+	return records
+}
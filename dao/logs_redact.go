@@ -0,0 +1,255 @@
+package dao
+
+// 日志脱敏: 保存前隐藏k8s secret的值、敏感参数的值和匹配配置正则的内容
+// 同样的规则用于阶段的失败信息、任务事件、通知内容和返回的参数
+
+import (
+	"context"
+	wfv1 "github.com/argoproj/argo-workflows/v3/pkg/apis/workflow/v1alpha1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"lyyops-cicd/config"
+	"lyyops-cicd/pkg/log"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+const (
+	RedactMask         = "******"
+	minRedactValueSize = 4 // 太短的值容易误伤，不做隐藏
+)
+
+type redactor struct {
+	values   []string // 按长度倒序，先替换长的值
+	patterns []*regexp.Regexp
+}
+
+// 根据任务的workflow和参数生成脱敏规则
+func newRedactor(ctx context.Context, job *Job, wf *wfv1.Workflow) *redactor {
+	var (
+		conf   = config.Config.Logs.Redact
+		r      = &redactor{}
+		values = map[string]bool{}
+	)
+	if conf.SensitiveParams != "" {
+		if re, err := regexp.Compile(conf.SensitiveParams); err != nil {
+			log.Errorf("redact sensitiveParams %q: %v", conf.SensitiveParams, err)
+		} else {
+			for name, value := range job.Parameters {
+				if re.MatchString(name) {
+					addRedactValue(values, value)
+				}
+			}
+		}
+	}
+	if conf.Secrets && wf != nil {
+		for _, value := range workflowSecretValues(ctx, wf) {
+			addRedactValue(values, value)
+		}
+	}
+	for value := range values {
+		if len(value) >= minRedactValueSize {
+			r.values = append(r.values, value)
+		}
+	}
+	sort.Slice(r.values, func(i, j int) bool { return len(r.values[i]) > len(r.values[j]) })
+
+	for _, pattern := range conf.Patterns {
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			log.Errorf("redact pattern %q: %v", pattern, err)
+			continue
+		}
+		r.patterns = append(r.patterns, re)
+	}
+	return r
+}
+
+// 去掉首尾的空白(文件中的secret通常以换行结尾)，日志按行脱敏，多行的值(证书、私钥)再逐行添加
+func addRedactValue(values map[string]bool, value string) {
+	value = strings.TrimSpace(value)
+	values[value] = true
+	if !strings.Contains(value, "\n") {
+		return
+	}
+	for _, line := range strings.Split(value, "\n") {
+		if line = strings.TrimSpace(line); !strings.HasPrefix(line, "-----") {
+			values[line] = true // PEM的BEGIN/END行不是敏感内容
+		}
+	}
+}
+
+// 任务的脱敏规则，没有在监听时生成的(不包含secret)按参数和正则生成
+func (j *Job) redactor() *redactor {
+	if j.redact == nil {
+		j.redact = newRedactor(j.Ctx, j, nil)
+	}
+	return j.redact
+}
+
+// 隐藏敏感参数的值，返回新的参数
+func RedactParameters(params map[string]string) map[string]string {
+	pattern := config.Config.Logs.Redact.SensitiveParams
+	if pattern == "" || len(params) == 0 {
+		return params
+	}
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return params
+	}
+	out := make(map[string]string, len(params))
+	for name, value := range params {
+		if re.MatchString(name) {
+			value = RedactMask
+		}
+		out[name] = value
+	}
+	return out
+}
+
+// 隐藏一行日志中的敏感内容，返回隐藏的次数
+func (r *redactor) Redact(line string) (string, int) {
+	if r == nil {
+		return line, 0
+	}
+	var count int
+	for _, value := range r.values {
+		if n := strings.Count(line, value); n > 0 {
+			line = strings.ReplaceAll(line, value, RedactMask)
+			count += n
+		}
+	}
+	for _, re := range r.patterns {
+		line = re.ReplaceAllStringFunc(line, func(match string) string {
+			count++
+			return redactGroups(re, match)
+		})
+	}
+	return line, count
+}
+
+// 记录任务隐藏的次数，用于审计
+func (j *Job) addRedactions(n int) {
+	if n <= 0 {
+		return
+	}
+	if err := RedisClient.HIncrBy(j.Ctx, JobStatusKey(j.Id), "redactions", int64(n)).Err(); err != nil {
+		log.Errorf("job[%s] redactions: %v", j.Id, err)
+	}
+}
+
+// 正则有分组时只隐藏分组的内容，否则隐藏整个匹配
+func redactGroups(re *regexp.Regexp, match string) string {
+	loc := re.FindStringSubmatchIndex(match)
+	if len(loc) <= 2 {
+		return RedactMask
+	}
+	var (
+		b    strings.Builder
+		last int
+	)
+	for i := 2; i+1 < len(loc); i += 2 {
+		if loc[i] < 0 || loc[i] < last {
+			continue
+		}
+		b.WriteString(match[last:loc[i]])
+		b.WriteString(RedactMask)
+		last = loc[i+1]
+	}
+	b.WriteString(match[last:])
+	return b.String()
+}
+
+// workflow引用的secret(环境变量、envFrom、volume)中的所有值
+func workflowSecretValues(ctx context.Context, wf *wfv1.Workflow) []string {
+	namespace := wf.Namespace
+	if namespace == "" {
+		namespace = getNamespace(ctx)
+	}
+	var (
+		values []string
+		names  = workflowSecretNames(wf)
+	)
+	if len(names) == 0 {
+		return nil
+	}
+	cli, err := NewKubeClient()
+	if err != nil {
+		log.Errorf("redact: new kube client: %v", err)
+		return nil
+	}
+	for name, keys := range names {
+		secret, err := cli.CoreV1().Secrets(namespace).Get(ctx, name, metav1.GetOptions{})
+		if err != nil {
+			log.Warningf("redact: get secret %s: %v", name, err)
+			continue
+		}
+		for key, data := range secret.Data {
+			if keys == nil || keys[key] {
+				values = append(values, string(data))
+			}
+		}
+	}
+	return values
+}
+
+// 引用的secret名字和key，key为nil时表示引用全部key
+func workflowSecretNames(wf *wfv1.Workflow) map[string]map[string]bool {
+	names := map[string]map[string]bool{}
+	addAll := func(name string) {
+		names[name] = nil
+	}
+	addKey := func(name, key string) {
+		if keys, ok := names[name]; ok && keys == nil {
+			return
+		}
+		if names[name] == nil {
+			names[name] = map[string]bool{}
+		}
+		names[name][key] = true
+	}
+	addContainer := func(c *corev1.Container) {
+		for _, env := range c.Env {
+			if env.ValueFrom != nil && env.ValueFrom.SecretKeyRef != nil {
+				addKey(env.ValueFrom.SecretKeyRef.Name, env.ValueFrom.SecretKeyRef.Key)
+			}
+		}
+		for _, from := range c.EnvFrom {
+			if from.SecretRef != nil {
+				addAll(from.SecretRef.Name)
+			}
+		}
+	}
+	addVolumes := func(volumes []corev1.Volume) {
+		for _, v := range volumes {
+			if v.Secret != nil {
+				addAll(v.Secret.SecretName)
+			}
+		}
+	}
+
+	addVolumes(wf.Spec.Volumes)
+	for i := range wf.Spec.Templates {
+		tmpl := &wf.Spec.Templates[i]
+		addVolumes(tmpl.Volumes)
+		if tmpl.Container != nil {
+			addContainer(tmpl.Container)
+		}
+		if tmpl.Script != nil {
+			addContainer(&tmpl.Script.Container)
+		}
+		if tmpl.ContainerSet != nil {
+			for j := range tmpl.ContainerSet.Containers {
+				addContainer(&tmpl.ContainerSet.Containers[j].Container)
+			}
+		}
+		for j := range tmpl.InitContainers {
+			addContainer(&tmpl.InitContainers[j].Container)
+		}
+		for j := range tmpl.Sidecars {
+			addContainer(&tmpl.Sidecars[j].Container)
+		}
+	}
+	return names
+}
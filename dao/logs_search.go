@@ -0,0 +1,356 @@
+package dao
+
+// 日志搜索: 按开始时间倒序遍历任务，在每个pod容器的日志中查找匹配的行
+// 每次请求读取的日志字节数和搜索的任务数有上限，超出时返回游标，由调用方从中断的位置继续搜索
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"github.com/pkg/errors"
+	"regexp"
+	"strings"
+	"time"
+)
+
+const (
+	DefaultLogSearchMatches = 100
+	DefaultLogSearchBytes   = 16 << 20 // 16MB
+	MaxLogSearchBytes       = 256 << 20
+	MaxLogSearchContext     = 10
+	DefaultLogSearchJobs    = 200
+	MaxLogSearchJobs        = 1000
+	logSearchBatch          = 1000 // 每次读取的日志行数
+)
+
+// 日志搜索条件
+type LogSearchQuery struct {
+	Query      string
+	Regex      bool
+	IgnoreCase bool
+	AppId      string
+	Status     string
+	Step       string // 步骤名
+	Since      time.Time
+	Until      time.Time
+	Context    int    // 匹配行前后各返回的行数
+	MaxMatches int    // 最多返回的匹配数
+	MaxBytes   int64  // 最多读取的日志字节数
+	MaxJobs    int    // 最多搜索的任务数，没有日志的任务也计入
+	Cursor     string // 上次返回的游标，可以指向任务中的某一行
+}
+
+// 匹配的日志行，带上任务的来源信息
+type LogMatch struct {
+	JobId     string    `json:"job_id"`
+	AppId     string    `json:"app_id"`
+	Trigger   string    `json:"trigger,omitempty"`
+	CreatedBy string    `json:"created_by,omitempty"`
+	Commit    string    `json:"commit,omitempty"`
+	Branch    string    `json:"branch,omitempty"`
+	Revision  string    `json:"revision,omitempty"`
+	Record    LogRecord `json:"record"`
+	Before    []string  `json:"before,omitempty"`
+	After     []string  `json:"after,omitempty"`
+}
+
+func newLogMatch(job *Job, record LogRecord) *LogMatch {
+	return &LogMatch{
+		JobId:     job.Id,
+		AppId:     job.AppId,
+		Trigger:   job.Trigger,
+		CreatedBy: job.CreatedBy,
+		Commit:    job.Commit,
+		Branch:    job.Branch,
+		Revision:  job.Revision,
+		Record:    record,
+	}
+}
+
+type LogSearchResult struct {
+	Matches      []*LogMatch `json:"matches"`
+	JobsScanned  int         `json:"jobs_scanned"`
+	BytesScanned int64       `json:"bytes_scanned"`
+	Truncated    bool        `json:"truncated"`   // 达到匹配数或字节数上限
+	NextCursor   string      `json:"next_cursor"` // 继续搜索的游标，为空时已搜索完
+}
+
+// 搜索游标: 中断的任务和日志位置，Pod为空时从Job之后的任务开始
+type logSearchCursor struct {
+	Job       string `json:"job"` // 任务的分页游标
+	Pod       string `json:"pod,omitempty"`
+	Container string `json:"container,omitempty"`
+	Line      int64  `json:"line,omitempty"` // 下一次读取的行(从0开始)
+}
+
+func (c *logSearchCursor) String() string {
+	if c.Pod == "" {
+		return c.Job
+	}
+	b, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+// 解析搜索游标，兼容只有任务分页游标的格式
+func parseLogSearchCursor(s string) (*logSearchCursor, error) {
+	c := &logSearchCursor{}
+	if s == "" {
+		return c, nil
+	}
+	if b, err := base64.RawURLEncoding.DecodeString(s); err == nil && json.Unmarshal(b, c) == nil && c.Job != "" {
+		return c, nil
+	}
+	if _, err := parsePageCursor(s); err != nil {
+		return nil, err
+	}
+	return &logSearchCursor{Job: s}, nil
+}
+
+type logSearcher struct {
+	ctx     context.Context
+	q       LogSearchQuery
+	lines   func(ctx context.Context, podName, container string, start, stop int64) ([]string, error)
+	match   func(string) bool
+	result  *LogSearchResult
+	pending []*LogMatch      // 还需要补充后面几行的匹配
+	resume  *logSearchCursor // 从中断的位置继续搜索
+	stopped *logSearchCursor // 本次中断的位置
+}
+
+func SearchLogs(ctx context.Context, q LogSearchQuery) (*LogSearchResult, error) {
+	if q.Query == "" {
+		return nil, errors.New("搜索内容不能为空")
+	}
+	if q.MaxMatches <= 0 {
+		q.MaxMatches = DefaultLogSearchMatches
+	}
+	if q.MaxBytes <= 0 {
+		q.MaxBytes = DefaultLogSearchBytes
+	}
+	if q.MaxBytes > MaxLogSearchBytes {
+		q.MaxBytes = MaxLogSearchBytes
+	}
+	if q.MaxJobs <= 0 {
+		q.MaxJobs = DefaultLogSearchJobs
+	}
+	if q.MaxJobs > MaxLogSearchJobs {
+		q.MaxJobs = MaxLogSearchJobs
+	}
+	if q.Context > MaxLogSearchContext {
+		q.Context = MaxLogSearchContext
+	}
+	s := &logSearcher{ctx: ctx, q: q, lines: getLogLines, result: &LogSearchResult{Matches: []*LogMatch{}}}
+	if err := s.compile(); err != nil {
+		return nil, err
+	}
+	resume, err := parseLogSearchCursor(q.Cursor)
+	if err != nil {
+		return nil, err
+	}
+
+	cursor := resume.Job
+	if resume.Pod != "" {
+		// 先搜索上次中断的任务剩余的日志
+		pc, _ := parsePageCursor(resume.Job)
+		job, err := GetJob(ctx, pc.id)
+		switch {
+		case err == nil:
+			s.resume = resume
+			done, err := s.scanJob(job)
+			if err != nil {
+				return nil, err
+			}
+			if done {
+				return s.result, nil
+			}
+			s.resume = nil
+		case errors.Cause(err) != ErrJobNotFound:
+			return nil, err
+		}
+	}
+	for {
+		jobs, next, err := QueryJobs(ctx, JobQuery{
+			AppId:  q.AppId,
+			Status: q.Status,
+			Since:  q.Since,
+			Until:  q.Until,
+			Cursor: cursor,
+		})
+		if err != nil {
+			return nil, err
+		}
+		for _, job := range jobs {
+			if s.result.JobsScanned >= q.MaxJobs {
+				s.result.Truncated = true
+				s.result.NextCursor = cursor
+				return s.result, nil
+			}
+			done, err := s.scanJob(job)
+			if err != nil {
+				return nil, err
+			}
+			if done {
+				return s.result, nil
+			}
+			cursor = jobCursor(job)
+		}
+		if next == "" {
+			return s.result, nil
+		}
+		cursor = next
+	}
+}
+
+// 搜索一个任务并计数，达到上限时记录继续搜索的游标
+func (s *logSearcher) scanJob(job *Job) (bool, error) {
+	s.result.JobsScanned++
+	done, err := s.searchJob(job)
+	if err != nil {
+		return false, err
+	}
+	if done {
+		s.stopped.Job = jobCursor(job)
+		s.result.NextCursor = s.stopped.String()
+	}
+	return done, nil
+}
+
+func (s *logSearcher) compile() error {
+	if s.q.Regex {
+		expr := s.q.Query
+		if s.q.IgnoreCase {
+			expr = "(?i)" + expr
+		}
+		re, err := regexp.Compile(expr)
+		if err != nil {
+			return errors.Wrap(err, "正则表达式错误")
+		}
+		s.match = re.MatchString
+		return nil
+	}
+	query := s.q.Query
+	if s.q.IgnoreCase {
+		query = strings.ToLower(query)
+		s.match = func(line string) bool { return strings.Contains(strings.ToLower(line), query) }
+		return nil
+	}
+	s.match = func(line string) bool { return strings.Contains(line, query) }
+	return nil
+}
+
+// 搜索一个任务的日志，达到上限时返回true
+func (s *logSearcher) searchJob(job *Job) (bool, error) {
+	phases, err := job.LogPhases()
+	if err != nil {
+		return false, err
+	}
+	for _, phase := range phases {
+		if s.q.Step != "" && phase.Name != s.q.Step {
+			continue
+		}
+		if s.resume != nil && phase.PodName != s.resume.Pod {
+			continue // 跳过上次已搜索的pod
+		}
+		containers, err := PodContainers(s.ctx, phase.PodName)
+		if err != nil {
+			return false, err
+		}
+		for _, container := range containers {
+			var from int64
+			if s.resume != nil {
+				if container != s.resume.Container {
+					continue
+				}
+				from, s.resume = s.resume.Line, nil
+			}
+			done, err := s.searchContainer(job, phase, container, from)
+			if err != nil || done {
+				return done, err
+			}
+		}
+		// 中断的容器已不存在时从下一个pod继续
+		s.resume = nil
+	}
+	return false, nil
+}
+
+// 从第from行开始分批读取一个容器的日志，保留最近几行作为匹配行的上文
+// 达到上限时记录中断的位置，再继续读取几行补全已返回匹配的下文，这些行在继续搜索时重新搜索
+func (s *logSearcher) searchContainer(job *Job, phase *JobPhaseStatus, container string, from int64) (bool, error) {
+	var recent []string
+	s.pending = nil
+	// 继续搜索时重新读取前面几行作为上文，不计入匹配
+	start := from - int64(s.q.Context)
+	if start < 0 {
+		start = 0
+	}
+	for ; ; start += logSearchBatch {
+		raws, err := s.lines(s.ctx, phase.PodName, container, start, start+logSearchBatch-1)
+		if err != nil {
+			return false, err
+		}
+		for i, raw := range raws {
+			line := start + int64(i)
+			record := ParseLogRecord(raw, phase.PodName, container, line+1)
+			if record.Step == "" {
+				record.Step = phase.Name
+			}
+			text := record.Text()
+			if line < from {
+				recent = s.addRecent(recent, text)
+				continue
+			}
+			s.addAfter(text)
+			if s.stopped != nil {
+				if len(s.pending) == 0 {
+					return true, nil
+				}
+				continue // 已达到上限，只补全下文
+			}
+			s.result.BytesScanned += int64(len(raw))
+			if s.match(record.Content) {
+				m := newLogMatch(job, record)
+				m.Before = append([]string{}, recent...)
+				s.result.Matches = append(s.result.Matches, m)
+				if s.q.Context > 0 {
+					s.pending = append(s.pending, m)
+				}
+			}
+			recent = s.addRecent(recent, text)
+			if len(s.result.Matches) >= s.q.MaxMatches || s.result.BytesScanned >= s.q.MaxBytes {
+				s.result.Truncated = true
+				s.stopped = &logSearchCursor{Pod: phase.PodName, Container: container, Line: line + 1}
+				if len(s.pending) == 0 {
+					return true, nil
+				}
+			}
+		}
+		if len(raws) < logSearchBatch {
+			return s.stopped != nil, nil
+		}
+	}
+}
+
+// 保留最近几行作为上文
+func (s *logSearcher) addRecent(recent []string, text string) []string {
+	if s.q.Context <= 0 {
+		return recent
+	}
+	recent = append(recent, text)
+	if len(recent) > s.q.Context {
+		recent = recent[1:]
+	}
+	return recent
+}
+
+// 给之前的匹配补充下文
+func (s *logSearcher) addAfter(text string) {
+	var pending []*LogMatch
+	for _, m := range s.pending {
+		m.After = append(m.After, text)
+		if len(m.After) < s.q.Context {
+			pending = append(pending, m)
+		}
+	}
+	s.pending = pending
+}
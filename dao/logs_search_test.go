@@ -0,0 +1,102 @@
+package dao
+
+import (
+	"context"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestLogSearchCursor(t *testing.T) {
+	c := &logSearchCursor{Job: "1634540000.25_app-build-x2k9", Pod: "app-build-x2k9-123", Container: "main", Line: 2048}
+	got, err := parseLogSearchCursor(c.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, c) {
+		t.Errorf("got %+v, want %+v", got, c)
+	}
+
+	// 只有任务游标时保持原格式，兼容旧的游标
+	for _, s := range []string{"1634540000.25_app-build-x2k9", "1634540000.5", "1634540000_app"} {
+		got, err := parseLogSearchCursor(s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got.Job != s || got.Pod != "" || got.String() != s {
+			t.Errorf("parse %s = %+v", s, got)
+		}
+	}
+	if _, err := parseLogSearchCursor("not-a-cursor"); err == nil {
+		t.Error("expected error for invalid cursor")
+	}
+}
+
+func TestSearchContainerContext(t *testing.T) {
+	raws := []string{"a", "match 1", "b", "match 2", "c", "d", "match 3", "e"}
+	text := func(i int) string {
+		r := ParseLogRecord(raws[i], "pod", "main", int64(i)+1)
+		return r.Text()
+	}
+	texts := func(idx ...int) []string {
+		var res []string
+		for _, i := range idx {
+			res = append(res, text(i))
+		}
+		return res
+	}
+	newSearcher := func() *logSearcher {
+		s := &logSearcher{
+			ctx: context.Background(),
+			q:   LogSearchQuery{Context: 2, MaxMatches: 2, MaxBytes: DefaultLogSearchBytes},
+			lines: func(_ context.Context, _, _ string, start, stop int64) ([]string, error) {
+				if start >= int64(len(raws)) {
+					return nil, nil
+				}
+				if stop >= int64(len(raws)) {
+					stop = int64(len(raws)) - 1
+				}
+				return raws[start : stop+1], nil
+			},
+			result: &LogSearchResult{Matches: []*LogMatch{}},
+		}
+		s.match = func(line string) bool { return strings.HasPrefix(line, "match") }
+		return s
+	}
+	job := &Job{Id: "app-1", AppId: "app"}
+	phase := &JobPhaseStatus{Name: "build", PodName: "pod"}
+
+	// 达到匹配数上限后补全最后一个匹配的下文，从匹配的下一行继续
+	s := newSearcher()
+	done, err := s.searchContainer(job, phase, "main", 0)
+	if err != nil || !done || !s.result.Truncated {
+		t.Fatalf("searchContainer = %v, %v, truncated %v", done, err, s.result.Truncated)
+	}
+	if len(s.result.Matches) != 2 {
+		t.Fatalf("matches = %d, want 2", len(s.result.Matches))
+	}
+	m1, m2 := s.result.Matches[0], s.result.Matches[1]
+	if !reflect.DeepEqual(m1.Before, texts(0)) || !reflect.DeepEqual(m1.After, texts(2, 3)) {
+		t.Errorf("match 1 before %v after %v", m1.Before, m1.After)
+	}
+	if !reflect.DeepEqual(m2.Before, texts(1, 2)) || !reflect.DeepEqual(m2.After, texts(4, 5)) {
+		t.Errorf("match 2 before %v after %v", m2.Before, m2.After)
+	}
+	if s.stopped == nil || s.stopped.Line != 4 {
+		t.Fatalf("stopped = %+v, want line 4", s.stopped)
+	}
+
+	// 继续搜索时补充的下文行重新搜索
+	s2 := newSearcher()
+	done, err = s2.searchContainer(job, phase, "main", s.stopped.Line)
+	if err != nil || done {
+		t.Fatalf("resume searchContainer = %v, %v", done, err)
+	}
+	if len(s2.result.Matches) != 1 {
+		t.Fatalf("resume matches = %d, want 1", len(s2.result.Matches))
+	}
+	m3 := s2.result.Matches[0]
+	if m3.Record.Seq != 7 || !reflect.DeepEqual(m3.Before, texts(4, 5)) || !reflect.DeepEqual(m3.After, texts(7)) {
+		t.Errorf("match 3 = %+v", m3)
+	}
+}
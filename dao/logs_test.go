@@ -0,0 +1,26 @@
+package dao
+
+import (
+	"testing"
+	"time"
+)
+
+func TestLogsComplete(t *testing.T) {
+	now := time.Now()
+	tests := []struct {
+		name string
+		job  Job
+		want bool
+	}{
+		{"running", Job{Status: "Running"}, false},
+		{"just finished", Job{Status: JobStatusSucceeded, EndTime: now}, false},
+		{"logs done", Job{Status: JobStatusSucceeded, EndTime: now, LogsDoneAt: now}, true},
+		{"archived", Job{Status: JobStatusFailed, EndTime: now, ArchivedAt: now}, true},
+		{"finished long ago", Job{Status: JobStatusFailed, EndTime: now.Add(-archiveWaitLogs)}, true},
+	}
+	for _, tt := range tests {
+		if got := tt.job.LogsComplete(); got != tt.want {
+			t.Errorf("%s: LogsComplete = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
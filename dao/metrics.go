@@ -0,0 +1,521 @@
+package dao
+
+// 交付效能指标(DORA): 部署频率、变更前置时间、变更失败率、服务恢复时间
+// 部署任务结束时按天增量统计，构建等其他任务不统计
+// 配置了Argocd Application的应用以Argocd部署历史中同步完成的时间作为部署时间，以同步失败和健康状态变为Degraded作为失败
+
+import (
+	"context"
+	appv1 "github.com/argoproj/argo-cd/v2/pkg/apiclient/application"
+	"github.com/argoproj/argo-cd/v2/pkg/apis/application/v1alpha1"
+	"github.com/go-redis/redis/v8"
+	"github.com/pkg/errors"
+	"lyyops-cicd/config"
+	"lyyops-cicd/pkg/log"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	defaultMetricsRetention = 400 * 24 * time.Hour
+	metricsDayLayout        = "2006-01-02"
+)
+
+var defaultDeployBranches = []string{"master", "main"}
+
+// 每天统计的字段，时间单位为秒
+const (
+	metricDeployments   = "deployments"
+	metricFailures      = "failures"
+	metricLeadTimeSum   = "lead_time_sum"
+	metricLeadTimeCount = "lead_time_count"
+	metricRestoreSum    = "restore_sum"
+	metricRestoreCount  = "restore_count"
+)
+
+// 统计的时间间隔
+const (
+	MetricsIntervalDay   = "day"
+	MetricsIntervalWeek  = "week"
+	MetricsIntervalMonth = "month"
+)
+
+// 一个时间区间的统计
+type DoraStats struct {
+	Start         time.Time
+	End           time.Time
+	Deployments   int64
+	Failures      int64
+	LeadTimeSum   int64
+	LeadTimeCount int64
+	RestoreSum    int64
+	RestoreCount  int64
+}
+
+// 指标查询条件，统计应用列表在[Since, Until)内的数据
+type DoraQuery struct {
+	AppIds   []string
+	Since    time.Time
+	Until    time.Time
+	Interval string // day, week, month
+}
+
+func metricsRetention() time.Duration {
+	if d := config.Config.Metrics.Retention; d > 0 {
+		return d
+	}
+	return defaultMetricsRetention
+}
+
+// 团队包含的应用，团队不存在时返回false
+func TeamApps(team string) ([]string, bool) {
+	apps, ok := config.Config.Metrics.Teams[team]
+	return apps, ok
+}
+
+// 是否是部署任务
+func isDeployJob(conf config.Metrics, j *Job) bool {
+	if len(conf.Apps) > 0 && !containsString(conf.Apps, j.AppId) && conf.ArgocdApps[j.AppId] == "" {
+		return false
+	}
+	branches := conf.DeployBranches
+	if len(branches) == 0 {
+		branches = defaultDeployBranches
+	}
+	if !containsString(branches, "*") && !containsString(branches, j.Branch) {
+		return false
+	}
+	return len(conf.DeployTriggers) == 0 || containsString(conf.DeployTriggers, j.Trigger)
+}
+
+// 部署任务结束时记录指标，每个任务只记录一次，取消和丢失的任务不统计
+// Argocd应用的任务失败时还没有部署，不计入失败
+func (j *Job) recordMetrics() {
+	if j.Status != JobStatusSucceeded && j.Status != JobStatusFailed && j.Status != JobStatusError {
+		return
+	}
+	conf := config.Config.Metrics
+	if !isDeployJob(conf, j) {
+		return
+	}
+	argocd := conf.ArgocdApps[j.AppId] != ""
+	if argocd && j.Status != JobStatusSucceeded {
+		return
+	}
+	ctx := context.Background()
+	ok, err := RedisClient.SetNX(ctx, MetricsRecordedKey(j.Id), j.Status, metricsRetention()).Result()
+	if err != nil || !ok {
+		return
+	}
+	changeAt := j.changeStart(ctx)
+	if j.Status != JobStatusSucceeded {
+		err = recordFailure(ctx, j.AppId, j.EndTime)
+	} else if argocd {
+		// 等待Argocd同步完成后记录部署
+		member := j.Id + sep + strconv.FormatInt(changeAt.Unix(), 10)
+		pipe := RedisClient.Pipeline()
+		pipe.ZAdd(ctx, MetricsPendingKey(j.AppId), &redis.Z{Score: float64(j.EndTime.Unix()), Member: member})
+		pipe.Expire(ctx, MetricsPendingKey(j.AppId), metricsRetention())
+		_, err = pipe.Exec(ctx)
+	} else {
+		err = recordDeployment(ctx, j.AppId, j.EndTime, []time.Time{changeAt})
+	}
+	if err != nil {
+		log.Errorf("job[%s] record metrics: %v", j.Id, err)
+		// 记录失败时删除标记，恢复监听或重新统计时可以再次记录
+		if err := RedisClient.Del(ctx, MetricsRecordedKey(j.Id)).Err(); err != nil {
+			log.Errorf("job[%s] delete metrics recorded: %v", j.Id, err)
+		}
+	}
+}
+
+// 变更的开始时间: 同一个commit最早开始的任务时间，没有commit时为任务开始时间
+func (j *Job) changeStart(ctx context.Context) time.Time {
+	start := j.StartTime
+	if j.Commit == "" {
+		return start
+	}
+	key := MetricsCommitKey(j.AppId)
+	if first, err := RedisClient.HGet(ctx, key, j.Commit).Int64(); err == nil && first <= start.Unix() {
+		return time.Unix(first, 0)
+	}
+	pipe := RedisClient.Pipeline()
+	pipe.HSet(ctx, key, j.Commit, start.Unix())
+	pipe.Expire(ctx, key, metricsRetention())
+	if _, err := pipe.Exec(ctx); err != nil {
+		log.Warningf("job[%s] save commit time: %v", j.Id, err)
+	}
+	return start
+}
+
+// 记录一次失败的部署，应用从第一次失败开始处于故障中
+func recordFailure(ctx context.Context, appId string, t time.Time) error {
+	key := MetricsDayKey(appId, t)
+	pipe := RedisClient.Pipeline()
+	pipe.HIncrBy(ctx, key, metricFailures, 1)
+	pipe.Expire(ctx, key, metricsRetention())
+	pipe.SetNX(ctx, MetricsFailingKey(appId), t.Unix(), metricsRetention())
+	_, err := pipe.Exec(ctx)
+	return err
+}
+
+// 记录一次成功的部署和其中每个变更的前置时间，应用处于故障中时记录恢复时间
+func recordDeployment(ctx context.Context, appId string, t time.Time, changes []time.Time) error {
+	key := MetricsDayKey(appId, t)
+	pipe := RedisClient.Pipeline()
+	pipe.HIncrBy(ctx, key, metricDeployments, 1)
+	for _, change := range changes {
+		lead := t.Sub(change)
+		if lead < 0 {
+			lead = 0
+		}
+		pipe.HIncrBy(ctx, key, metricLeadTimeSum, int64(lead/time.Second))
+		pipe.HIncrBy(ctx, key, metricLeadTimeCount, 1)
+	}
+	if err := addRestore(ctx, pipe, appId, t); err != nil {
+		return err
+	}
+	pipe.Expire(ctx, key, metricsRetention())
+	_, err := pipe.Exec(ctx)
+	return err
+}
+
+// 记录没有新部署的恢复(Argocd应用的健康状态恢复)
+func recordRestore(ctx context.Context, appId string, t time.Time) error {
+	pipe := RedisClient.Pipeline()
+	if err := addRestore(ctx, pipe, appId, t); err != nil {
+		return err
+	}
+	pipe.Expire(ctx, MetricsDayKey(appId, t), metricsRetention())
+	_, err := pipe.Exec(ctx)
+	return err
+}
+
+// 应用处于故障中时记录恢复时间，并结束故障
+func addRestore(ctx context.Context, pipe redis.Pipeliner, appId string, t time.Time) error {
+	failedAt, err := RedisClient.Get(ctx, MetricsFailingKey(appId)).Int64()
+	if err == redis.Nil {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if restore := t.Unix() - failedAt; restore >= 0 {
+		key := MetricsDayKey(appId, t)
+		pipe.HIncrBy(ctx, key, metricRestoreSum, restore)
+		pipe.HIncrBy(ctx, key, metricRestoreCount, 1)
+	}
+	pipe.Del(ctx, MetricsFailingKey(appId))
+	return nil
+}
+
+// 同步所有配置的Argocd Application的部署历史
+func SyncArgocdDeployments(ctx context.Context) error {
+	var errs []string
+	for appId, name := range config.Config.Metrics.ArgocdApps {
+		if err := syncArgocdHistory(ctx, appId, name); err != nil {
+			errs = append(errs, appId+": "+err.Error())
+		}
+	}
+	if len(errs) > 0 {
+		return errors.New(strings.Join(errs, "; "))
+	}
+	return nil
+}
+
+// 处理上次同步之后新增的部署历史，每次部署包含部署完成前结束的成功任务
+func syncArgocdHistory(ctx context.Context, appId, name string) error {
+	argoCli, err := newArgocdClient()
+	if err != nil {
+		return errors.Wrap(err, "newArgocdClient")
+	}
+	clo, appcli, err := argoCli.NewApplicationClient()
+	if err != nil {
+		return errors.Wrap(err, "argoCli.NewApplicationClient")
+	}
+	defer clo.Close()
+	app, err := appcli.Get(ctx, &appv1.ApplicationQuery{Name: &name})
+	if err != nil {
+		return errors.Wrap(err, "appcli.Get")
+	}
+
+	last, err := RedisClient.Get(ctx, MetricsArgocdCursorKey(appId)).Int64()
+	if err != nil && err != redis.Nil {
+		return err
+	}
+	history := app.Status.History
+	sort.Slice(history, func(i, j int) bool {
+		return history[i].ID < history[j].ID
+	})
+	for _, h := range history {
+		if h.ID <= last || h.DeployedAt.IsZero() {
+			continue
+		}
+		changes, err := popPendingChanges(ctx, appId, h.DeployedAt.Time)
+		if err != nil {
+			return err
+		}
+		if err := recordDeployment(ctx, appId, h.DeployedAt.Time, changes); err != nil {
+			return err
+		}
+		if err := RedisClient.Set(ctx, MetricsArgocdCursorKey(appId), h.ID, -1).Err(); err != nil {
+			return err
+		}
+		log.Infof("metrics: %s deployed by argocd %s[%d] at %s, changes: %d", appId, name, h.ID, h.DeployedAt.Format(time.RFC3339), len(changes))
+	}
+	return syncArgocdFailures(ctx, appId, app)
+}
+
+// Argocd应用的失败: 同步操作失败，每次操作只记录一次；健康状态变为Degraded，恢复为Healthy时记录恢复时间
+func syncArgocdFailures(ctx context.Context, appId string, app *v1alpha1.Application) error {
+	if op := app.Status.OperationState; op != nil && op.FinishedAt != nil && isArgocdOperationFailed(string(op.Phase)) {
+		ok, err := RedisClient.SetNX(ctx, MetricsArgocdOperationKey(appId, op.StartedAt.Time), string(op.Phase), metricsRetention()).Result()
+		if err != nil {
+			return err
+		}
+		if ok {
+			if err := recordFailure(ctx, appId, op.FinishedAt.Time); err != nil {
+				return err
+			}
+			log.Infof("metrics: %s argocd sync %s at %s: %s", appId, op.Phase, op.FinishedAt.Format(time.RFC3339), op.Message)
+		}
+	}
+
+	health := string(app.Status.Health.Status)
+	if health == "" {
+		return nil
+	}
+	prev, err := RedisClient.GetSet(ctx, MetricsArgocdHealthKey(appId), health).Result()
+	if err != nil && err != redis.Nil {
+		return err
+	}
+	RedisClient.Expire(ctx, MetricsArgocdHealthKey(appId), metricsRetention())
+	now := time.Now()
+	switch {
+	case prev == "" || prev == health:
+		return nil // 第一次同步时不知道状态变化的时间
+	case health == argocdHealthDegraded:
+		log.Infof("metrics: %s argocd health %s -> %s", appId, prev, health)
+		return recordFailure(ctx, appId, now)
+	case health == argocdHealthHealthy && prev == argocdHealthDegraded:
+		log.Infof("metrics: %s argocd health %s -> %s", appId, prev, health)
+		return recordRestore(ctx, appId, now)
+	}
+	return nil
+}
+
+const (
+	argocdHealthHealthy  = "Healthy"
+	argocdHealthDegraded = "Degraded"
+)
+
+func isArgocdOperationFailed(phase string) bool {
+	return phase == "Failed" || phase == "Error"
+}
+
+// 取出部署完成前结束的成功任务，返回每个任务的变更开始时间
+func popPendingChanges(ctx context.Context, appId string, deployedAt time.Time) ([]time.Time, error) {
+	key := MetricsPendingKey(appId)
+	members, err := RedisClient.ZRangeByScore(ctx, key, &redis.ZRangeBy{
+		Min: "-inf", Max: strconv.FormatInt(deployedAt.Unix(), 10),
+	}).Result()
+	if err != nil || len(members) == 0 {
+		return nil, err
+	}
+	var changes []time.Time
+	for _, member := range members {
+		i := strings.LastIndex(member, sep)
+		if i < 0 {
+			continue
+		}
+		if ts, err := strconv.ParseInt(member[i+1:], 10, 64); err == nil {
+			changes = append(changes, time.Unix(ts, 0))
+		}
+	}
+	args := make([]interface{}, len(members))
+	for i, member := range members {
+		args[i] = member
+	}
+	return changes, RedisClient.ZRem(ctx, key, args...).Err()
+}
+
+// 按时间间隔汇总应用的每天统计，返回每个区间和整个时间范围的统计
+func QueryDora(ctx context.Context, q DoraQuery) ([]*DoraStats, *DoraStats, error) {
+	var (
+		total = &DoraStats{Start: q.Since, End: q.Until}
+		days  []time.Time
+	)
+	for day := startOfDay(q.Since.Local()); day.Before(q.Until); day = day.AddDate(0, 0, 1) {
+		days = append(days, day)
+	}
+	pipe := RedisClient.Pipeline()
+	cmds := make([][]*redis.StringStringMapCmd, len(days))
+	for i, day := range days {
+		for _, appId := range q.AppIds {
+			cmds[i] = append(cmds[i], pipe.HGetAll(ctx, MetricsDayKey(appId, day)))
+		}
+	}
+	if _, err := pipe.Exec(ctx); err != nil && err != redis.Nil {
+		return nil, nil, errors.Wrap(err, "QueryDora")
+	}
+
+	daily := make([][]map[string]string, len(days))
+	for i := range days {
+		for _, cmd := range cmds[i] {
+			daily[i] = append(daily[i], cmd.Val())
+		}
+	}
+	return aggregateDora(q, days, daily, total), total, nil
+}
+
+// 把每天每个应用的统计汇总到时间区间和总计
+func aggregateDora(q DoraQuery, days []time.Time, daily [][]map[string]string, total *DoraStats) []*DoraStats {
+	var (
+		series  []*DoraStats
+		current *DoraStats
+		bucket  time.Time
+	)
+	for i, day := range days {
+		if start := intervalStart(day, q.Interval); current == nil || !bucket.Equal(start) {
+			// 第一个和最后一个区间只包含查询范围内的部分
+			bucket = start
+			current = &DoraStats{Start: maxTime(start, q.Since), End: minTime(intervalEnd(start, q.Interval), q.Until)}
+			series = append(series, current)
+		}
+		for _, fields := range daily[i] {
+			current.add(fields)
+			total.add(fields)
+		}
+	}
+	return series
+}
+
+func (s *DoraStats) add(fields map[string]string) {
+	for name, ptr := range map[string]*int64{
+		metricDeployments:   &s.Deployments,
+		metricFailures:      &s.Failures,
+		metricLeadTimeSum:   &s.LeadTimeSum,
+		metricLeadTimeCount: &s.LeadTimeCount,
+		metricRestoreSum:    &s.RestoreSum,
+		metricRestoreCount:  &s.RestoreCount,
+	} {
+		n, _ := strconv.ParseInt(fields[name], 10, 64)
+		*ptr += n
+	}
+}
+
+// 部署频率: 平均每天的成功部署次数
+func (s *DoraStats) DeploymentFrequency() float64 {
+	days := s.End.Sub(s.Start).Hours() / 24
+	if days <= 0 {
+		return 0
+	}
+	return float64(s.Deployments) / days
+}
+
+// 变更前置时间: 从变更第一次开始构建到部署完成的平均时间
+func (s *DoraStats) LeadTime() time.Duration {
+	if s.LeadTimeCount == 0 {
+		return 0
+	}
+	return time.Duration(s.LeadTimeSum/s.LeadTimeCount) * time.Second
+}
+
+// 变更失败率: 失败的部署占全部部署的比例
+func (s *DoraStats) ChangeFailureRate() float64 {
+	if s.Deployments+s.Failures == 0 {
+		return 0
+	}
+	return float64(s.Failures) / float64(s.Deployments+s.Failures)
+}
+
+// 服务恢复时间: 从第一次失败到下一次成功部署的平均时间
+func (s *DoraStats) TimeToRestore() time.Duration {
+	if s.RestoreCount == 0 {
+		return 0
+	}
+	return time.Duration(s.RestoreSum/s.RestoreCount) * time.Second
+}
+
+func maxTime(a, b time.Time) time.Time {
+	if a.After(b) {
+		return a
+	}
+	return b
+}
+
+func minTime(a, b time.Time) time.Time {
+	if a.Before(b) {
+		return a
+	}
+	return b
+}
+
+func startOfDay(t time.Time) time.Time {
+	y, m, d := t.Date()
+	return time.Date(y, m, d, 0, 0, 0, 0, t.Location())
+}
+
+// 时间所在区间的开始，周从周一开始
+func intervalStart(day time.Time, interval string) time.Time {
+	switch interval {
+	case MetricsIntervalWeek:
+		offset := (int(day.Weekday()) + 6) % 7
+		return day.AddDate(0, 0, -offset)
+	case MetricsIntervalMonth:
+		return day.AddDate(0, 0, 1-day.Day())
+	}
+	return day
+}
+
+func intervalEnd(start time.Time, interval string) time.Time {
+	switch interval {
+	case MetricsIntervalWeek:
+		return start.AddDate(0, 0, 7)
+	case MetricsIntervalMonth:
+		return start.AddDate(0, 1, 0)
+	}
+	return start.AddDate(0, 0, 1)
+}
+
+// 应用每天的统计
+func MetricsDayKey(appId string, t time.Time) string {
+	return strings.Join([]string{"cicd", "metrics", appId, t.Local().Format(metricsDayLayout)}, sep)
+}
+
+// 应用每个commit第一次开始构建的时间
+func MetricsCommitKey(appId string) string {
+	return strings.Join([]string{"cicd", "metrics-commit", appId}, sep)
+}
+
+// 应用处于故障中的开始时间
+func MetricsFailingKey(appId string) string {
+	return strings.Join([]string{"cicd", "metrics-failing", appId}, sep)
+}
+
+// 等待Argocd部署的成功任务，按任务结束时间排序
+func MetricsPendingKey(appId string) string {
+	return strings.Join([]string{"cicd", "metrics-pending", appId}, sep)
+}
+
+// 已处理的Argocd部署历史的最大ID
+func MetricsArgocdCursorKey(appId string) string {
+	return strings.Join([]string{"cicd", "metrics-argocd", appId}, sep)
+}
+
+// 已记录为失败的Argocd同步操作，以操作开始时间区分
+func MetricsArgocdOperationKey(appId string, startedAt time.Time) string {
+	return strings.Join([]string{"cicd", "metrics-argocd-op", appId, strconv.FormatInt(startedAt.Unix(), 10)}, sep)
+}
+
+// 上次同步时Argocd应用的健康状态
+func MetricsArgocdHealthKey(appId string) string {
+	return strings.Join([]string{"cicd", "metrics-argocd-health", appId}, sep)
+}
+
+// 已记录指标的任务
+func MetricsRecordedKey(id string) string {
+	return strings.Join([]string{"cicd", "metrics-recorded", id}, sep)
+}
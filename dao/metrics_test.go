@@ -0,0 +1,81 @@
+package dao
+
+import (
+	"lyyops-cicd/config"
+	"testing"
+	"time"
+)
+
+func TestIsDeployJob(t *testing.T) {
+	tests := []struct {
+		name string
+		conf config.Metrics
+		job  Job
+		want bool
+	}{
+		{"default branch", config.Metrics{}, Job{AppId: "web", Branch: "main"}, true},
+		{"feature branch", config.Metrics{}, Job{AppId: "web", Branch: "feature/x"}, false},
+		{"no branch", config.Metrics{}, Job{AppId: "web"}, false},
+		{"all branches", config.Metrics{DeployBranches: []string{"*"}}, Job{AppId: "web", Branch: "feature/x"}, true},
+		{"configured branch", config.Metrics{DeployBranches: []string{"release"}}, Job{AppId: "web", Branch: "main"}, false},
+		{"trigger", config.Metrics{DeployTriggers: []string{JobTriggerWebhook}}, Job{AppId: "web", Branch: "main", Trigger: JobTriggerApi}, false},
+		{"app not listed", config.Metrics{Apps: []string{"api"}}, Job{AppId: "web", Branch: "main"}, false},
+		{"argocd app", config.Metrics{Apps: []string{"api"}, ArgocdApps: map[string]string{"web": "web-prod"}}, Job{AppId: "web", Branch: "main"}, true},
+	}
+	for _, tt := range tests {
+		if got := isDeployJob(tt.conf, &tt.job); got != tt.want {
+			t.Errorf("%s: isDeployJob = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestAggregateDora(t *testing.T) {
+	loc := time.Local
+	q := DoraQuery{
+		Since:    time.Date(2021, 10, 1, 0, 0, 0, 0, loc), // 周五
+		Until:    time.Date(2021, 10, 12, 0, 0, 0, 0, loc),
+		Interval: MetricsIntervalWeek,
+	}
+	var days []time.Time
+	for day := q.Since; day.Before(q.Until); day = day.AddDate(0, 0, 1) {
+		days = append(days, day)
+	}
+	daily := make([][]map[string]string, len(days))
+	daily[0] = []map[string]string{
+		{metricDeployments: "2", metricLeadTimeSum: "600", metricLeadTimeCount: "2"},
+		{metricFailures: "1"},
+	}
+	daily[4] = []map[string]string{{metricDeployments: "1", metricRestoreSum: "3600", metricRestoreCount: "1"}}
+	daily[10] = []map[string]string{{metricDeployments: "3", metricFailures: "1", metricLeadTimeSum: "300", metricLeadTimeCount: "1"}}
+
+	total := &DoraStats{Start: q.Since, End: q.Until}
+	series := aggregateDora(q, days, daily, total)
+	if len(series) != 3 {
+		t.Fatalf("len(series) = %d, want 3", len(series))
+	}
+	// 第一个区间从查询开始到周一，最后一个区间到查询结束
+	if !series[0].Start.Equal(q.Since) || !series[0].End.Equal(time.Date(2021, 10, 4, 0, 0, 0, 0, loc)) {
+		t.Errorf("series[0] = %s - %s", series[0].Start, series[0].End)
+	}
+	if !series[2].End.Equal(q.Until) {
+		t.Errorf("series[2].End = %s", series[2].End)
+	}
+	if series[0].Deployments != 2 || series[0].Failures != 1 || series[1].Deployments != 1 || series[2].Deployments != 3 {
+		t.Errorf("series = %+v %+v %+v", series[0], series[1], series[2])
+	}
+	if total.Deployments != 6 || total.Failures != 2 {
+		t.Errorf("total = %+v", total)
+	}
+	if got := total.ChangeFailureRate(); got != 0.25 {
+		t.Errorf("ChangeFailureRate = %v", got)
+	}
+	if got := total.LeadTime(); got != 5*time.Minute {
+		t.Errorf("LeadTime = %s", got)
+	}
+	if got := total.TimeToRestore(); got != time.Hour {
+		t.Errorf("TimeToRestore = %s", got)
+	}
+	if got := series[0].DeploymentFrequency(); got != 2.0/3 {
+		t.Errorf("DeploymentFrequency = %v", got)
+	}
+}
@@ -0,0 +1,402 @@
+package dao
+
+// 任务状态变化的通知: 通知目标按应用配置，状态变化时异步发送，失败后按退避时间重试
+// 每次发送都记录到任务的通知记录中
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/go-redis/redis/v8"
+	"github.com/pkg/errors"
+	"lyyops-cicd/config"
+	"lyyops-cicd/pkg/common"
+	"lyyops-cicd/pkg/log"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	NotifyTypeWebhook  = "webhook"  // 通用的http webhook，secret不为空时带HMAC签名
+	NotifyTypeDingtalk = "dingtalk" // 钉钉机器人，secret为加签密钥
+	NotifyTypeWecom    = "wecom"    // 企业微信机器人
+	NotifyTypeSlack    = "slack"    // Slack incoming webhook
+)
+
+const (
+	NotifyDeliveryPending = "pending"
+	NotifyDeliverySuccess = "success"
+	NotifyDeliveryFailed  = "failed"
+)
+
+// 通知所有状态
+const NotifyAllEvents = "*"
+
+const (
+	defaultNotifyTimeout = 10 * time.Second
+	defaultNotifyBackoff = 2 * time.Second
+)
+
+var NotifyTypes = []string{NotifyTypeWebhook, NotifyTypeDingtalk, NotifyTypeWecom, NotifyTypeSlack}
+
+// 应用的通知目标
+type NotifyTarget struct {
+	Ctx       context.Context `json:"-"`
+	Id        string          `json:"id"`
+	AppId     string          `json:"app_id"`
+	Name      string          `json:"name"`
+	Type      string          `json:"type"`
+	Url       string          `json:"url"`
+	Secret    string          `json:"secret,omitempty"`
+	Events    []string        `json:"events"` // 通知的任务状态，为空时通知结束和等待审批，*为全部
+	CreatedBy string          `json:"created_by"`
+	CreatedAt time.Time       `json:"created_at"`
+}
+
+// 一次通知的发送记录
+type NotifyDelivery struct {
+	Id        string          `json:"id"`
+	JobId     string          `json:"job_id"`
+	TargetId  string          `json:"target_id"`
+	Type      string          `json:"type"`
+	Event     string          `json:"event"` // 任务状态
+	Status    string          `json:"status"`
+	Attempts  []NotifyAttempt `json:"attempts"`
+	CreatedAt time.Time       `json:"created_at"`
+}
+
+type NotifyAttempt struct {
+	Time       time.Time `json:"time"`
+	StatusCode int       `json:"status_code,omitempty"`
+	Duration   string    `json:"duration"`
+	Error      string    `json:"error,omitempty"`
+}
+
+// 通知的内容
+type NotifyMessage struct {
+	Event          string    `json:"event"` // job.status
+	JobId          string    `json:"job_id"`
+	AppId          string    `json:"app_id"`
+	Status         string    `json:"status"`
+	PreviousStatus string    `json:"previous_status,omitempty"`
+	Trigger        string    `json:"trigger"`
+	Branch         string    `json:"branch,omitempty"`
+	StartTime      time.Time `json:"start_time"`
+	EndTime        time.Time `json:"end_time"`
+	Cost           string    `json:"cost"`
+	FailedPhase    string    `json:"failed_phase,omitempty"`
+	FailedMessage  string    `json:"failed_message,omitempty"`
+	Url            string    `json:"url,omitempty"`
+	LogsUrl        string    `json:"logs_url,omitempty"`
+}
+
+func GetNotifyTarget(ctx context.Context, id string) (*NotifyTarget, error) {
+	bs, err := RedisClient.Get(ctx, NotifyTargetKey(id)).Bytes()
+	if err != nil {
+		return nil, errors.Wrapf(err, "notify target %s", id)
+	}
+	t := &NotifyTarget{}
+	if err := json.Unmarshal(bs, t); err != nil {
+		return nil, err
+	}
+	t.Ctx = ctx
+	return t, nil
+}
+
+// 通知目标列表，appId为空时返回全部
+func ListNotifyTarget(ctx context.Context, appId string) ([]*NotifyTarget, error) {
+	var targets = []*NotifyTarget{}
+	ids, err := indexMembers(ctx, NotifyTargetIndexKey(), NotifyTargetKey("*"), ExtractNotifyTargetName)
+	if err != nil {
+		return nil, err
+	}
+	for _, id := range ids {
+		t, err := GetNotifyTarget(ctx, id)
+		if err != nil {
+			pruneIndexMember(ctx, NotifyTargetIndexKey(), id, err)
+			continue
+		}
+		if appId != "" && t.AppId != appId {
+			continue
+		}
+		targets = append(targets, t)
+	}
+	sort.Slice(targets, func(i, j int) bool {
+		return targets[i].CreatedAt.Before(targets[j].CreatedAt)
+	})
+	return targets, nil
+}
+
+func (t *NotifyTarget) Save() error {
+	if t.Id == "" {
+		t.Id = fmt.Sprintf("%s-%d", t.AppId, time.Now().UnixNano())
+	}
+	pipe := RedisClient.TxPipeline()
+	pipe.Set(t.Ctx, NotifyTargetKey(t.Id), []byte(common.ParseJsonStr(t)), -1)
+	pipe.SAdd(t.Ctx, NotifyTargetIndexKey(), t.Id)
+	_, err := pipe.Exec(t.Ctx)
+	return err
+}
+
+func (t *NotifyTarget) Delete() error {
+	pipe := RedisClient.TxPipeline()
+	pipe.Del(t.Ctx, NotifyTargetKey(t.Id))
+	pipe.SRem(t.Ctx, NotifyTargetIndexKey(), t.Id)
+	_, err := pipe.Exec(t.Ctx)
+	return err
+}
+
+// 是否需要通知该状态
+func (t *NotifyTarget) Match(status string) bool {
+	if len(t.Events) == 0 {
+		return IsJobFinished(status) || status == JobStatusAwaitingApproval
+	}
+	for _, e := range t.Events {
+		if e == NotifyAllEvents || e == status {
+			return true
+		}
+	}
+	return false
+}
+
+// 任务状态变化时通知应用的所有目标，每次状态变化只通知一次(包括多个副本和恢复监听)
+// 状态和审批节点一起判断，再次等待审批(其他节点或同一节点)时重新通知
+func (j *Job) notifyStatus() {
+	transition := notifyTransition(j.Status, j.ApprovalGate)
+	if transition == j.notified {
+		return
+	}
+	j.notified = transition
+
+	ctx := context.Background()
+	previous, err := notifyTransitionScript.Run(ctx, RedisClient, []string{JobStatusKey(j.Id)}, transition).Text()
+	if err == redis.Nil {
+		return // 已通知过或任务已删除
+	}
+	if err != nil {
+		log.Errorf("job[%s] notify transition: %v", j.Id, err)
+		return
+	}
+	if i := strings.Index(previous, notifyTransitionSep); i >= 0 {
+		previous = previous[:i]
+	}
+	var msg *NotifyMessage
+	message := func() *NotifyMessage {
+		if msg == nil {
+			msg = j.notifyMessage(previous)
+		}
+		return msg
+	}
+	targets, err := ListNotifyTarget(ctx, j.AppId)
+	if err != nil {
+		log.Errorf("job[%s] list notify targets: %v", j.Id, err)
+	}
+	for _, t := range targets {
+		if t.Match(j.Status) {
+			go t.Send(ctx, message())
+		}
+	}
+	j.notifySubscribers(ctx, message)
+}
+
+const notifyTransitionSep = "|"
+
+// 任务的状态变化: 状态|审批节点
+func notifyTransition(status, gate string) string {
+	if status != JobStatusAwaitingApproval {
+		gate = ""
+	}
+	return status + notifyTransitionSep + gate
+}
+
+// 与已通知的状态变化不同时保存并返回之前的状态变化，相同或任务已删除时返回nil(redis.Nil)
+var notifyTransitionScript = redis.NewScript(`
+if redis.call("exists", KEYS[1]) == 0 then
+	return nil
+end
+local previous = redis.call("hget", KEYS[1], "notified") or ""
+if previous == ARGV[1] then
+	return nil
+end
+redis.call("hset", KEYS[1], "notified", ARGV[1])
+return previous`)
+
+// 生成任务当前状态的通知内容
+func (j *Job) notifyMessage(previous string) *NotifyMessage {
+	msg := &NotifyMessage{
+		Event:          "job.status",
+		JobId:          j.Id,
+		AppId:          j.AppId,
+		Status:         j.Status,
+		PreviousStatus: previous,
+		Trigger:        j.Trigger,
+		Branch:         j.Branch,
+		StartTime:      j.StartTime,
+		Cost:           time.Since(j.StartTime).Truncate(time.Second).String(),
+	}
+	if msg.Trigger == "" {
+		msg.Trigger = j.defaultTrigger()
+	}
+	if IsJobFinished(j.Status) {
+		msg.EndTime = j.EndTime
+		if !j.EndTime.IsZero() {
+			msg.Cost = j.EndTime.Sub(j.StartTime).Truncate(time.Second).String()
+		}
+		_, msg.FailedPhase, msg.FailedMessage = phaseSummaryOutput(phaseTree(j.Phases))
+		msg.FailedMessage, _ = j.redactor().Redact(msg.FailedMessage) // 旧的阶段记录没有脱敏
+	}
+	if url := config.Config.Notify.JobUrl; url != "" {
+		msg.Url = fmt.Sprintf(url, j.Id)
+	}
+	if url := config.Config.Notify.LogsUrl; url != "" {
+		msg.LogsUrl = fmt.Sprintf(url, j.Id)
+	}
+	return msg
+}
+
+// 发送通知，失败时按退避时间重试，每次发送后更新通知记录
+func (t *NotifyTarget) Send(ctx context.Context, msg *NotifyMessage) *NotifyDelivery {
+	d := newNotifyDelivery(msg, t.Id, t.Type)
+	return d.deliver(ctx, func() (int, error) {
+		return t.post(ctx, d.Id, msg)
+	})
+}
+
+// 发送一条测试通知，不重试也不记录
+func (t *NotifyTarget) SendTest(ctx context.Context) *NotifyDelivery {
+	msg := testNotifyMessage(t.AppId)
+	d := newNotifyDelivery(msg, t.Id, t.Type)
+	return d.attempt(func() (int, error) {
+		return t.post(ctx, d.Id, msg)
+	})
+}
+
+func newNotifyDelivery(msg *NotifyMessage, targetId, typ string) *NotifyDelivery {
+	return &NotifyDelivery{
+		Id:        fmt.Sprintf("%s-%d", targetId, time.Now().UnixNano()),
+		JobId:     msg.JobId,
+		TargetId:  targetId,
+		Type:      typ,
+		Event:     msg.Status,
+		Status:    NotifyDeliveryPending,
+		Attempts:  []NotifyAttempt{},
+		CreatedAt: time.Now(),
+	}
+}
+
+func testNotifyMessage(appId string) *NotifyMessage {
+	return &NotifyMessage{
+		Event:     "job.test",
+		JobId:     appId + "-test",
+		AppId:     appId,
+		Status:    JobStatusSucceeded,
+		Trigger:   JobTriggerApi,
+		StartTime: time.Now(),
+		EndTime:   time.Now(),
+		Cost:      "0s",
+	}
+}
+
+// 发送并按退避时间重试
+func (d *NotifyDelivery) deliver(ctx context.Context, send func() (int, error)) *NotifyDelivery {
+	var (
+		conf    = config.Config.Notify
+		backoff = conf.Backoff
+	)
+	if backoff <= 0 {
+		backoff = defaultNotifyBackoff
+	}
+	for i := 0; i <= conf.Retries; i++ {
+		if i > 0 {
+			time.Sleep(backoff)
+			backoff *= 2
+		}
+		if d.attempt(send); d.Status == NotifyDeliverySuccess {
+			d.save(ctx)
+			break
+		}
+		if i == conf.Retries {
+			log.Errorf("notify job[%s] to %s: %s", d.JobId, d.TargetId, d.Attempts[i].Error)
+		} else {
+			d.Status = NotifyDeliveryPending // 等待重试
+		}
+		d.save(ctx)
+	}
+	return d
+}
+
+// 发送一次，记录结果
+func (d *NotifyDelivery) attempt(send func() (int, error)) *NotifyDelivery {
+	start := time.Now()
+	code, err := send()
+	attempt := NotifyAttempt{Time: start, StatusCode: code, Duration: time.Since(start).String()}
+	d.Status = NotifyDeliverySuccess
+	if err != nil {
+		attempt.Error = err.Error()
+		d.Status = NotifyDeliveryFailed
+	}
+	d.Attempts = append(d.Attempts, attempt)
+	return d
+}
+
+// 是否为可以通知的任务状态
+func IsNotifyEvent(event string) bool {
+	if event == NotifyAllEvents {
+		return true
+	}
+	for _, status := range jobStatuses {
+		if status == event {
+			return true
+		}
+	}
+	return false
+}
+
+func (d *NotifyDelivery) save(ctx context.Context) {
+	pipe := RedisClient.Pipeline()
+	pipe.HSet(ctx, NotifyDeliveryKey(d.JobId), d.Id, common.ParseJsonStr(d))
+	pipe.Expire(ctx, NotifyDeliveryKey(d.JobId), defaultExpired)
+	if _, err := pipe.Exec(ctx); err != nil {
+		log.Errorf("notify delivery %s save: %v", d.Id, err)
+	}
+}
+
+// 任务的通知记录，按创建时间排序
+func ListNotifyDelivery(ctx context.Context, jobId string) ([]*NotifyDelivery, error) {
+	var deliveries = []*NotifyDelivery{}
+	res, err := RedisClient.HGetAll(ctx, NotifyDeliveryKey(jobId)).Result()
+	if err != nil {
+		return nil, errors.Wrap(err, "ListNotifyDelivery")
+	}
+	for _, data := range res {
+		d := &NotifyDelivery{}
+		if err := json.Unmarshal([]byte(data), d); err != nil {
+			continue
+		}
+		deliveries = append(deliveries, d)
+	}
+	sort.Slice(deliveries, func(i, j int) bool {
+		return deliveries[i].CreatedAt.Before(deliveries[j].CreatedAt)
+	})
+	return deliveries, nil
+}
+
+func NotifyTargetKey(id string) string {
+	return strings.Join([]string{"cicd", "notify-target", id}, sep)
+}
+
+// 全部通知目标的Id
+func NotifyTargetIndexKey() string {
+	return ObjectIndexKey("notify-target")
+}
+
+func ExtractNotifyTargetName(fullname string) string {
+	names := strings.Split(fullname, sep)
+	return names[2]
+}
+
+// 任务的通知记录
+func NotifyDeliveryKey(jobId string) string {
+	return strings.Join([]string{"cicd", "notify-delivery", jobId}, sep)
+}
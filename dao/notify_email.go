@@ -0,0 +1,341 @@
+package dao
+
+// 邮件通知: 用户订阅应用，选择关心的事件，任务状态变化时通过SMTP发送html和纯文本邮件
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha1"
+	"crypto/tls"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"github.com/pkg/errors"
+	htmltemplate "html/template"
+	"lyyops-cicd/config"
+	"lyyops-cicd/pkg/common"
+	"mime"
+	"mime/multipart"
+	"net"
+	"net/smtp"
+	"net/textproto"
+	"sort"
+	"strconv"
+	"strings"
+	texttemplate "text/template"
+	"time"
+)
+
+const NotifyTypeEmail = "email"
+
+// 订阅的事件
+const (
+	SubscribeFailed = "failed" // 只通知失败
+	SubscribeDeploy = "deploy" // 任务结束(成功、失败、取消)
+	SubscribeAll    = "all"    // 所有状态变化
+)
+
+var SubscribeEvents = []string{SubscribeFailed, SubscribeDeploy, SubscribeAll}
+
+// 用户对应用的邮件订阅，同一个应用的同一个邮箱只有一个订阅
+type NotifySubscription struct {
+	Ctx       context.Context `json:"-"`
+	Id        string          `json:"id"`
+	AppId     string          `json:"app_id"`
+	User      string          `json:"user"`
+	Email     string          `json:"email"`
+	Events    string          `json:"events"` // failed, deploy, all
+	CreatedAt time.Time       `json:"created_at"`
+}
+
+func GetNotifySubscription(ctx context.Context, id string) (*NotifySubscription, error) {
+	bs, err := RedisClient.Get(ctx, NotifySubscriptionKey(id)).Bytes()
+	if err != nil {
+		return nil, errors.Wrapf(err, "notify subscription %s", id)
+	}
+	s := &NotifySubscription{}
+	if err := json.Unmarshal(bs, s); err != nil {
+		return nil, err
+	}
+	s.Ctx = ctx
+	return s, nil
+}
+
+// 订阅列表，appId和user为空时不过滤
+func ListNotifySubscription(ctx context.Context, appId, user string) ([]*NotifySubscription, error) {
+	var subs = []*NotifySubscription{}
+	ids, err := indexMembers(ctx, NotifySubscriptionIndexKey(), NotifySubscriptionKey("*"), ExtractNotifySubscriptionName)
+	if err != nil {
+		return nil, err
+	}
+	for _, id := range ids {
+		s, err := GetNotifySubscription(ctx, id)
+		if err != nil {
+			pruneIndexMember(ctx, NotifySubscriptionIndexKey(), id, err)
+			continue
+		}
+		if (appId != "" && s.AppId != appId) || (user != "" && s.User != user) {
+			continue
+		}
+		subs = append(subs, s)
+	}
+	sort.Slice(subs, func(i, j int) bool {
+		return subs[i].CreatedAt.Before(subs[j].CreatedAt)
+	})
+	return subs, nil
+}
+
+// 同一个应用的同一个邮箱只有一个订阅，重复订阅时更新订阅的用户和事件
+func (s *NotifySubscription) Save() error {
+	s.Id = NotifySubscriptionId(s.AppId, s.Email)
+	pipe := RedisClient.TxPipeline()
+	pipe.Set(s.Ctx, NotifySubscriptionKey(s.Id), []byte(common.ParseJsonStr(s)), -1)
+	pipe.SAdd(s.Ctx, NotifySubscriptionIndexKey(), s.Id)
+	_, err := pipe.Exec(s.Ctx)
+	return err
+}
+
+func (s *NotifySubscription) Delete() error {
+	pipe := RedisClient.TxPipeline()
+	pipe.Del(s.Ctx, NotifySubscriptionKey(s.Id))
+	pipe.SRem(s.Ctx, NotifySubscriptionIndexKey(), s.Id)
+	_, err := pipe.Exec(s.Ctx)
+	return err
+}
+
+// 是否需要通知该状态
+func (s *NotifySubscription) Match(status string) bool {
+	switch s.Events {
+	case SubscribeAll:
+		return true
+	case SubscribeDeploy:
+		return IsJobFinished(status)
+	}
+	return isJobFailed(status)
+}
+
+func isJobFailed(status string) bool {
+	switch status {
+	case JobStatusFailed, JobStatusError, JobStatusLost:
+		return true
+	}
+	return false
+}
+
+// 发送邮件，失败时按退避时间重试
+func (s *NotifySubscription) Send(ctx context.Context, msg *NotifyMessage) *NotifyDelivery {
+	d := newNotifyDelivery(msg, s.Id, NotifyTypeEmail)
+	return d.deliver(ctx, func() (int, error) {
+		return 0, SendEmail([]string{s.Email}, msg)
+	})
+}
+
+// 发送一封测试邮件，不重试也不记录
+func (s *NotifySubscription) SendTest() *NotifyDelivery {
+	msg := testNotifyMessage(s.AppId)
+	d := newNotifyDelivery(msg, s.Id, NotifyTypeEmail)
+	return d.attempt(func() (int, error) {
+		return 0, SendEmail([]string{s.Email}, msg)
+	})
+}
+
+// 通知应用的邮件订阅者
+func (j *Job) notifySubscribers(ctx context.Context, msg func() *NotifyMessage) {
+	if config.Config.Notify.Smtp.Host == "" {
+		return
+	}
+	subs, err := ListNotifySubscription(ctx, j.AppId, "")
+	if err != nil {
+		return
+	}
+	sent := map[string]bool{} // 旧版本按用户保存的订阅可能与新订阅的邮箱重复
+	for _, s := range subs {
+		if s.Match(j.Status) && !sent[strings.ToLower(s.Email)] {
+			sent[strings.ToLower(s.Email)] = true
+			go s.Send(ctx, msg())
+		}
+	}
+}
+
+var emailSubjectTmpl = texttemplate.Must(texttemplate.New("subject").Parse(
+	`[cicd] {{.AppId}} {{.Status}}{{if .Branch}} ({{.Branch}}){{end}}`))
+
+var emailTextTmpl = texttemplate.Must(texttemplate.New("text").Parse(`应用: {{.AppId}}
+任务: {{.JobId}}
+状态: {{.Status}}{{if .PreviousStatus}} (之前: {{.PreviousStatus}}){{end}}
+触发方式: {{.Trigger}}
+{{- if .Branch}}
+分支: {{.Branch}}{{end}}
+耗时: {{.Cost}}
+{{- if .FailedPhase}}
+失败步骤: {{.FailedPhase}}
+失败原因: {{.FailedMessage}}{{end}}
+{{- if .Url}}
+任务详情: {{.Url}}{{end}}
+{{- if .LogsUrl}}
+任务日志: {{.LogsUrl}}{{end}}
+`))
+
+var emailHtmlTmpl = htmltemplate.Must(htmltemplate.New("html").Parse(`<html><body>
+<h3>{{.AppId}} {{.Status}}</h3>
+<table border="1" cellspacing="0" cellpadding="4">
+<tr><td>应用</td><td>{{.AppId}}</td></tr>
+<tr><td>任务</td><td>{{if .Url}}<a href="{{.Url}}">{{.JobId}}</a>{{else}}{{.JobId}}{{end}}</td></tr>
+<tr><td>状态</td><td>{{.Status}}{{if .PreviousStatus}} (之前: {{.PreviousStatus}}){{end}}</td></tr>
+<tr><td>触发方式</td><td>{{.Trigger}}</td></tr>
+{{if .Branch}}<tr><td>分支</td><td>{{.Branch}}</td></tr>{{end}}
+<tr><td>耗时</td><td>{{.Cost}}</td></tr>
+{{if .FailedPhase}}<tr><td>失败步骤</td><td>{{.FailedPhase}}</td></tr>
+<tr><td>失败原因</td><td><pre>{{.FailedMessage}}</pre></td></tr>{{end}}
+</table>
+{{if .LogsUrl}}<p><a href="{{.LogsUrl}}">查看日志</a></p>{{end}}
+</body></html>
+`))
+
+// 生成multipart/alternative格式的邮件
+func buildEmail(from string, to []string, msg *NotifyMessage) ([]byte, error) {
+	var subject, text, html bytes.Buffer
+	if err := emailSubjectTmpl.Execute(&subject, msg); err != nil {
+		return nil, err
+	}
+	if err := emailTextTmpl.Execute(&text, msg); err != nil {
+		return nil, err
+	}
+	if err := emailHtmlTmpl.Execute(&html, msg); err != nil {
+		return nil, err
+	}
+
+	var (
+		body bytes.Buffer
+		mw   = multipart.NewWriter(&body)
+	)
+	for _, part := range []struct {
+		contentType string
+		content     []byte
+	}{
+		{"text/plain; charset=UTF-8", text.Bytes()},
+		{"text/html; charset=UTF-8", html.Bytes()},
+	} {
+		w, err := mw.CreatePart(textproto.MIMEHeader{
+			"Content-Type":              {part.contentType},
+			"Content-Transfer-Encoding": {"base64"},
+		})
+		if err != nil {
+			return nil, err
+		}
+		if _, err := w.Write(base64Lines(part.content)); err != nil {
+			return nil, err
+		}
+	}
+	if err := mw.Close(); err != nil {
+		return nil, err
+	}
+
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "From: %s\r\n", from)
+	fmt.Fprintf(&buf, "To: %s\r\n", strings.Join(to, ", "))
+	fmt.Fprintf(&buf, "Subject: %s\r\n", mime.BEncoding.Encode("UTF-8", subject.String()))
+	fmt.Fprintf(&buf, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	fmt.Fprintf(&buf, "MIME-Version: 1.0\r\n")
+	fmt.Fprintf(&buf, "Content-Type: multipart/alternative; boundary=%s\r\n\r\n", mw.Boundary())
+	buf.Write(body.Bytes())
+	return buf.Bytes(), nil
+}
+
+// 发送邮件，服务器支持时使用STARTTLS，配置了用户名时使用PLAIN认证
+func SendEmail(to []string, msg *NotifyMessage) error {
+	conf := config.Config.Notify.Smtp
+	for _, addr := range to {
+		if strings.ContainsAny(addr, "\r\n") {
+			return errors.Errorf("invalid email address: %q", addr)
+		}
+	}
+	if conf.Host == "" {
+		return errors.New("smtp is not configured")
+	}
+	data, err := buildEmail(conf.From, to, msg)
+	if err != nil {
+		return errors.Wrap(err, "build email")
+	}
+	port := conf.Port
+	if port == 0 {
+		port = 25
+	}
+	addr := net.JoinHostPort(conf.Host, strconv.Itoa(port))
+	var auth smtp.Auth
+	if conf.Username != "" {
+		auth = smtp.PlainAuth("", conf.Username, conf.Password, conf.Host)
+	}
+	if !conf.Tls {
+		return smtp.SendMail(addr, auth, conf.From, to, data)
+	}
+
+	conn, err := tls.Dial("tcp", addr, &tls.Config{ServerName: conf.Host})
+	if err != nil {
+		return err
+	}
+	c, err := smtp.NewClient(conn, conf.Host)
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+	if auth != nil {
+		if err := c.Auth(auth); err != nil {
+			return err
+		}
+	}
+	if err := c.Mail(conf.From); err != nil {
+		return err
+	}
+	for _, addr := range to {
+		if err := c.Rcpt(addr); err != nil {
+			return err
+		}
+	}
+	w, err := c.Data()
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(data); err != nil {
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+	return c.Quit()
+}
+
+// base64编码，每行76个字符
+func base64Lines(data []byte) []byte {
+	var (
+		buf     bytes.Buffer
+		encoded = base64.StdEncoding.EncodeToString(data)
+	)
+	for len(encoded) > 76 {
+		buf.WriteString(encoded[:76] + "\r\n")
+		encoded = encoded[76:]
+	}
+	buf.WriteString(encoded + "\r\n")
+	return buf.Bytes()
+}
+
+// 订阅Id: 应用和邮箱的sha1前16位，应用名和邮箱中的分隔符不会造成冲突
+func NotifySubscriptionId(appId, email string) string {
+	sum := sha1.Sum([]byte(appId + "\x00" + strings.ToLower(email)))
+	return hex.EncodeToString(sum[:])[:16]
+}
+
+func NotifySubscriptionKey(id string) string {
+	return strings.Join([]string{"cicd", "notify-subscription", id}, sep)
+}
+
+// 全部订阅的Id
+func NotifySubscriptionIndexKey() string {
+	return ObjectIndexKey("notify-subscription")
+}
+
+func ExtractNotifySubscriptionName(fullname string) string {
+	names := strings.Split(fullname, sep)
+	return names[2]
+}
@@ -0,0 +1,164 @@
+package dao
+
+import (
+	"bufio"
+	"encoding/base64"
+	"io/ioutil"
+	"lyyops-cicd/config"
+	"mime"
+	"mime/multipart"
+	"net"
+	"net/mail"
+	"net/textproto"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestNotifySubscriptionId(t *testing.T) {
+	if NotifySubscriptionId("a-b", "c@x.com") == NotifySubscriptionId("a", "b-c@x.com") {
+		t.Error("ids of different app and email collide")
+	}
+	if NotifySubscriptionId("app", "Dev@Example.com") != NotifySubscriptionId("app", "dev@example.com") {
+		t.Error("email should be case insensitive")
+	}
+	id := NotifySubscriptionId("app:with:colon", "dev@example.com")
+	if strings.Contains(id, sep) || ExtractNotifySubscriptionName(NotifySubscriptionKey(id)) != id {
+		t.Errorf("id %s can not be extracted from key", id)
+	}
+}
+
+func TestSendEmailRejectsHeaderInjection(t *testing.T) {
+	err := SendEmail([]string{"a@example.com\r\nBcc: b@example.com"}, &NotifyMessage{})
+	if err == nil || !strings.Contains(err.Error(), "invalid email address") {
+		t.Fatalf("expected invalid address error, got %v", err)
+	}
+}
+
+// 本地的SMTP服务，记录收到的发件人、收件人和邮件内容
+type smtpStub struct {
+	addr string
+	from string
+	rcpt []string
+	data chan []byte
+}
+
+func newSmtpStub(t *testing.T) *smtpStub {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+	s := &smtpStub{addr: l.Addr().String(), data: make(chan []byte, 1)}
+	go func() {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		tc := textproto.NewConn(conn)
+		tc.PrintfLine("220 localhost ESMTP stub")
+		for {
+			line, err := tc.ReadLine()
+			if err != nil {
+				return
+			}
+			cmd := strings.ToUpper(strings.SplitN(line, " ", 2)[0])
+			switch {
+			case cmd == "EHLO" || cmd == "HELO":
+				tc.PrintfLine("250 localhost")
+			case strings.HasPrefix(strings.ToUpper(line), "MAIL FROM:"):
+				s.from = strings.Trim(line[len("MAIL FROM:"):], "<>")
+				tc.PrintfLine("250 OK")
+			case strings.HasPrefix(strings.ToUpper(line), "RCPT TO:"):
+				s.rcpt = append(s.rcpt, strings.Trim(line[len("RCPT TO:"):], "<>"))
+				tc.PrintfLine("250 OK")
+			case cmd == "DATA":
+				tc.PrintfLine("354 End data with <CR><LF>.<CR><LF>")
+				data, err := ioutil.ReadAll(tc.DotReader())
+				if err != nil {
+					return
+				}
+				s.data <- data
+				tc.PrintfLine("250 OK")
+			case cmd == "QUIT":
+				tc.PrintfLine("221 Bye")
+				return
+			default:
+				tc.PrintfLine("250 OK")
+			}
+		}
+	}()
+	return s
+}
+
+func TestSendEmail(t *testing.T) {
+	stub := newSmtpStub(t)
+	host, port, _ := net.SplitHostPort(stub.addr)
+	old := config.Config.Notify.Smtp
+	defer func() { config.Config.Notify.Smtp = old }()
+	config.Config.Notify.Smtp = config.Smtp{Host: host, From: "cicd@example.com"}
+	config.Config.Notify.Smtp.Port, _ = strconv.Atoi(port)
+
+	msg := &NotifyMessage{
+		JobId: "web-abc12", AppId: "web", Status: JobStatusFailed, PreviousStatus: "Running",
+		Trigger: JobTriggerWebhook, Branch: "main", Cost: "1m0s",
+		FailedPhase: "build", FailedMessage: "exit code 1 <script>",
+		Url: "https://cicd.example.com/jobs/web-abc12",
+	}
+	to := []string{"dev@example.com", "ops@example.com"}
+	if err := SendEmail(to, msg); err != nil {
+		t.Fatalf("SendEmail: %v", err)
+	}
+	data := <-stub.data
+
+	if stub.from != "cicd@example.com" || strings.Join(stub.rcpt, ",") != strings.Join(to, ",") {
+		t.Errorf("envelope from %q to %v", stub.from, stub.rcpt)
+	}
+	m, err := mail.ReadMessage(bufio.NewReader(strings.NewReader(string(data))))
+	if err != nil {
+		t.Fatalf("ReadMessage: %v", err)
+	}
+	subject, err := new(mime.WordDecoder).DecodeHeader(m.Header.Get("Subject"))
+	if err != nil || subject != "[cicd] web Failed (main)" {
+		t.Errorf("Subject = %q, %v", subject, err)
+	}
+	if m.Header.Get("From") != "cicd@example.com" || m.Header.Get("To") != "dev@example.com, ops@example.com" {
+		t.Errorf("headers = %v", m.Header)
+	}
+	mediaType, params, err := mime.ParseMediaType(m.Header.Get("Content-Type"))
+	if err != nil || mediaType != "multipart/alternative" {
+		t.Fatalf("Content-Type = %q, %v", m.Header.Get("Content-Type"), err)
+	}
+
+	parts := map[string]string{}
+	mr := multipart.NewReader(m.Body, params["boundary"])
+	for {
+		p, err := mr.NextPart()
+		if err != nil {
+			break
+		}
+		if p.Header.Get("Content-Transfer-Encoding") != "base64" {
+			t.Errorf("part %s encoding = %q", p.Header.Get("Content-Type"), p.Header.Get("Content-Transfer-Encoding"))
+		}
+		content, err := ioutil.ReadAll(base64.NewDecoder(base64.StdEncoding, p))
+		if err != nil {
+			t.Fatalf("decode part: %v", err)
+		}
+		parts[p.Header.Get("Content-Type")] = string(content)
+	}
+	text, html := parts["text/plain; charset=UTF-8"], parts["text/html; charset=UTF-8"]
+	for _, want := range []string{"任务: web-abc12", "状态: Failed (之前: Running)", "失败原因: exit code 1 <script>", "任务详情: " + msg.Url} {
+		if !strings.Contains(text, want) {
+			t.Errorf("text part missing %q:\n%s", want, text)
+		}
+	}
+	for _, want := range []string{`<a href="` + msg.Url + `">web-abc12</a>`, "exit code 1 &lt;script&gt;"} {
+		if !strings.Contains(html, want) {
+			t.Errorf("html part missing %q:\n%s", want, html)
+		}
+	}
+	if len(parts) != 2 {
+		t.Errorf("parts = %v", parts)
+	}
+}
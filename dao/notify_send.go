@@ -0,0 +1,182 @@
+package dao
+
+// 按通知目标的类型生成请求内容并发送
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"github.com/pkg/errors"
+	"io/ioutil"
+	"lyyops-cicd/config"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// 通用webhook的请求头
+const (
+	NotifyHeaderEvent     = "X-Cicd-Event"
+	NotifyHeaderDelivery  = "X-Cicd-Delivery"
+	NotifyHeaderTimestamp = "X-Cicd-Timestamp"
+	NotifyHeaderSignature = "X-Cicd-Signature" // sha256=hex(hmac_sha256(secret, timestamp + "." + body))
+)
+
+// 发送一次通知，返回http状态码
+func (t *NotifyTarget) post(ctx context.Context, deliveryId string, msg *NotifyMessage) (int, error) {
+	var (
+		target = t.Url
+		body   []byte
+		header = http.Header{}
+		err    error
+	)
+	header.Set("Content-Type", "application/json")
+	switch t.Type {
+	case NotifyTypeWebhook:
+		body, err = json.Marshal(msg)
+		ts := strconv.FormatInt(time.Now().Unix(), 10)
+		header.Set(NotifyHeaderEvent, msg.Event)
+		header.Set(NotifyHeaderDelivery, deliveryId)
+		header.Set(NotifyHeaderTimestamp, ts)
+		if t.Secret != "" {
+			header.Set(NotifyHeaderSignature, "sha256="+NotifySignature(t.Secret, ts, body))
+		}
+	case NotifyTypeDingtalk:
+		body, err = json.Marshal(map[string]interface{}{
+			"msgtype":  "markdown",
+			"markdown": map[string]string{"title": msg.Title(), "text": msg.Markdown()},
+		})
+		if t.Secret != "" {
+			target, err = dingtalkSignUrl(t.Url, t.Secret, time.Now())
+		}
+	case NotifyTypeWecom:
+		body, err = json.Marshal(map[string]interface{}{
+			"msgtype":  "markdown",
+			"markdown": map[string]string{"content": msg.Markdown()},
+		})
+	case NotifyTypeSlack:
+		body, err = json.Marshal(map[string]string{"text": msg.Text()})
+	default:
+		return 0, errors.Errorf("unknown notify type: %s", t.Type)
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	timeout := config.Config.Notify.Timeout
+	if timeout <= 0 {
+		timeout = defaultNotifyTimeout
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, target, bytes.NewReader(body))
+	if err != nil {
+		return 0, err
+	}
+	req.Header = header
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		if ue, ok := err.(*url.Error); ok {
+			err = errors.Errorf("%s: %v", ue.Op, ue.Err) // 错误中不包含url，url中可能有token
+		}
+		return 0, err
+	}
+	defer resp.Body.Close()
+	data, _ := ioutil.ReadAll(resp.Body)
+	if resp.StatusCode >= 300 {
+		return resp.StatusCode, errors.Errorf("%s: %s", resp.Status, truncate(string(data), 200))
+	}
+	// 钉钉和企业微信返回200，错误在errcode中
+	if t.Type == NotifyTypeDingtalk || t.Type == NotifyTypeWecom {
+		res := struct {
+			ErrCode int    `json:"errcode"`
+			ErrMsg  string `json:"errmsg"`
+		}{}
+		if err := json.Unmarshal(data, &res); err == nil && res.ErrCode != 0 {
+			return resp.StatusCode, errors.Errorf("errcode %d: %s", res.ErrCode, res.ErrMsg)
+		}
+	}
+	return resp.StatusCode, nil
+}
+
+// 通用webhook的签名，接收方用同样的方法计算后比较
+func NotifySignature(secret, timestamp string, body []byte) string {
+	h := hmac.New(sha256.New, []byte(secret))
+	h.Write([]byte(timestamp + "."))
+	h.Write(body)
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// 钉钉机器人加签: url后加上timestamp(毫秒)和sign
+func dingtalkSignUrl(rawUrl, secret string, now time.Time) (string, error) {
+	u, err := url.Parse(rawUrl)
+	if err != nil {
+		return "", err
+	}
+	ts := strconv.FormatInt(now.UnixNano()/int64(time.Millisecond), 10)
+	h := hmac.New(sha256.New, []byte(secret))
+	h.Write([]byte(ts + "\n" + secret))
+	q := u.Query()
+	q.Set("timestamp", ts)
+	q.Set("sign", base64.StdEncoding.EncodeToString(h.Sum(nil)))
+	u.RawQuery = q.Encode()
+	return u.String(), nil
+}
+
+func (m *NotifyMessage) Title() string {
+	return fmt.Sprintf("[%s] %s %s", m.AppId, m.JobId, m.Status)
+}
+
+// 纯文本内容(Slack)
+func (m *NotifyMessage) Text() string {
+	lines := []string{m.Title()}
+	lines = append(lines, m.details()...)
+	if m.Url != "" {
+		lines = append(lines, m.Url)
+	}
+	if m.LogsUrl != "" {
+		lines = append(lines, m.LogsUrl)
+	}
+	return strings.Join(lines, "\n")
+}
+
+// markdown内容(钉钉、企业微信)
+func (m *NotifyMessage) Markdown() string {
+	lines := []string{"### " + m.Title()}
+	for _, line := range m.details() {
+		lines = append(lines, "- "+line)
+	}
+	if m.Url != "" {
+		lines = append(lines, fmt.Sprintf("[查看任务](%s)", m.Url))
+	}
+	if m.LogsUrl != "" {
+		lines = append(lines, fmt.Sprintf("[查看日志](%s)", m.LogsUrl))
+	}
+	return strings.Join(lines, "\n")
+}
+
+func (m *NotifyMessage) details() []string {
+	lines := []string{"状态: " + m.Status, "触发方式: " + m.Trigger}
+	if m.Branch != "" {
+		lines = append(lines, "分支: "+m.Branch)
+	}
+	lines = append(lines, "耗时: "+m.Cost)
+	if m.FailedPhase != "" {
+		lines = append(lines, fmt.Sprintf("失败步骤: %s %s", m.FailedPhase, m.FailedMessage))
+	}
+	return lines
+}
+
+func truncate(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+	return s[:n] + "..."
+}
@@ -0,0 +1,201 @@
+package dao
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	wfv1 "github.com/argoproj/argo-workflows/v3/pkg/apis/workflow/v1alpha1"
+	"github.com/go-redis/redis/v8"
+	"github.com/pkg/errors"
+	"io/ioutil"
+	"lyyops-cicd/config"
+	"lyyops-cicd/pkg/log"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// 记录收到的请求，按顺序返回设置的响应
+type notifyServer struct {
+	*httptest.Server
+	requests []*http.Request
+	bodies   [][]byte
+	replies  []string
+	codes    []int
+}
+
+func newNotifyServer(t *testing.T) *notifyServer {
+	s := &notifyServer{}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		i := len(s.requests)
+		s.requests = append(s.requests, r)
+		s.bodies = append(s.bodies, body)
+		if i < len(s.codes) && s.codes[i] != 0 {
+			w.WriteHeader(s.codes[i])
+		}
+		if i < len(s.replies) {
+			w.Write([]byte(s.replies[i]))
+		}
+	}))
+	t.Cleanup(s.Close)
+	return s
+}
+
+func hmacSha256(key, data string) []byte {
+	mac := hmac.New(sha256.New, []byte(key))
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
+
+func TestNotifyPostWebhookSignature(t *testing.T) {
+	s := newNotifyServer(t)
+	target := &NotifyTarget{Id: "t1", Type: NotifyTypeWebhook, Url: s.URL + "/hook", Secret: "s3cret"}
+	msg := &NotifyMessage{Event: "job.status", JobId: "app-1", AppId: "app", Status: JobStatusFailed}
+
+	code, err := target.post(context.Background(), "d1", msg)
+	if err != nil || code != http.StatusOK {
+		t.Fatalf("post = %d, %v", code, err)
+	}
+	r, body := s.requests[0], s.bodies[0]
+	ts := r.Header.Get(NotifyHeaderTimestamp)
+	want := "sha256=" + hex.EncodeToString(hmacSha256("s3cret", ts+"."+string(body)))
+	if got := r.Header.Get(NotifyHeaderSignature); got != want {
+		t.Errorf("%s = %q, want %q", NotifyHeaderSignature, got, want)
+	}
+	if r.Header.Get(NotifyHeaderEvent) != "job.status" || r.Header.Get(NotifyHeaderDelivery) != "d1" {
+		t.Errorf("headers = %v", r.Header)
+	}
+	var got NotifyMessage
+	if err := json.Unmarshal(body, &got); err != nil || got.JobId != "app-1" || got.Status != JobStatusFailed {
+		t.Errorf("body = %s, %v", body, err)
+	}
+
+	// 没有secret时不签名
+	target.Secret = ""
+	if _, err := target.post(context.Background(), "d2", msg); err != nil {
+		t.Fatal(err)
+	}
+	if sig := s.requests[1].Header.Get(NotifyHeaderSignature); sig != "" {
+		t.Errorf("unsigned request has %s: %s", NotifyHeaderSignature, sig)
+	}
+}
+
+func TestNotifyPostDingtalkSign(t *testing.T) {
+	s := newNotifyServer(t)
+	s.replies = []string{`{"errcode":0,"errmsg":"ok"}`, `{"errcode":310000,"errmsg":"sign not match"}`}
+	target := &NotifyTarget{Id: "t1", Type: NotifyTypeDingtalk, Url: s.URL + "/robot/send?access_token=abc", Secret: "SECxyz"}
+	msg := &NotifyMessage{JobId: "app-1", AppId: "app", Status: JobStatusSucceeded}
+
+	if _, err := target.post(context.Background(), "d1", msg); err != nil {
+		t.Fatalf("post: %v", err)
+	}
+	q := s.requests[0].URL.Query()
+	ts := q.Get("timestamp")
+	if ts == "" || q.Get("access_token") != "abc" {
+		t.Fatalf("query = %v", q)
+	}
+	if want := base64.StdEncoding.EncodeToString(hmacSha256("SECxyz", ts+"\n"+"SECxyz")); q.Get("sign") != want {
+		t.Errorf("sign = %q, want %q", q.Get("sign"), want)
+	}
+	var body struct {
+		Msgtype  string            `json:"msgtype"`
+		Markdown map[string]string `json:"markdown"`
+	}
+	if err := json.Unmarshal(s.bodies[0], &body); err != nil || body.Msgtype != "markdown" || body.Markdown["title"] != msg.Title() {
+		t.Errorf("body = %s, %v", s.bodies[0], err)
+	}
+
+	// 返回200但errcode不为0时失败
+	code, err := target.post(context.Background(), "d2", msg)
+	if err == nil || code != http.StatusOK || !strings.Contains(err.Error(), "310000") {
+		t.Errorf("post errcode = %d, %v", code, err)
+	}
+}
+
+func TestNotifyPostErrors(t *testing.T) {
+	s := newNotifyServer(t)
+	s.replies = []string{`{"errcode":93000,"errmsg":"invalid webhook url"}`, `bad gateway`, `{"errcode":1}`}
+	s.codes = []int{0, http.StatusBadGateway, 0}
+	msg := &NotifyMessage{JobId: "app-1", AppId: "app", Status: JobStatusFailed}
+
+	wecom := &NotifyTarget{Type: NotifyTypeWecom, Url: s.URL}
+	if _, err := wecom.post(context.Background(), "d1", msg); err == nil || !strings.Contains(err.Error(), "93000") {
+		t.Errorf("wecom errcode: %v", err)
+	}
+	if code, err := wecom.post(context.Background(), "d2", msg); err == nil || code != http.StatusBadGateway {
+		t.Errorf("wecom 502 = %d, %v", code, err)
+	}
+	// 其他类型不检查errcode
+	slack := &NotifyTarget{Type: NotifyTypeSlack, Url: s.URL}
+	if _, err := slack.post(context.Background(), "d3", msg); err != nil {
+		t.Errorf("slack: %v", err)
+	}
+	// 错误中不包含url中的token
+	s.Close()
+	dingtalk := &NotifyTarget{Type: NotifyTypeDingtalk, Url: s.URL + "?access_token=secret-token"}
+	if _, err := dingtalk.post(context.Background(), "d4", msg); err == nil || strings.Contains(err.Error(), "secret-token") {
+		t.Errorf("closed server: %v", err)
+	}
+}
+
+func TestNotifyDeliverRetry(t *testing.T) {
+	log.NewLogger(config.Log{FilePath: filepath.Join(t.TempDir(), "test.log")})
+	// 发送记录保存到一个不可用的redis，保存失败只记录日志
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	l.Close()
+	oldClient, oldConf := RedisClient, config.Config.Notify
+	defer func() { RedisClient, config.Config.Notify = oldClient, oldConf }()
+	RedisClient = redis.NewClient(&redis.Options{Addr: l.Addr().String(), MaxRetries: -1})
+	config.Config.Notify.Retries = 2
+	config.Config.Notify.Backoff = 20 * time.Millisecond
+
+	msg := &NotifyMessage{JobId: "app-1", Status: JobStatusFailed}
+	var sent []time.Time
+	send := func(fails int) func() (int, error) {
+		return func() (int, error) {
+			sent = append(sent, time.Now())
+			if len(sent) <= fails {
+				return http.StatusInternalServerError, errors.New("500 Internal Server Error")
+			}
+			return http.StatusOK, nil
+		}
+	}
+
+	d := newNotifyDelivery(msg, "t1", NotifyTypeWebhook).deliver(context.Background(), send(1))
+	if d.Status != NotifyDeliverySuccess || len(d.Attempts) != 2 || d.Attempts[0].StatusCode != 500 || d.Attempts[1].Error != "" {
+		t.Errorf("deliver after one failure = %+v", d)
+	}
+
+	sent = nil
+	d = newNotifyDelivery(msg, "t1", NotifyTypeWebhook).deliver(context.Background(), send(10))
+	if d.Status != NotifyDeliveryFailed || len(d.Attempts) != 3 {
+		t.Fatalf("deliver failing = %+v", d)
+	}
+	// 退避时间每次翻倍: 20ms, 40ms
+	if gap := sent[1].Sub(sent[0]); gap < 20*time.Millisecond {
+		t.Errorf("first backoff = %s, want >= 20ms", gap)
+	}
+	if gap := sent[2].Sub(sent[1]); gap < 40*time.Millisecond {
+		t.Errorf("second backoff = %s, want >= 40ms", gap)
+	}
+}
+
+func TestNotifyTransition(t *testing.T) {
+	if notifyTransition(JobStatusAwaitingApproval, "deploy") == notifyTransition(JobStatusAwaitingApproval, "release") {
+		t.Error("different approval gates should be different transitions")
+	}
+	if notifyTransition(string(wfv1.WorkflowRunning), "deploy") != notifyTransition(string(wfv1.WorkflowRunning), "") {
+		t.Error("gate should only be part of the AwaitingApproval transition")
+	}
+}
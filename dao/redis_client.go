@@ -18,6 +18,7 @@ func InitRedis() error {
 		Addr:     config.Config.Redis.Address,
 		DB:       config.Config.Redis.Db,
 		Password: config.Config.Redis.Password,
+		PoolSize: config.Config.Redis.PoolSize,
 	})
 	ctx, cancel := context.WithTimeout(context.Background(), defaultRedisTimeout) // 10秒超时
 	defer cancel()
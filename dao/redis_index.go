@@ -0,0 +1,74 @@
+package dao
+
+// 对象Id的索引集合: 列表查询读取索引集合，不使用KEYS遍历整个redis
+// 升级前保存的对象没有索引，第一次查询时用SCAN重建，重建后记录标记，之后不再SCAN
+
+import (
+	"context"
+	"github.com/go-redis/redis/v8"
+	"github.com/pkg/errors"
+	"strings"
+)
+
+// 索引中的全部Id，pattern为对象key的通配符，extract从key中取出Id
+func indexMembers(ctx context.Context, indexKey, pattern string, extract func(string) string) ([]string, error) {
+	if err := rebuildIndex(ctx, indexKey, pattern, extract); err != nil {
+		return nil, err
+	}
+	ids, err := RedisClient.SMembers(ctx, indexKey).Result()
+	return ids, errors.Wrap(err, "RedisClient.SMembers")
+}
+
+// 没有重建标记时把已有的对象加入索引(升级前保存的对象)
+func rebuildIndex(ctx context.Context, indexKey, pattern string, extract func(string) string) error {
+	ready := indexKey + "-ready"
+	if n, err := RedisClient.Exists(ctx, ready).Result(); err != nil || n > 0 {
+		return err
+	}
+	ids, err := scanIds(ctx, pattern, extract)
+	if err != nil {
+		return err
+	}
+	if len(ids) > 0 {
+		args := make([]interface{}, len(ids))
+		for i, id := range ids {
+			args[i] = id
+		}
+		if err := RedisClient.SAdd(ctx, indexKey, args...).Err(); err != nil {
+			return errors.Wrap(err, "RedisClient.SAdd")
+		}
+	}
+	return RedisClient.Set(ctx, ready, 1, -1).Err()
+}
+
+// 用SCAN遍历匹配的key，返回其中的Id
+func scanIds(ctx context.Context, pattern string, extract func(string) string) ([]string, error) {
+	var (
+		ids    []string
+		cursor uint64
+	)
+	for {
+		keys, next, err := RedisClient.Scan(ctx, cursor, pattern, 500).Result()
+		if err != nil {
+			return nil, errors.Wrap(err, "RedisClient.Scan")
+		}
+		for _, key := range keys {
+			ids = append(ids, extract(key))
+		}
+		if cursor = next; cursor == 0 {
+			return ids, nil
+		}
+	}
+}
+
+// 对象不存在时从索引中删除
+func pruneIndexMember(ctx context.Context, indexKey, id string, err error) {
+	if errors.Cause(err) == redis.Nil {
+		RedisClient.SRem(ctx, indexKey, id)
+	}
+}
+
+// 对象类型的索引集合，如 schedule, webhook-trigger
+func ObjectIndexKey(kind string) string {
+	return strings.Join([]string{"cicd", kind + "-index"}, sep)
+}
@@ -0,0 +1,73 @@
+package dao
+
+// 多个副本之间的redis锁: 用随机token标识持有者，持有期间定时续期，释放时只删除自己持有的锁
+
+import (
+	"context"
+	"github.com/go-redis/redis/v8"
+	"github.com/pkg/errors"
+	utilrand "k8s.io/apimachinery/pkg/util/rand"
+	"lyyops-cicd/pkg/log"
+	"sync"
+	"time"
+)
+
+var (
+	unlockScript = redis.NewScript(`
+if redis.call("get", KEYS[1]) == ARGV[1] then
+	return redis.call("del", KEYS[1])
+end
+return 0`)
+	extendScript = redis.NewScript(`
+if redis.call("get", KEYS[1]) == ARGV[1] then
+	return redis.call("pexpire", KEYS[1], ARGV[2])
+end
+return 0`)
+)
+
+// 在wait时间内获取锁，返回释放锁的函数；wait为0时只尝试一次
+func acquireLock(ctx context.Context, key string, ttl, wait time.Duration) (func(), error) {
+	token := utilrand.String(16)
+	deadline := time.Now().Add(wait)
+	for {
+		ok, err := RedisClient.SetNX(ctx, key, token, ttl).Result()
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			break
+		}
+		if !time.Now().Before(deadline) {
+			return nil, errors.Errorf("获取锁 %s 超时", key)
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+
+	// 持有期间每ttl/3续期一次，避免操作超过ttl时被其他副本获取
+	done := make(chan struct{})
+	go func() {
+		ticker := time.NewTicker(ttl / 3)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				n, err := extendScript.Run(context.Background(), RedisClient, []string{key}, token, ttl.Milliseconds()).Int()
+				if err != nil || n == 0 {
+					log.Warningf("lock %s lost: %v", key, err)
+					return
+				}
+			}
+		}
+	}()
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			close(done)
+			if err := unlockScript.Run(context.Background(), RedisClient, []string{key}, token).Err(); err != nil {
+				log.Warningf("unlock %s: %v", key, err)
+			}
+		})
+	}, nil
+}
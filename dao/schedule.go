@@ -0,0 +1,106 @@
+package dao
+
+// 定时任务: 按cron表达式定时创建发布任务
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/pkg/errors"
+	"lyyops-cicd/pkg/common"
+	"strings"
+	"time"
+)
+
+type Schedule struct {
+	Ctx        context.Context   `json:"-"`
+	Id         string            `json:"id"`
+	AppId      string            `json:"app_id"`
+	Cron       string            `json:"cron"`
+	Parameters map[string]string `json:"parameters"`
+	Paused     bool              `json:"paused"`
+	CreatedBy  string            `json:"created_by"`
+	CreatedAt  time.Time         `json:"created_at"`
+	LastFire   time.Time         `json:"last_fire"` // 最近一次触发的时间点
+	LastJob    string            `json:"last_job"`
+	LastError  string            `json:"last_error"`
+}
+
+func GetSchedule(ctx context.Context, id string) (*Schedule, error) {
+	bs, err := RedisClient.Get(ctx, ScheduleKey(id)).Bytes()
+	if err != nil {
+		return nil, errors.Wrapf(err, "schedule %s", id)
+	}
+	s := &Schedule{}
+	if err := json.Unmarshal(bs, s); err != nil {
+		return nil, err
+	}
+	s.Ctx = ctx
+	return s, nil
+}
+
+// 定时任务列表，appId为空时返回全部
+func ListSchedule(ctx context.Context, appId string) ([]*Schedule, error) {
+	var schedules = []*Schedule{}
+	ids, err := indexMembers(ctx, ScheduleIndexKey(), ScheduleKey("*"), ExtractScheduleName)
+	if err != nil {
+		return nil, err
+	}
+	for _, id := range ids {
+		s, err := GetSchedule(ctx, id)
+		if err != nil {
+			pruneIndexMember(ctx, ScheduleIndexKey(), id, err)
+			continue
+		}
+		if appId != "" && s.AppId != appId {
+			continue
+		}
+		schedules = append(schedules, s)
+	}
+	return schedules, nil
+}
+
+func (s *Schedule) Save() error {
+	if s.Id == "" {
+		s.Id = fmt.Sprintf("%s-%d", s.AppId, time.Now().UnixNano())
+	}
+	pipe := RedisClient.TxPipeline()
+	pipe.Set(s.Ctx, ScheduleKey(s.Id), []byte(common.ParseJsonStr(s)), -1)
+	pipe.SAdd(s.Ctx, ScheduleIndexKey(), s.Id)
+	_, err := pipe.Exec(s.Ctx)
+	return err
+}
+
+// 只更新已存在的定时任务，触发期间被删除时不重新创建，返回是否已更新
+func (s *Schedule) Update() (bool, error) {
+	return RedisClient.SetXX(s.Ctx, ScheduleKey(s.Id), []byte(common.ParseJsonStr(s)), 0).Result()
+}
+
+func (s *Schedule) Delete() error {
+	pipe := RedisClient.TxPipeline()
+	pipe.Del(s.Ctx, ScheduleKey(s.Id))
+	pipe.SRem(s.Ctx, ScheduleIndexKey(), s.Id)
+	_, err := pipe.Exec(s.Ctx)
+	return err
+}
+
+// 抢占某个触发时间点，多个副本中只有一个能成功
+func (s *Schedule) AcquireFire(fireTime time.Time) bool {
+	key := strings.Join([]string{"cicd", "schedule-fire", s.Id, fmt.Sprint(fireTime.Unix())}, sep)
+	ok, err := RedisClient.SetNX(s.Ctx, key, time.Now().Format(time.RFC3339), time.Hour).Result()
+	return err == nil && ok
+}
+
+func ScheduleKey(id string) string {
+	return strings.Join([]string{"cicd", "schedule", id}, sep)
+}
+
+// 全部定时任务的Id
+func ScheduleIndexKey() string {
+	return ObjectIndexKey("schedule")
+}
+
+func ExtractScheduleName(fullname string) string {
+	names := strings.Split(fullname, sep)
+	return names[2]
+}
@@ -0,0 +1,198 @@
+package dao
+
+// webhook触发规则和接收记录
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/go-redis/redis/v8"
+	"github.com/pkg/errors"
+	"lyyops-cicd/pkg/common"
+	"strings"
+	"time"
+)
+
+// 根据仓库地址和分支把push/tag事件映射到应用
+type WebhookTrigger struct {
+	Ctx         context.Context   `json:"-"`
+	Id          string            `json:"id"`
+	AppId       string            `json:"app_id"`
+	RepoUrl     string            `json:"repo_url"`
+	Events      []string          `json:"events"`   // push, tag，为空时只处理push
+	Branches    []string          `json:"branches"` // 分支或tag的通配符，为空时不过滤
+	Parameters  map[string]string `json:"parameters"`
+	BranchParam string            `json:"branch_param"` // 分支名对应的workflow参数，默认branch
+	CommitParam string            `json:"commit_param"` // commit sha对应的workflow参数，默认commit
+	CreatedBy   string            `json:"created_by"`
+	CreatedAt   time.Time         `json:"created_at"`
+}
+
+// webhook接收记录，用于重放和排查
+type WebhookDelivery struct {
+	Ctx        context.Context   `json:"-"`
+	Id         string            `json:"id"`
+	Provider   string            `json:"provider"`
+	Headers    map[string]string `json:"headers"`
+	Body       string            `json:"body"`
+	ReceivedAt time.Time         `json:"received_at"`
+	ReplayOf   string            `json:"replay_of"`
+	Event      string            `json:"event"`
+	Ref        string            `json:"ref"`
+	Commit     string            `json:"commit"`
+	Jobs       []string          `json:"jobs"`
+	Errors     []string          `json:"errors"`
+}
+
+func GetWebhookTrigger(ctx context.Context, id string) (*WebhookTrigger, error) {
+	bs, err := RedisClient.Get(ctx, WebhookTriggerKey(id)).Bytes()
+	if err != nil {
+		return nil, errors.Wrapf(err, "webhook trigger %s", id)
+	}
+	t := &WebhookTrigger{}
+	if err := json.Unmarshal(bs, t); err != nil {
+		return nil, err
+	}
+	t.Ctx = ctx
+	return t, nil
+}
+
+// webhook触发规则列表，appId为空时返回全部
+func ListWebhookTrigger(ctx context.Context, appId string) ([]*WebhookTrigger, error) {
+	var triggers = []*WebhookTrigger{}
+	ids, err := indexMembers(ctx, WebhookTriggerIndexKey(), WebhookTriggerKey("*"), ExtractWebhookTriggerName)
+	if err != nil {
+		return nil, err
+	}
+	for _, id := range ids {
+		t, err := GetWebhookTrigger(ctx, id)
+		if err != nil {
+			pruneIndexMember(ctx, WebhookTriggerIndexKey(), id, err)
+			continue
+		}
+		if appId != "" && t.AppId != appId {
+			continue
+		}
+		triggers = append(triggers, t)
+	}
+	return triggers, nil
+}
+
+func (t *WebhookTrigger) Save() error {
+	if t.Id == "" {
+		t.Id = fmt.Sprintf("%s-%d", t.AppId, time.Now().UnixNano())
+	}
+	pipe := RedisClient.TxPipeline()
+	pipe.Set(t.Ctx, WebhookTriggerKey(t.Id), []byte(common.ParseJsonStr(t)), -1)
+	pipe.SAdd(t.Ctx, WebhookTriggerIndexKey(), t.Id)
+	_, err := pipe.Exec(t.Ctx)
+	return err
+}
+
+func (t *WebhookTrigger) Delete() error {
+	pipe := RedisClient.TxPipeline()
+	pipe.Del(t.Ctx, WebhookTriggerKey(t.Id))
+	pipe.SRem(t.Ctx, WebhookTriggerIndexKey(), t.Id)
+	_, err := pipe.Exec(t.Ctx)
+	return err
+}
+
+func GetWebhookDelivery(ctx context.Context, id string) (*WebhookDelivery, error) {
+	bs, err := RedisClient.Get(ctx, WebhookDeliveryKey(id)).Bytes()
+	if err != nil {
+		return nil, errors.Wrapf(err, "webhook delivery %s", id)
+	}
+	d := &WebhookDelivery{}
+	if err := json.Unmarshal(bs, d); err != nil {
+		return nil, err
+	}
+	d.Ctx = ctx
+	return d, nil
+}
+
+// 最近的webhook接收记录(不包含请求内容)，按接收时间倒序
+func ListWebhookDelivery(ctx context.Context, size int) ([]*WebhookDelivery, error) {
+	var deliveries = []*WebhookDelivery{}
+	if err := rebuildDeliveryIndex(ctx); err != nil {
+		return nil, err
+	}
+	stop := int64(-1)
+	if size > 0 {
+		stop = int64(size) - 1
+	}
+	ids, err := RedisClient.ZRevRange(ctx, WebhookDeliveryIndexKey(), 0, stop).Result()
+	if err != nil {
+		return nil, errors.Wrap(err, "RedisClient.ZRevRange")
+	}
+	for _, id := range ids {
+		d, err := GetWebhookDelivery(ctx, id)
+		if err != nil {
+			if errors.Cause(err) == redis.Nil {
+				RedisClient.ZRem(ctx, WebhookDeliveryIndexKey(), id) // 已过期
+			}
+			continue
+		}
+		d.Body = ""
+		deliveries = append(deliveries, d)
+	}
+	return deliveries, nil
+}
+
+// 接收记录按接收时间保存在有序集合中，升级前的记录第一次查询时加入
+func rebuildDeliveryIndex(ctx context.Context) error {
+	ready := WebhookDeliveryIndexKey() + "-ready"
+	if n, err := RedisClient.Exists(ctx, ready).Result(); err != nil || n > 0 {
+		return err
+	}
+	ids, err := scanIds(ctx, WebhookDeliveryKey("*"), ExtractWebhookDeliveryName)
+	if err != nil {
+		return err
+	}
+	for _, id := range ids {
+		if d, err := GetWebhookDelivery(ctx, id); err == nil {
+			RedisClient.ZAdd(ctx, WebhookDeliveryIndexKey(), &redis.Z{Score: float64(d.ReceivedAt.Unix()), Member: id})
+		}
+	}
+	return RedisClient.Set(ctx, ready, 1, -1).Err()
+}
+
+func (d *WebhookDelivery) Save() error {
+	if d.Id == "" {
+		d.Id = fmt.Sprintf("%s-%d", d.Provider, time.Now().UnixNano())
+	}
+	pipe := RedisClient.TxPipeline()
+	pipe.Set(d.Ctx, WebhookDeliveryKey(d.Id), []byte(common.ParseJsonStr(d)), defaultExpired)
+	pipe.ZAdd(d.Ctx, WebhookDeliveryIndexKey(), &redis.Z{Score: float64(d.ReceivedAt.Unix()), Member: d.Id})
+	// 清理已过期记录的索引
+	pipe.ZRemRangeByScore(d.Ctx, WebhookDeliveryIndexKey(), "-inf", fmt.Sprint(time.Now().Add(-defaultExpired).Unix()))
+	_, err := pipe.Exec(d.Ctx)
+	return err
+}
+
+func WebhookTriggerKey(id string) string {
+	return strings.Join([]string{"cicd", "webhook-trigger", id}, sep)
+}
+
+// 全部webhook触发规则的Id
+func WebhookTriggerIndexKey() string {
+	return ObjectIndexKey("webhook-trigger")
+}
+
+func ExtractWebhookTriggerName(fullname string) string {
+	names := strings.Split(fullname, sep)
+	return names[2]
+}
+
+func WebhookDeliveryKey(id string) string {
+	return strings.Join([]string{"cicd", "webhook-delivery", id}, sep)
+}
+
+// 全部接收记录，按接收时间排序
+func WebhookDeliveryIndexKey() string {
+	return ObjectIndexKey("webhook-delivery")
+}
+
+func ExtractWebhookDeliveryName(fullname string) string {
+	names := strings.Split(fullname, sep)
+	return names[2]
+}
@@ -23,6 +23,125 @@ var doc = `{
     "host": "{{.Host}}",
     "basePath": "{{.BasePath}}",
     "paths": {
+        "/admin/clean": {
+            "get": {
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "后台管理"
+                ],
+                "summary": "最近一次任务清理的结果",
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "type": "string"
+                        }
+                    }
+                }
+            },
+            "post": {
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "后台管理"
+                ],
+                "summary": "立即执行任务清理",
+                "parameters": [
+                    {
+                        "enum": [
+                            true,
+                            false
+                        ],
+                        "type": "boolean",
+                        "description": "只返回将要清理的任务，不做删除",
+                        "name": "dry_run",
+                        "in": "query",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "type": "string"
+                        }
+                    }
+                }
+            }
+        },
+        "/admin/reconcile": {
+            "get": {
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "后台管理"
+                ],
+                "summary": "最近一次任务状态修复的结果",
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "type": "string"
+                        }
+                    }
+                }
+            },
+            "post": {
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "后台管理"
+                ],
+                "summary": "立即执行任务状态修复",
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "type": "string"
+                        }
+                    }
+                }
+            }
+        },
+        "/admin/reindex": {
+            "post": {
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "后台管理"
+                ],
+                "summary": "根据任务记录重建任务索引",
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "type": "string"
+                        }
+                    }
+                }
+            }
+        },
         "/application/create": {
             "post": {
                 "consumes": [
@@ -365,8 +484,54 @@ var doc = `{
                 }
             }
         },
+        "/job/approve/{id}": {
+            "post": {
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "发布任务管理"
+                ],
+                "summary": "审批通过，恢复等待审批的workflow",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "任务 ID",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "description": "审批节点和意见",
+                        "name": "input",
+                        "in": "body",
+                        "schema": {
+                            "$ref": "#/definitions/dto.ApprovalInput"
+                        }
+                    },
+                    {
+                        "type": "string",
+                        "description": "审批人",
+                        "name": "user",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "type": "string"
+                        }
+                    }
+                }
+            }
+        },
         "/job/create": {
             "post": {
+                "description": "提交时把参数commit(分支或tag)或branch解析成commit sha记录在任务上；仓库地址取参数repo_url或应用的webhook触发规则，只支持http(s)地址，无法解析时commit为空",
                 "consumes": [
                     "application/json"
                 ],
@@ -387,10 +552,28 @@ var doc = `{
                     },
                     {
                         "type": "string",
-                        "description": "代码分支",
+                        "description": "代码分支(等同于参数branch)",
                         "name": "branch",
-                        "in": "query",
-                        "required": true
+                        "in": "query"
+                    },
+                    {
+                        "description": "workflow参数",
+                        "name": "input",
+                        "in": "body",
+                        "schema": {
+                            "$ref": "#/definitions/dto.CreateJobInput"
+                        }
+                    },
+                    {
+                        "enum": [
+                            "queue",
+                            "reject",
+                            "cancel"
+                        ],
+                        "type": "string",
+                        "description": "并发数已满时的策略",
+                        "name": "policy",
+                        "in": "query"
                     }
                 ],
                 "responses": {
@@ -434,25 +617,35 @@ var doc = `{
                 }
             }
         },
-        "/job/list": {
+        "/job/events/{id}": {
             "get": {
-                "consumes": [
-                    "application/json"
-                ],
+                "description": "每个事件的data与获取发布任务的返回相同，任务结束时发送end事件并关闭连接\n连接不受server.writeTimeout限制；每个连接等待事件时占用一个redis连接(XREAD BLOCK)，同时订阅的客户端数受redis.poolSize限制",
                 "produces": [
-                    "application/json"
+                    "text/event-stream"
                 ],
                 "tags": [
                     "发布任务管理"
                 ],
-                "summary": "发布任务列表",
+                "summary": "订阅发布任务的状态变化(Server-Sent Events)",
                 "parameters": [
                     {
-                        "type": "integer",
-                        "description": "数量",
-                        "name": "size",
-                        "in": "query",
+                        "type": "string",
+                        "description": "任务 ID",
+                        "name": "id",
+                        "in": "path",
                         "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "断线重连时上次收到的事件id",
+                        "name": "Last-Event-ID",
+                        "in": "header"
+                    },
+                    {
+                        "type": "string",
+                        "description": "同Last-Event-ID，用于不能设置header的客户端",
+                        "name": "last_event_id",
+                        "in": "query"
                     }
                 ],
                 "responses": {
@@ -465,7 +658,7 @@ var doc = `{
                 }
             }
         },
-        "/job/search": {
+        "/job/list": {
             "get": {
                 "consumes": [
                     "application/json"
@@ -478,26 +671,92 @@ var doc = `{
                 ],
                 "summary": "发布任务列表",
                 "parameters": [
+                    {
+                        "type": "integer",
+                        "description": "数量",
+                        "name": "size",
+                        "in": "query"
+                    },
                     {
                         "type": "string",
-                        "description": "关键字",
-                        "name": "keyword",
-                        "in": "query",
-                        "required": true
+                        "description": "上一页返回的next_cursor",
+                        "name": "cursor",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "应用",
+                        "name": "app_id",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "任务状态",
+                        "name": "status",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "代码分支",
+                        "name": "branch",
+                        "in": "query"
+                    },
+                    {
+                        "enum": [
+                            "api",
+                            "webhook",
+                            "schedule",
+                            "retry",
+                            "resubmit"
+                        ],
+                        "type": "string",
+                        "description": "触发方式",
+                        "name": "trigger",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "调用者",
+                        "name": "user",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "完整的commit sha",
+                        "name": "commit",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "应用内容的版本",
+                        "name": "revision",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "开始时间下限(RFC3339)",
+                        "name": "since",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "开始时间上限(RFC3339)",
+                        "name": "until",
+                        "in": "query"
                     }
                 ],
                 "responses": {
                     "200": {
                         "description": "OK",
                         "schema": {
-                            "type": "string"
+                            "$ref": "#/definitions/dto.ListJobPageOutput"
                         }
                     }
                 }
             }
         },
-        "/job/{id}": {
-            "get": {
+        "/job/reject/{id}": {
+            "post": {
                 "consumes": [
                     "application/json"
                 ],
@@ -507,7 +766,7 @@ var doc = `{
                 "tags": [
                     "发布任务管理"
                 ],
-                "summary": "获取发布任务",
+                "summary": "审批拒绝，停止等待审批的workflow",
                 "parameters": [
                     {
                         "type": "string",
@@ -515,6 +774,20 @@ var doc = `{
                         "name": "id",
                         "in": "path",
                         "required": true
+                    },
+                    {
+                        "description": "审批节点和意见",
+                        "name": "input",
+                        "in": "body",
+                        "schema": {
+                            "$ref": "#/definitions/dto.ApprovalInput"
+                        }
+                    },
+                    {
+                        "type": "string",
+                        "description": "审批人",
+                        "name": "user",
+                        "in": "query"
                     }
                 ],
                 "responses": {
@@ -527,8 +800,8 @@ var doc = `{
                 }
             }
         },
-        "/logs/{id}": {
-            "get": {
+        "/job/resubmit/{id}": {
+            "post": {
                 "consumes": [
                     "application/json"
                 ],
@@ -536,13 +809,13 @@ var doc = `{
                     "application/json"
                 ],
                 "tags": [
-                    "日志管理"
+                    "发布任务管理"
                 ],
-                "summary": "获取pod日志",
+                "summary": "重新提交发布任务(使用原任务的参数)",
                 "parameters": [
                     {
                         "type": "string",
-                        "description": "pod name",
+                        "description": "任务 ID",
                         "name": "id",
                         "in": "path",
                         "required": true
@@ -558,7 +831,7 @@ var doc = `{
                 }
             }
         },
-        "/template/create": {
+        "/job/retry/{id}": {
             "post": {
                 "consumes": [
                     "application/json"
@@ -567,12 +840,1126 @@ var doc = `{
                     "application/json"
                 ],
                 "tags": [
-                    "工作流模板"
+                    "发布任务管理"
                 ],
-                "summary": "创建WorkflowTemplate",
+                "summary": "重试发布任务(只重新执行失败的步骤)",
                 "parameters": [
                     {
-                        "description": "内容",
+                        "type": "string",
+                        "description": "任务 ID",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "type": "string"
+                        }
+                    }
+                }
+            }
+        },
+        "/job/search": {
+            "get": {
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "发布任务管理"
+                ],
+                "summary": "搜索发布任务",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "关键字",
+                        "name": "keyword",
+                        "in": "query",
+                        "required": true
+                    },
+                    {
+                        "type": "integer",
+                        "description": "数量",
+                        "name": "size",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "上一页返回的next_cursor",
+                        "name": "cursor",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "应用",
+                        "name": "app_id",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "任务状态",
+                        "name": "status",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "代码分支",
+                        "name": "branch",
+                        "in": "query"
+                    },
+                    {
+                        "enum": [
+                            "api",
+                            "webhook",
+                            "schedule",
+                            "retry",
+                            "resubmit"
+                        ],
+                        "type": "string",
+                        "description": "触发方式",
+                        "name": "trigger",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "调用者",
+                        "name": "user",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "完整的commit sha",
+                        "name": "commit",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "应用内容的版本",
+                        "name": "revision",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "开始时间下限(RFC3339)",
+                        "name": "since",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "开始时间上限(RFC3339)",
+                        "name": "until",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/dto.ListJobPageOutput"
+                        }
+                    }
+                }
+            }
+        },
+        "/job/stop/{id}": {
+            "post": {
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "发布任务管理"
+                ],
+                "summary": "停止发布任务(会执行exit handler)",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "任务 ID",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "操作人",
+                        "name": "user",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "type": "string"
+                        }
+                    }
+                }
+            }
+        },
+        "/job/terminate/{id}": {
+            "post": {
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "发布任务管理"
+                ],
+                "summary": "终止发布任务",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "任务 ID",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "操作人",
+                        "name": "user",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "type": "string"
+                        }
+                    }
+                }
+            }
+        },
+        "/job/{id}": {
+            "get": {
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "发布任务管理"
+                ],
+                "summary": "获取发布任务",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "任务 ID",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "type": "string"
+                        }
+                    }
+                }
+            }
+        },
+        "/logs/job/download/{id}": {
+            "get": {
+                "produces": [
+                    "text/plain",
+                    "application/gzip"
+                ],
+                "tags": [
+                    "日志管理"
+                ],
+                "summary": "下载任务的全部日志",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "任务 ID",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "enum": [
+                            "text",
+                            "gzip"
+                        ],
+                        "type": "string",
+                        "description": "文件格式，默认text",
+                        "name": "format",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "容器名，默认所有容器",
+                        "name": "container",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "type": "string"
+                        }
+                    }
+                }
+            }
+        },
+        "/logs/job/{id}": {
+            "get": {
+                "description": "按阶段顺序返回，每行标明步骤、pod和容器",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "日志管理"
+                ],
+                "summary": "获取任务所有pod的日志",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "任务 ID",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "容器名，默认所有容器",
+                        "name": "container",
+                        "in": "query"
+                    },
+                    {
+                        "enum": [
+                            "json",
+                            "text"
+                        ],
+                        "type": "string",
+                        "description": "json返回结构化的日志(默认)，text返回每行的文本",
+                        "name": "format",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "type": "array",
+                            "items": {
+                                "$ref": "#/definitions/dao.LogRecord"
+                            }
+                        }
+                    }
+                }
+            }
+        },
+        "/logs/search": {
+            "get": {
+                "description": "按任务开始时间倒序搜索，匹配数、读取的日志字节数或搜索的任务数达到上限时返回next_cursor，从中断的日志行继续搜索",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "日志管理"
+                ],
+                "summary": "在多个任务的日志中搜索",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "搜索内容",
+                        "name": "q",
+                        "in": "query",
+                        "required": true
+                    },
+                    {
+                        "type": "boolean",
+                        "description": "q为正则表达式",
+                        "name": "regex",
+                        "in": "query"
+                    },
+                    {
+                        "type": "boolean",
+                        "description": "忽略大小写",
+                        "name": "ignore_case",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "应用",
+                        "name": "app_id",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "任务状态",
+                        "name": "status",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "步骤名",
+                        "name": "step",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "任务开始时间下限(RFC3339)",
+                        "name": "since",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "任务开始时间上限(RFC3339)",
+                        "name": "until",
+                        "in": "query"
+                    },
+                    {
+                        "type": "integer",
+                        "description": "匹配行前后各返回的行数，最多10",
+                        "name": "context",
+                        "in": "query"
+                    },
+                    {
+                        "type": "integer",
+                        "description": "最多返回的匹配数，默认100",
+                        "name": "limit",
+                        "in": "query"
+                    },
+                    {
+                        "type": "integer",
+                        "description": "最多读取的日志字节数，默认16MB，最大256MB",
+                        "name": "max_bytes",
+                        "in": "query"
+                    },
+                    {
+                        "type": "integer",
+                        "description": "最多搜索的任务数，默认200，最大1000",
+                        "name": "max_jobs",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "上次返回的next_cursor",
+                        "name": "cursor",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/dao.LogSearchResult"
+                        }
+                    }
+                }
+            }
+        },
+        "/logs/tail/{id}": {
+            "get": {
+                "description": "默认返回分块的纯文本；Accept为text/event-stream时返回SSE，事件id为下一行的offset，可用Last-Event-ID断线续传",
+                "produces": [
+                    "text/plain",
+                    "text/event-stream"
+                ],
+                "tags": [
+                    "日志管理"
+                ],
+                "summary": "实时查看pod日志",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "pod name",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "容器名，默认main",
+                        "name": "container",
+                        "in": "query"
+                    },
+                    {
+                        "type": "boolean",
+                        "description": "持续输出新的日志，任务结束后关闭(找不到所属任务时10分钟没有新日志后关闭)；15秒没有新日志时发送心跳，text格式为空行",
+                        "name": "follow",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "只返回该时间之后的日志(RFC3339)",
+                        "name": "since",
+                        "in": "query"
+                    },
+                    {
+                        "type": "integer",
+                        "description": "只返回最后N行",
+                        "name": "tail",
+                        "in": "query"
+                    },
+                    {
+                        "type": "integer",
+                        "description": "从第offset行开始(从0开始)，用于断线续传",
+                        "name": "offset",
+                        "in": "query"
+                    },
+                    {
+                        "enum": [
+                            "text",
+                            "json"
+                        ],
+                        "type": "string",
+                        "description": "text每行输出文本(默认)，json每行输出一个json",
+                        "name": "format",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "type": "string"
+                        }
+                    }
+                }
+            }
+        },
+        "/logs/{id}": {
+            "get": {
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "日志管理"
+                ],
+                "summary": "获取pod日志",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "pod name",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "容器名，默认main，all表示按时间合并所有容器",
+                        "name": "container",
+                        "in": "query"
+                    },
+                    {
+                        "enum": [
+                            "text",
+                            "json"
+                        ],
+                        "type": "string",
+                        "description": "text返回每行的文本(默认)，json返回结构化的日志",
+                        "name": "format",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "type": "string"
+                        }
+                    }
+                }
+            }
+        },
+        "/metrics/dora": {
+            "get": {
+                "description": "按应用或团队统计，app_id和team都为空时统计所有应用；只统计部署任务(见metrics配置)，Argocd应用的失败以同步失败和健康状态为准；时间单位为秒",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "交付效能指标"
+                ],
+                "summary": "交付效能指标(部署频率、变更前置时间、变更失败率、服务恢复时间)",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "应用",
+                        "name": "app_id",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "团队(配置文件metrics.teams)",
+                        "name": "team",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "开始时间(RFC3339)，默认为30天前",
+                        "name": "since",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "结束时间(RFC3339)，默认为当前时间",
+                        "name": "until",
+                        "in": "query"
+                    },
+                    {
+                        "enum": [
+                            "day",
+                            "week",
+                            "month"
+                        ],
+                        "type": "string",
+                        "description": "时间序列的间隔",
+                        "name": "interval",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/dto.GetDoraMetricsOutput"
+                        }
+                    }
+                }
+            }
+        },
+        "/notify/delivery/{job_id}": {
+            "get": {
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "通知"
+                ],
+                "summary": "任务的通知记录",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "任务 ID",
+                        "name": "job_id",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "type": "string"
+                        }
+                    }
+                }
+            }
+        },
+        "/notify/subscription/create": {
+            "post": {
+                "description": "events: failed(只通知失败), deploy(任务结束), all(所有状态变化)；同一个用户重复订阅时更新",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "通知"
+                ],
+                "summary": "订阅应用的邮件通知",
+                "parameters": [
+                    {
+                        "description": "邮件订阅",
+                        "name": "input",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/dto.CreateNotifySubscriptionInput"
+                        }
+                    },
+                    {
+                        "type": "string",
+                        "description": "订阅的用户",
+                        "name": "user",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "type": "string"
+                        }
+                    }
+                }
+            }
+        },
+        "/notify/subscription/delete/{id}": {
+            "post": {
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "通知"
+                ],
+                "summary": "取消邮件订阅",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "订阅 ID",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "type": "string"
+                        }
+                    }
+                }
+            }
+        },
+        "/notify/subscription/list": {
+            "get": {
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "通知"
+                ],
+                "summary": "邮件订阅列表",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Application ID",
+                        "name": "app_id",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "订阅的用户",
+                        "name": "user",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "type": "string"
+                        }
+                    }
+                }
+            }
+        },
+        "/notify/subscription/test/{id}": {
+            "post": {
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "通知"
+                ],
+                "summary": "发送测试邮件",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "订阅 ID",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "type": "string"
+                        }
+                    }
+                }
+            }
+        },
+        "/notify/target/create": {
+            "post": {
+                "description": "type: webhook(带HMAC签名的http请求), dingtalk, wecom, slack；events为空时只通知结束的状态，*为全部状态",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "通知"
+                ],
+                "summary": "创建通知目标",
+                "parameters": [
+                    {
+                        "description": "通知目标",
+                        "name": "input",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/dto.CreateNotifyTargetInput"
+                        }
+                    },
+                    {
+                        "type": "string",
+                        "description": "操作人",
+                        "name": "user",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/dto.NotifyTargetOutput"
+                        }
+                    }
+                }
+            }
+        },
+        "/notify/target/delete/{id}": {
+            "post": {
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "通知"
+                ],
+                "summary": "删除通知目标",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "通知目标 ID",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "type": "string"
+                        }
+                    }
+                }
+            }
+        },
+        "/notify/target/list": {
+            "get": {
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "通知"
+                ],
+                "summary": "通知目标列表",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Application ID",
+                        "name": "app_id",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "type": "array",
+                            "items": {
+                                "$ref": "#/definitions/dto.NotifyTargetOutput"
+                            }
+                        }
+                    }
+                }
+            }
+        },
+        "/notify/target/test/{id}": {
+            "post": {
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "通知"
+                ],
+                "summary": "发送测试通知",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "通知目标 ID",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "type": "string"
+                        }
+                    }
+                }
+            }
+        },
+        "/schedule/create": {
+            "post": {
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "定时任务管理"
+                ],
+                "summary": "创建定时任务",
+                "parameters": [
+                    {
+                        "description": "定时任务",
+                        "name": "input",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/dto.CreateScheduleInput"
+                        }
+                    },
+                    {
+                        "type": "string",
+                        "description": "操作人",
+                        "name": "user",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "type": "string"
+                        }
+                    }
+                }
+            }
+        },
+        "/schedule/delete/{id}": {
+            "post": {
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "定时任务管理"
+                ],
+                "summary": "删除定时任务",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "定时任务 ID",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "type": "string"
+                        }
+                    }
+                }
+            }
+        },
+        "/schedule/list": {
+            "get": {
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "定时任务管理"
+                ],
+                "summary": "定时任务列表",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Application ID",
+                        "name": "app_id",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "type": "string"
+                        }
+                    }
+                }
+            }
+        },
+        "/schedule/pause/{id}": {
+            "post": {
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "定时任务管理"
+                ],
+                "summary": "暂停定时任务",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "定时任务 ID",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "type": "string"
+                        }
+                    }
+                }
+            }
+        },
+        "/schedule/resume/{id}": {
+            "post": {
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "定时任务管理"
+                ],
+                "summary": "恢复定时任务",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "定时任务 ID",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "type": "string"
+                        }
+                    }
+                }
+            }
+        },
+        "/schedule/{id}": {
+            "get": {
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "定时任务管理"
+                ],
+                "summary": "获取定时任务",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "定时任务 ID",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "type": "string"
+                        }
+                    }
+                }
+            }
+        },
+        "/template/create": {
+            "post": {
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "工作流模板"
+                ],
+                "summary": "创建WorkflowTemplate",
+                "parameters": [
+                    {
+                        "description": "内容",
                         "name": "context",
                         "in": "body",
                         "required": true,
@@ -591,7 +1978,152 @@ var doc = `{
                 }
             }
         },
-        "/template/delete/{id}": {
+        "/template/delete/{id}": {
+            "post": {
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "工作流模板"
+                ],
+                "summary": "删除WorkflowTemplate",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "WorkflowTemplate ID",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "type": "string"
+                        }
+                    }
+                }
+            }
+        },
+        "/template/list": {
+            "get": {
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "工作流模板"
+                ],
+                "summary": "获取WorkflowTemplate列表",
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "type": "string"
+                        }
+                    }
+                }
+            }
+        },
+        "/template/search": {
+            "get": {
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "工作流模板"
+                ],
+                "summary": "搜索WorkflowTemplate",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "关键字",
+                        "name": "keyword",
+                        "in": "query",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "type": "string"
+                        }
+                    }
+                }
+            }
+        },
+        "/template/{id}": {
+            "get": {
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "工作流模板"
+                ],
+                "summary": "获取WorkflowTemplate",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "WorkflowTemplate ID",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "type": "string"
+                        }
+                    }
+                }
+            }
+        },
+        "/webhook/delivery/list": {
+            "get": {
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "Webhook"
+                ],
+                "summary": "webhook接收记录列表",
+                "parameters": [
+                    {
+                        "type": "integer",
+                        "description": "数量",
+                        "name": "size",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "type": "string"
+                        }
+                    }
+                }
+            }
+        },
+        "/webhook/delivery/replay/{id}": {
             "post": {
                 "consumes": [
                     "application/json"
@@ -600,13 +2132,13 @@ var doc = `{
                     "application/json"
                 ],
                 "tags": [
-                    "工作流模板"
+                    "Webhook"
                 ],
-                "summary": "删除WorkflowTemplate",
+                "summary": "重放webhook接收记录(不校验签名)",
                 "parameters": [
                     {
                         "type": "string",
-                        "description": "WorkflowTemplate ID",
+                        "description": "接收记录 ID",
                         "name": "id",
                         "in": "path",
                         "required": true
@@ -622,7 +2154,7 @@ var doc = `{
                 }
             }
         },
-        "/template/list": {
+        "/webhook/delivery/{id}": {
             "get": {
                 "consumes": [
                     "application/json"
@@ -631,9 +2163,18 @@ var doc = `{
                     "application/json"
                 ],
                 "tags": [
-                    "工作流模板"
+                    "Webhook"
+                ],
+                "summary": "获取webhook接收记录(包含请求内容)",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "接收记录 ID",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    }
                 ],
-                "summary": "获取WorkflowTemplate列表",
                 "responses": {
                     "200": {
                         "description": "OK",
@@ -644,8 +2185,8 @@ var doc = `{
                 }
             }
         },
-        "/template/search": {
-            "get": {
+        "/webhook/gitee": {
+            "post": {
                 "consumes": [
                     "application/json"
                 ],
@@ -653,16 +2194,123 @@ var doc = `{
                     "application/json"
                 ],
                 "tags": [
-                    "工作流模板"
+                    "Webhook"
                 ],
-                "summary": "获取WorkflowTemplate列表",
+                "summary": "接收Gitee push/tag事件",
+                "parameters": [
+                    {
+                        "description": "Gitee webhook内容",
+                        "name": "content",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "type": "string"
+                        }
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "type": "string"
+                        }
+                    }
+                }
+            }
+        },
+        "/webhook/github": {
+            "post": {
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "Webhook"
+                ],
+                "summary": "接收GitHub push/tag事件",
+                "parameters": [
+                    {
+                        "description": "GitHub webhook内容",
+                        "name": "content",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "type": "string"
+                        }
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "type": "string"
+                        }
+                    }
+                }
+            }
+        },
+        "/webhook/gitlab": {
+            "post": {
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "Webhook"
+                ],
+                "summary": "接收GitLab push/tag事件",
+                "parameters": [
+                    {
+                        "description": "GitLab webhook内容",
+                        "name": "content",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "type": "string"
+                        }
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "type": "string"
+                        }
+                    }
+                }
+            }
+        },
+        "/webhook/trigger/create": {
+            "post": {
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "Webhook"
+                ],
+                "summary": "创建webhook触发规则",
                 "parameters": [
+                    {
+                        "description": "触发规则",
+                        "name": "input",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/dto.CreateWebhookTriggerInput"
+                        }
+                    },
                     {
                         "type": "string",
-                        "description": "关键字",
-                        "name": "keyword",
-                        "in": "query",
-                        "required": true
+                        "description": "操作人",
+                        "name": "user",
+                        "in": "query"
                     }
                 ],
                 "responses": {
@@ -675,8 +2323,8 @@ var doc = `{
                 }
             }
         },
-        "/template/{id}": {
-            "get": {
+        "/webhook/trigger/delete/{id}": {
+            "post": {
                 "consumes": [
                     "application/json"
                 ],
@@ -684,13 +2332,13 @@ var doc = `{
                     "application/json"
                 ],
                 "tags": [
-                    "工作流模板"
+                    "Webhook"
                 ],
-                "summary": "获取WorkflowTemplate",
+                "summary": "删除webhook触发规则",
                 "parameters": [
                     {
                         "type": "string",
-                        "description": "WorkflowTemplate ID",
+                        "description": "触发规则 ID",
                         "name": "id",
                         "in": "path",
                         "required": true
@@ -705,6 +2353,434 @@ var doc = `{
                     }
                 }
             }
+        },
+        "/webhook/trigger/list": {
+            "get": {
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "Webhook"
+                ],
+                "summary": "webhook触发规则列表",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Application ID",
+                        "name": "app_id",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "type": "string"
+                        }
+                    }
+                }
+            }
+        }
+    },
+    "definitions": {
+        "dao.LogMatch": {
+            "type": "object",
+            "properties": {
+                "after": {
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    }
+                },
+                "app_id": {
+                    "type": "string"
+                },
+                "before": {
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    }
+                },
+                "branch": {
+                    "type": "string"
+                },
+                "commit": {
+                    "type": "string"
+                },
+                "created_by": {
+                    "type": "string"
+                },
+                "job_id": {
+                    "type": "string"
+                },
+                "record": {
+                    "$ref": "#/definitions/dao.LogRecord"
+                },
+                "revision": {
+                    "type": "string"
+                },
+                "trigger": {
+                    "type": "string"
+                }
+            }
+        },
+        "dao.LogRecord": {
+            "type": "object",
+            "properties": {
+                "container": {
+                    "type": "string"
+                },
+                "content": {
+                    "type": "string"
+                },
+                "pod": {
+                    "type": "string"
+                },
+                "seq": {
+                    "type": "integer"
+                },
+                "step": {
+                    "type": "string"
+                },
+                "time": {
+                    "type": "string"
+                }
+            }
+        },
+        "dao.LogSearchResult": {
+            "type": "object",
+            "properties": {
+                "bytes_scanned": {
+                    "type": "integer"
+                },
+                "jobs_scanned": {
+                    "type": "integer"
+                },
+                "matches": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/dao.LogMatch"
+                    }
+                },
+                "next_cursor": {
+                    "description": "继续搜索的游标，为空时已搜索完",
+                    "type": "string"
+                },
+                "truncated": {
+                    "description": "达到匹配数或字节数上限",
+                    "type": "boolean"
+                }
+            }
+        },
+        "dto.ApprovalInput": {
+            "type": "object",
+            "properties": {
+                "comment": {
+                    "type": "string",
+                    "example": "LGTM"
+                },
+                "gate": {
+                    "type": "string",
+                    "example": "approve-production"
+                }
+            }
+        },
+        "dto.CreateJobInput": {
+            "type": "object",
+            "properties": {
+                "parameters": {
+                    "type": "object",
+                    "additionalProperties": {
+                        "type": "string"
+                    },
+                    "example": {
+                        "branch": "master"
+                    }
+                }
+            }
+        },
+        "dto.CreateNotifySubscriptionInput": {
+            "type": "object",
+            "properties": {
+                "app_id": {
+                    "type": "string",
+                    "example": "iot-api-gateway"
+                },
+                "email": {
+                    "type": "string",
+                    "example": "release@example.com"
+                },
+                "events": {
+                    "description": "failed, deploy, all",
+                    "type": "string",
+                    "example": "failed"
+                }
+            }
+        },
+        "dto.CreateNotifyTargetInput": {
+            "type": "object",
+            "properties": {
+                "app_id": {
+                    "type": "string",
+                    "example": "iot-api-gateway"
+                },
+                "events": {
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    },
+                    "example": [
+                        "Succeeded",
+                        "Failed"
+                    ]
+                },
+                "name": {
+                    "type": "string",
+                    "example": "build-alert"
+                },
+                "secret": {
+                    "type": "string"
+                },
+                "type": {
+                    "description": "webhook, dingtalk, wecom, slack",
+                    "type": "string",
+                    "example": "webhook"
+                },
+                "url": {
+                    "type": "string",
+                    "example": "http://127.0.0.1:8080/notify"
+                }
+            }
+        },
+        "dto.CreateScheduleInput": {
+            "type": "object",
+            "properties": {
+                "app_id": {
+                    "type": "string",
+                    "example": "iot-api-gateway"
+                },
+                "cron": {
+                    "type": "string",
+                    "example": "0 2 * * *"
+                },
+                "parameters": {
+                    "type": "object",
+                    "additionalProperties": {
+                        "type": "string"
+                    },
+                    "example": {
+                        "branch": "master"
+                    }
+                }
+            }
+        },
+        "dto.CreateWebhookTriggerInput": {
+            "type": "object",
+            "properties": {
+                "app_id": {
+                    "type": "string",
+                    "example": "iot-api-gateway"
+                },
+                "branch_param": {
+                    "type": "string",
+                    "example": "branch"
+                },
+                "branches": {
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    },
+                    "example": [
+                        "master",
+                        "release-*"
+                    ]
+                },
+                "commit_param": {
+                    "type": "string",
+                    "example": "commit"
+                },
+                "events": {
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    },
+                    "example": [
+                        "push",
+                        "tag"
+                    ]
+                },
+                "parameters": {
+                    "type": "object",
+                    "additionalProperties": {
+                        "type": "string"
+                    }
+                },
+                "repo_url": {
+                    "type": "string",
+                    "example": "https://gitee.com/carter115/argocd-example-apps.git"
+                }
+            }
+        },
+        "dto.DoraMetricsOutput": {
+            "type": "object",
+            "properties": {
+                "change_failure_rate": {
+                    "description": "失败次数/(成功次数+失败次数)",
+                    "type": "number"
+                },
+                "deployment_frequency": {
+                    "description": "平均每天的部署次数",
+                    "type": "number"
+                },
+                "deployments": {
+                    "description": "成功的部署次数",
+                    "type": "integer"
+                },
+                "end": {
+                    "type": "string"
+                },
+                "failures": {
+                    "description": "失败的部署次数",
+                    "type": "integer"
+                },
+                "lead_time": {
+                    "description": "平均变更前置时间",
+                    "type": "integer"
+                },
+                "restores": {
+                    "description": "从失败中恢复的次数",
+                    "type": "integer"
+                },
+                "start": {
+                    "type": "string"
+                },
+                "time_to_restore": {
+                    "description": "平均服务恢复时间",
+                    "type": "integer"
+                }
+            }
+        },
+        "dto.GetDoraMetricsOutput": {
+            "type": "object",
+            "properties": {
+                "app_ids": {
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    }
+                },
+                "interval": {
+                    "type": "string"
+                },
+                "series": {
+                    "description": "每个区间的指标，用于绘制趋势图",
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/dto.DoraMetricsOutput"
+                    }
+                },
+                "summary": {
+                    "description": "整个时间范围的指标",
+                    "$ref": "#/definitions/dto.DoraMetricsOutput"
+                },
+                "team": {
+                    "type": "string"
+                }
+            }
+        },
+        "dto.JobOutput": {
+            "type": "object",
+            "properties": {
+                "app_id": {
+                    "type": "string"
+                },
+                "branch": {
+                    "type": "string"
+                },
+                "commit": {
+                    "type": "string"
+                },
+                "created_by": {
+                    "type": "string"
+                },
+                "end": {
+                    "type": "string"
+                },
+                "id": {
+                    "type": "string"
+                },
+                "parameters": {
+                    "type": "object",
+                    "additionalProperties": {
+                        "type": "string"
+                    }
+                },
+                "revision": {
+                    "type": "string"
+                },
+                "start": {
+                    "type": "string"
+                },
+                "status": {
+                    "type": "string"
+                },
+                "trigger": {
+                    "type": "string"
+                }
+            }
+        },
+        "dto.ListJobPageOutput": {
+            "type": "object",
+            "properties": {
+                "jobs": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/dto.JobOutput"
+                    }
+                },
+                "next_cursor": {
+                    "type": "string"
+                }
+            }
+        },
+        "dto.NotifyTargetOutput": {
+            "type": "object",
+            "properties": {
+                "app_id": {
+                    "type": "string"
+                },
+                "created_at": {
+                    "type": "string"
+                },
+                "created_by": {
+                    "type": "string"
+                },
+                "events": {
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    }
+                },
+                "has_secret": {
+                    "description": "是否设置了签名密钥",
+                    "type": "boolean"
+                },
+                "id": {
+                    "type": "string"
+                },
+                "name": {
+                    "type": "string"
+                },
+                "type": {
+                    "type": "string"
+                },
+                "url": {
+                    "type": "string"
+                }
+            }
         }
     }
 }`
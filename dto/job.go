@@ -1,21 +1,73 @@
 package dto
 
+type CreateJobInput struct {
+	Parameters map[string]string `json:"parameters" example:"branch:master"`
+}
+
 type CreateJobOutput struct {
-	Id string `json:"job_id"`
+	Id     string `json:"job_id"`
+	Status string `json:"status,omitempty"`
 }
 
 type GetJobOutput struct {
-	Id        string      `json:"id"`
-	Cost      string      `json:"cost"`
-	Status    string      `json:"status"`
-	PhaseList interface{} `json:"phase_list"`
+	Id            string            `json:"id"`
+	AppId         string            `json:"app_id"`
+	Cost          string            `json:"cost"`
+	Status        string            `json:"status"`
+	Trigger       string            `json:"trigger,omitempty"`    // 触发方式
+	CreatedBy     string            `json:"created_by,omitempty"` // 调用者
+	Commit        string            `json:"commit,omitempty"`
+	Branch        string            `json:"branch,omitempty"`
+	Revision      string            `json:"revision,omitempty"`      // 应用内容的版本
+	ApprovalGate  string            `json:"approval_gate,omitempty"` // 等待审批的节点
+	Approvals     []ApprovalOutput  `json:"approvals,omitempty"`
+	Parameters    map[string]string `json:"parameters"`
+	OriginJob     string            `json:"origin_job,omitempty"`
+	OriginAction  string            `json:"origin_action,omitempty"`
+	RetryCount    int               `json:"retry_count"`
+	QueuePosition int64             `json:"queue_position,omitempty"`
+	CancelledBy   string            `json:"cancelled_by,omitempty"`
+	CancelledAt   string            `json:"cancelled_at,omitempty"`
+	RunningPhases []string          `json:"running_phases,omitempty"` // 正在运行的步骤
+	FailedPhase   string            `json:"failed_phase,omitempty"`   // 第一个失败的步骤
+	FailedMessage string            `json:"failed_message,omitempty"`
+	Redactions    int               `json:"redactions"`            // 日志中隐藏敏感内容的次数
+	ArchivedAt    string            `json:"archived_at,omitempty"` // 日志归档的时间
+	PhaseList     interface{}       `json:"phase_list"`
 }
 
 type JobOutput struct {
-	Id     string `json:"id"`
-	Start  string `json:"start"`
-	End    string `json:"end"`
-	Status string `json:"status"`
+	Id         string            `json:"id"`
+	AppId      string            `json:"app_id"`
+	Start      string            `json:"start"`
+	End        string            `json:"end"`
+	Status     string            `json:"status"`
+	Trigger    string            `json:"trigger"`
+	CreatedBy  string            `json:"created_by,omitempty"`
+	Commit     string            `json:"commit,omitempty"`
+	Branch     string            `json:"branch,omitempty"`
+	Revision   string            `json:"revision,omitempty"`
+	Parameters map[string]string `json:"parameters"`
 }
 
 type ListJobOutput []JobOutput
+
+// 分页的任务列表，next_cursor为空时没有更多数据
+type ListJobPageOutput struct {
+	Jobs       ListJobOutput `json:"jobs"`
+	NextCursor string        `json:"next_cursor"`
+}
+
+// 审批通过或拒绝，gate为空时审批当前等待的节点，不为空时必须是当前等待的节点
+type ApprovalInput struct {
+	Gate    string `json:"gate" example:"approve-production"`
+	Comment string `json:"comment" example:"LGTM"`
+}
+
+type ApprovalOutput struct {
+	Gate     string `json:"gate"`
+	Action   string `json:"action"`
+	Approver string `json:"approver"`
+	Comment  string `json:"comment"`
+	Time     string `json:"time"`
+}
@@ -0,0 +1,22 @@
+package dto
+
+// 一个时间区间的交付效能指标，时间单位为秒
+type DoraMetricsOutput struct {
+	Start               string  `json:"start"`
+	End                 string  `json:"end"`
+	Deployments         int64   `json:"deployments"`          // 成功的部署次数
+	Failures            int64   `json:"failures"`             // 失败的部署次数
+	DeploymentFrequency float64 `json:"deployment_frequency"` // 平均每天的部署次数
+	LeadTime            int64   `json:"lead_time"`            // 平均变更前置时间
+	ChangeFailureRate   float64 `json:"change_failure_rate"`  // 失败次数/(成功次数+失败次数)
+	TimeToRestore       int64   `json:"time_to_restore"`      // 平均服务恢复时间
+	Restores            int64   `json:"restores"`             // 从失败中恢复的次数
+}
+
+type GetDoraMetricsOutput struct {
+	Team     string              `json:"team,omitempty"`
+	AppIds   []string            `json:"app_ids"`
+	Interval string              `json:"interval"`
+	Summary  DoraMetricsOutput   `json:"summary"` // 整个时间范围的指标
+	Series   []DoraMetricsOutput `json:"series"`  // 每个区间的指标，用于绘制趋势图
+}
@@ -0,0 +1,29 @@
+package dto
+
+type CreateNotifyTargetInput struct {
+	AppId  string   `json:"app_id" example:"iot-api-gateway"`
+	Name   string   `json:"name" example:"build-alert"`
+	Type   string   `json:"type" example:"webhook"` // webhook, dingtalk, wecom, slack
+	Url    string   `json:"url" example:"http://127.0.0.1:8080/notify"`
+	Secret string   `json:"secret"`
+	Events []string `json:"events" example:"Succeeded,Failed"`
+}
+
+// 通知目标的返回，不包含签名密钥，url中的token等参数已隐藏
+type NotifyTargetOutput struct {
+	Id        string   `json:"id"`
+	AppId     string   `json:"app_id"`
+	Name      string   `json:"name"`
+	Type      string   `json:"type"`
+	Url       string   `json:"url"`
+	HasSecret bool     `json:"has_secret"` // 是否设置了签名密钥
+	Events    []string `json:"events"`
+	CreatedBy string   `json:"created_by"`
+	CreatedAt string   `json:"created_at"`
+}
+
+type CreateNotifySubscriptionInput struct {
+	AppId  string `json:"app_id" example:"iot-api-gateway"`
+	Email  string `json:"email" example:"release@example.com"`
+	Events string `json:"events" example:"failed"` // failed, deploy, all
+}
@@ -0,0 +1,23 @@
+package dto
+
+type CreateScheduleInput struct {
+	AppId      string            `json:"app_id" example:"iot-api-gateway"`
+	Cron       string            `json:"cron" example:"0 2 * * *"`
+	Parameters map[string]string `json:"parameters" example:"branch:master"`
+}
+
+type ScheduleOutput struct {
+	Id            string            `json:"id"`
+	AppId         string            `json:"app_id"`
+	Cron          string            `json:"cron"`
+	Parameters    map[string]string `json:"parameters"`
+	Paused        bool              `json:"paused"`
+	CreatedBy     string            `json:"created_by"`
+	CreatedAt     string            `json:"created_at"`
+	LastFire      string            `json:"last_fire"`
+	LastJob       string            `json:"last_job"`
+	LastError     string            `json:"last_error"`
+	NextFireTimes []string          `json:"next_fire_times"`
+}
+
+type ListScheduleOutput []ScheduleOutput
@@ -0,0 +1,11 @@
+package dto
+
+type CreateWebhookTriggerInput struct {
+	AppId       string            `json:"app_id" example:"iot-api-gateway"`
+	RepoUrl     string            `json:"repo_url" example:"https://gitee.com/carter115/argocd-example-apps.git"`
+	Events      []string          `json:"events" example:"push,tag"`
+	Branches    []string          `json:"branches" example:"master,release-*"`
+	Parameters  map[string]string `json:"parameters"`
+	BranchParam string            `json:"branch_param" example:"branch"`
+	CommitParam string            `json:"commit_param" example:"commit"`
+}
@@ -44,6 +44,26 @@ func InitHandler() *gin.Engine {
 	deploymentGroup := engine.Group("/deployment")
 	controller.DeploymentControllerGroupRegistry(deploymentGroup)
 
+	// scheduleGroup
+	scheduleGroup := engine.Group("/schedule")
+	controller.ScheduleControllerGroupRegistry(scheduleGroup)
+
+	// webhookGroup
+	webhookGroup := engine.Group("/webhook")
+	controller.WebhookControllerGroupRegistry(webhookGroup)
+
+	// notifyGroup
+	notifyGroup := engine.Group("/notify")
+	controller.NotifyControllerGroupRegistry(notifyGroup)
+
+	// metricsGroup
+	metricsGroup := engine.Group("/metrics")
+	controller.MetricsControllerGroupRegistry(metricsGroup)
+
+	// adminGroup
+	adminGroup := engine.Group("/admin")
+	controller.AdminControllerGroupRegistry(adminGroup)
+
 	return engine
 }
 
@@ -1,10 +1,13 @@
 package main
 
 import (
+	"context"
 	"lyyops-cicd/config"
 	"lyyops-cicd/dao"
 	"lyyops-cicd/handler"
+	"lyyops-cicd/pkg/common"
 	"lyyops-cicd/pkg/log"
+	"lyyops-cicd/pkg/scheduler"
 	"net/http"
 )
 
@@ -26,13 +29,31 @@ func main() {
 		log.Fatalf("init redis connection error: %+v", err)
 	}
 
+	// 升级后重建任务索引，再恢复未结束任务的监听
+	go func() {
+		if err := dao.EnsureJobIndex(context.Background()); err != nil {
+			log.Errorf("ensure job index error: %+v", err)
+		}
+		if err := dao.ResumeJobs(); err != nil {
+			log.Errorf("resume jobs error: %+v", err)
+		}
+	}()
+
+	// 后台定时任务
+	scheduler.StartReconciler(config.Config.Scheduler.ReconcileInterval)
+	scheduler.StartCleaner(config.Config.Scheduler.Clean)
+	scheduler.StartCronScheduler(config.Config.Scheduler.CronInterval)
+	scheduler.StartQueueDispatcher(config.Config.Queue.DispatchInterval)
+	scheduler.StartLogArchiver(config.Config.Logs.Archive)
+	scheduler.StartDeploymentSync(config.Config.Metrics)
+
 	// http server
 	engine := handler.InitHandler()
 	server := &http.Server{
 		Addr:         config.Config.Server.Address,
 		ReadTimeout:  config.Config.Server.ReadTimeout,
 		WriteTimeout: config.Config.Server.WriteTimeout,
-		Handler:      engine,
+		Handler:      common.WithRawWriter(engine), // 流式返回的路由会清除写超时
 	}
 	// 启动http server
 	log.Info("http server is running")
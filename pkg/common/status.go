@@ -2,6 +2,7 @@ package common
 
 type StatusCode int
 
+// 状态码的值会返回给调用方，新增的状态码只能追加在最后，不能改变已有的值
 const (
 	Success StatusCode = iota
 	InvalidParam
@@ -34,6 +35,41 @@ const (
 	UnmarshalWorkflowFailed
 	UnmarshalWorkflowTemplateFailed
 
+	InvalidParameters
+	CancelJobFailed
+	ResubmitJobFailed
+	RetryJobFailed
+
+	GetScheduleFailed
+	ListScheduleFailed
+	SaveScheduleFailed
+	DeleteScheduleFailed
+
+	WebhookVerifyFailed
+	WebhookHandleFailed
+	GetWebhookTriggerFailed
+	ListWebhookTriggerFailed
+	SaveWebhookTriggerFailed
+	DeleteWebhookTriggerFailed
+	GetWebhookDeliveryFailed
+	ListWebhookDeliveryFailed
+
+	GetNotifyTargetFailed
+	ListNotifyTargetFailed
+	SaveNotifyTargetFailed
+	DeleteNotifyTargetFailed
+	ListNotifyDeliveryFailed
+	SendNotifyFailed
+	GetNotifySubscriptionFailed
+	ListNotifySubscriptionFailed
+	SaveNotifySubscriptionFailed
+	DeleteNotifySubscriptionFailed
+
+	ApproveJobFailed
+	RejectJobFailed
+
+	GetDoraMetricsFailed
+
 	Unknown StatusCode = 9999
 )
 
@@ -53,6 +89,12 @@ var statusCodeMap = map[StatusCode]string{
 	GetJobStatusFailed: "获取任务状态失败",
 	ListJobFailed:      "获取任务列表失败",
 	DeleteJobFailed:    "删除任务失败",
+	InvalidParameters:  "任务参数错误",
+	CancelJobFailed:    "取消任务失败",
+	ResubmitJobFailed:  "重新提交任务失败",
+	RetryJobFailed:     "重试任务失败",
+	ApproveJobFailed:   "审批通过失败",
+	RejectJobFailed:    "审批拒绝失败",
 
 	GetTemplateFailed:    "获取流水线模版失败",
 	ListTemplateFailed:   "获取流水线模版列表失败",
@@ -61,6 +103,34 @@ var statusCodeMap = map[StatusCode]string{
 
 	GetLogsFailed: "获取Pod日志失败",
 
+	GetScheduleFailed:    "获取定时任务失败",
+	ListScheduleFailed:   "获取定时任务列表失败",
+	SaveScheduleFailed:   "保存定时任务失败",
+	DeleteScheduleFailed: "删除定时任务失败",
+
+	WebhookVerifyFailed:        "webhook签名校验失败",
+	WebhookHandleFailed:        "处理webhook失败",
+	GetWebhookTriggerFailed:    "获取webhook触发规则失败",
+	ListWebhookTriggerFailed:   "获取webhook触发规则列表失败",
+	SaveWebhookTriggerFailed:   "保存webhook触发规则失败",
+	DeleteWebhookTriggerFailed: "删除webhook触发规则失败",
+	GetWebhookDeliveryFailed:   "获取webhook接收记录失败",
+	ListWebhookDeliveryFailed:  "获取webhook接收记录列表失败",
+
+	GetNotifyTargetFailed:    "获取通知目标失败",
+	ListNotifyTargetFailed:   "获取通知目标列表失败",
+	SaveNotifyTargetFailed:   "保存通知目标失败",
+	DeleteNotifyTargetFailed: "删除通知目标失败",
+	ListNotifyDeliveryFailed: "获取通知记录失败",
+	SendNotifyFailed:         "发送通知失败",
+
+	GetNotifySubscriptionFailed:    "获取邮件订阅失败",
+	ListNotifySubscriptionFailed:   "获取邮件订阅列表失败",
+	SaveNotifySubscriptionFailed:   "保存邮件订阅失败",
+	DeleteNotifySubscriptionFailed: "删除邮件订阅失败",
+
+	GetDoraMetricsFailed: "获取交付效能指标失败",
+
 	GetArgocdApplicationFailed:       "获取Argocd Application失败",
 	GetArgocdApplicationStatusFailed: "获取Argocd Application Status失败",
 	CreateArgocdApplicationFailed:    "创建Argocd Application失败",
@@ -0,0 +1,37 @@
+package common
+
+import "testing"
+
+// 已发布的状态码不能改变
+func TestStatusCodeValues(t *testing.T) {
+	for code, want := range map[StatusCode]int{
+		Success:                         0,
+		InvalidParam:                    1,
+		ApplicationNotFound:             3,
+		DeleteApplicationFailed:         7,
+		CreateJobFailed:                 8,
+		DeleteJobFailed:                 12,
+		GetTemplateFailed:               13,
+		DeleteTemplateFailed:            16,
+		GetLogsFailed:                   17,
+		GetArgocdApplicationFailed:      18,
+		DeleteArgocdApplicationFailed:   21,
+		UnmarshalWorkflowFailed:         22,
+		UnmarshalWorkflowTemplateFailed: 23,
+		InvalidParameters:               24,
+		GetDoraMetricsFailed:            52,
+		Unknown:                         9999,
+	} {
+		if int(code) != want {
+			t.Errorf("%s: got %d, want %d", code.GetMsg(), code, want)
+		}
+	}
+}
+
+func TestStatusCodeMsg(t *testing.T) {
+	for code := Success; code <= GetDoraMetricsFailed; code++ {
+		if code != Success && code.GetMsg() == statusCodeMap[Unknown] {
+			t.Errorf("status code %d has no message", code)
+		}
+	}
+}
@@ -0,0 +1,31 @@
+package common
+
+import (
+	"context"
+	"net/http"
+	"time"
+)
+
+// 流式返回(SSE、日志跟踪)是长连接，不能受server的WriteTimeout限制
+// gin的ResponseWriter不能取得原始的ResponseWriter，所以在进入gin之前保存到请求的context中
+
+type rawWriterKey struct{}
+
+// 保存原始的ResponseWriter，用于流式返回时清除写超时
+func WithRawWriter(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := context.WithValue(r.Context(), rawWriterKey{}, w)
+		h.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// 清除本次请求的写超时，在写入第一个字节之前调用；Go 1.20以下不支持时返回false
+func ClearWriteDeadline(r *http.Request) bool {
+	w, ok := r.Context().Value(rawWriterKey{}).(interface {
+		SetWriteDeadline(time.Time) error
+	})
+	if !ok {
+		return false
+	}
+	return w.SetWriteDeadline(time.Time{}) == nil
+}
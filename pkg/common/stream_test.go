@@ -0,0 +1,60 @@
+package common
+
+import (
+	"bufio"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+)
+
+// 清除写超时后，超过WriteTimeout的流式返回仍能写入
+func TestClearWriteDeadline(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/stream", func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("clear") != "" && !ClearWriteDeadline(r) {
+			t.Error("ClearWriteDeadline returned false")
+		}
+		w.Write([]byte("first\n"))
+		w.(http.Flusher).Flush()
+		time.Sleep(300 * time.Millisecond)
+		w.Write([]byte("second\n"))
+		w.(http.Flusher).Flush()
+	})
+	server := &http.Server{Handler: WithRawWriter(mux), WriteTimeout: 100 * time.Millisecond}
+	go server.Serve(ln)
+	defer server.Close()
+
+	for _, tt := range []struct {
+		query string
+		lines int
+	}{
+		{"", 1},
+		{"?clear=1", 2},
+	} {
+		resp, err := http.Get("http://" + ln.Addr().String() + "/stream" + tt.query)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var lines int
+		scanner := bufio.NewScanner(resp.Body)
+		for scanner.Scan() {
+			lines++
+		}
+		resp.Body.Close()
+		if lines != tt.lines {
+			t.Errorf("%q: got %d lines, want %d", tt.query, lines, tt.lines)
+		}
+	}
+}
+
+func TestClearWriteDeadlineWithoutWriter(t *testing.T) {
+	r, _ := http.NewRequest(http.MethodGet, "/", nil)
+	if ClearWriteDeadline(r) {
+		t.Error("got true without raw writer")
+	}
+}
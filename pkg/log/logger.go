@@ -80,7 +80,6 @@ func (level Level) String() string {
 	default:
 		return "unkown"
 	}
-	return "unknown"
 }
 
 func getLevelCode(str string) Level {
@@ -0,0 +1,39 @@
+package scheduler
+
+import (
+	"context"
+	"lyyops-cicd/config"
+	"lyyops-cicd/dao"
+	"lyyops-cicd/pkg/log"
+	"time"
+)
+
+// 定时清理过期的日志归档，并重新归档之前失败的任务
+func StartLogArchiver(conf config.Archive) {
+	if !conf.Enabled || conf.Interval <= 0 {
+		log.Info("log archiver is disabled")
+		return
+	}
+	go func() {
+		ticker := time.NewTicker(conf.Interval)
+		defer ticker.Stop()
+		for range ticker.C {
+			ArchiveLogs()
+		}
+	}()
+	log.Infof("log archiver is running: %s, interval: %s", conf.Type, conf.Interval)
+}
+
+// 执行一次日志归档的清理和重试
+func ArchiveLogs() {
+	ctx := context.Background()
+	if err := dao.ArchivePendingJobs(ctx); err != nil {
+		log.Errorf("archive pending jobs: %+v", err)
+	}
+	n, err := dao.PruneLogArchives(ctx)
+	if err != nil {
+		log.Errorf("prune log archives: %+v", err)
+		return
+	}
+	log.Infof("prune log archives: %d deleted", n)
+}
@@ -1 +1,163 @@
 package scheduler
+
+import (
+	"context"
+	"lyyops-cicd/config"
+	"lyyops-cicd/dao"
+	"lyyops-cicd/pkg/log"
+	"sync"
+	"time"
+)
+
+// 清理的任务
+type CleanItem struct {
+	JobId     string    `json:"job_id"`
+	AppId     string    `json:"app_id"`
+	Status    string    `json:"status"`
+	StartTime time.Time `json:"start_time"`
+	Pods      []string  `json:"pods"`
+	Error     string    `json:"error,omitempty"`
+}
+
+// 任务清理的执行结果
+type CleanReport struct {
+	StartTime time.Time   `json:"start_time"`
+	EndTime   time.Time   `json:"end_time"`
+	DryRun    bool        `json:"dry_run"`
+	KeepJobs  int         `json:"keep_jobs"`
+	KeepDays  int         `json:"keep_days"`
+	Checked   int         `json:"checked"`
+	Deleted   []CleanItem `json:"deleted"`
+	Error     string      `json:"error,omitempty"`
+}
+
+var (
+	cleanMu   sync.Mutex
+	lastClean *CleanReport
+)
+
+// 启动时清理一次，之后按配置的间隔定时清理过期任务
+func StartCleaner(conf config.Clean) {
+	if conf.Interval <= 0 {
+		log.Info("job cleaner is disabled")
+		return
+	}
+	go func() {
+		CleanJobs(conf.DryRun)
+		ticker := time.NewTicker(conf.Interval)
+		defer ticker.Stop()
+		for range ticker.C {
+			CleanJobs(conf.DryRun)
+		}
+	}()
+	log.Infof("job cleaner is running: %+v", conf)
+}
+
+// 执行一次任务清理
+// 每个应用保留最近的keepJobs个任务，以及keepDays天内的任务，未结束的任务和还有重试任务的原任务不清理
+// dryRun只返回将要清理的任务，不做删除；多个副本同时清理时只有一个执行
+func CleanJobs(dryRun bool) *CleanReport {
+	cleanMu.Lock()
+	defer cleanMu.Unlock()
+
+	conf := config.Config.Scheduler.Clean
+	report := &CleanReport{
+		StartTime: time.Now(),
+		DryRun:    dryRun,
+		KeepJobs:  conf.KeepJobs,
+		KeepDays:  conf.KeepDays,
+		Deleted:   []CleanItem{},
+	}
+	defer func() {
+		report.EndTime = time.Now()
+		log.Infof("clean jobs: checked %d, deleted %d, dry run: %v", report.Checked, len(report.Deleted), dryRun)
+		if !dryRun {
+			lastClean = report
+		}
+	}()
+
+	if conf.KeepJobs <= 0 && conf.KeepDays <= 0 {
+		report.Error = "retention policy is not configured"
+		return report
+	}
+
+	ctx := context.Background()
+	if !dryRun {
+		unlock, err := dao.LockJobClean(ctx)
+		if err != nil {
+			report.Error = err.Error()
+			log.Warningf("clean jobs: %v", err)
+			return report
+		}
+		defer unlock()
+	}
+	jobs, err := listAllJobs(ctx) // 按开始时间倒序
+	if err != nil {
+		report.Error = err.Error()
+		log.Errorf("clean jobs: %+v", err)
+		return report
+	}
+	report.Checked = len(jobs)
+	if !dryRun {
+		if err := dao.PruneJobIndex(ctx); err != nil {
+			log.Errorf("prune job index: %+v", err)
+		}
+	}
+
+	var (
+		count    = map[string]int{} // 每个应用已保留的任务数
+		deadline = time.Now().AddDate(0, 0, -conf.KeepDays)
+	)
+	for _, job := range jobs {
+		count[job.AppId]++
+		if count[job.AppId] <= conf.KeepJobs || !dao.IsJobFinished(job.Status) {
+			continue
+		}
+		if conf.KeepDays > 0 && job.StartTime.After(deadline) {
+			continue
+		}
+		// 重试任务比原任务新，按时间倒序会先被清理；还有保留的重试任务时不删除原任务
+		if retries, err := job.RetryJobs(); err != nil || len(retries) > 0 {
+			continue
+		}
+
+		item := CleanItem{JobId: job.Id, AppId: job.AppId, Status: job.Status, StartTime: job.StartTime}
+		if item.Pods, err = job.PodNames(); err != nil {
+			item.Error = err.Error()
+		}
+		if !dryRun && item.Error == "" {
+			if err := job.Purge(); err != nil {
+				item.Error = err.Error()
+				log.Errorf("clean job[%s]: %+v", job.Id, err)
+			}
+		}
+		report.Deleted = append(report.Deleted, item)
+	}
+	return report
+}
+
+// 分页读取全部任务
+func listAllJobs(ctx context.Context) ([]*dao.Job, error) {
+	var (
+		all    []*dao.Job
+		cursor string
+	)
+	for {
+		jobs, next, err := dao.QueryJobs(ctx, dao.JobQuery{Cursor: cursor, Size: 500})
+		if err != nil {
+			return nil, err
+		}
+		all = append(all, jobs...)
+		if next == "" {
+			return all, nil
+		}
+		cursor = next
+	}
+}
+
+// 最近一次任务清理的结果(不包括dry run)，没有执行过时返回nil
+func LastClean() *CleanReport {
+	cleanMu.Lock()
+	defer cleanMu.Unlock()
+	return lastClean
+}
@@ -0,0 +1,189 @@
+package scheduler
+
+import (
+	"github.com/pkg/errors"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// 标准的5段cron表达式: 分 时 日 月 周
+// 支持 * , - / 以及 @yearly @monthly @weekly @daily @midnight @hourly
+// 夏令时开始时跳过的时间点不执行；结束时重复的时间点只执行一次，小时为*时两次都执行
+type Cron struct {
+	minute, hour, dom, month, dow uint64
+	domStar, dowStar, hourStar    bool
+}
+
+type cronField struct {
+	min, max int
+}
+
+var (
+	cronFields = []cronField{
+		{0, 59}, // minute
+		{0, 23}, // hour
+		{1, 31}, // day of month
+		{1, 12}, // month
+		{0, 6},  // day of week
+	}
+	cronDescriptors = map[string]string{
+		"@yearly":   "0 0 1 1 *",
+		"@annually": "0 0 1 1 *",
+		"@monthly":  "0 0 1 * *",
+		"@weekly":   "0 0 * * 0",
+		"@daily":    "0 0 * * *",
+		"@midnight": "0 0 * * *",
+		"@hourly":   "0 * * * *",
+	}
+)
+
+func ParseCron(spec string) (*Cron, error) {
+	spec = strings.TrimSpace(spec)
+	if d, ok := cronDescriptors[spec]; ok {
+		spec = d
+	}
+	fields := strings.Fields(spec)
+	if len(fields) != len(cronFields) {
+		return nil, errors.Errorf("cron表达式 %q 需要5段: 分 时 日 月 周", spec)
+	}
+
+	var bits [5]uint64
+	for i, f := range fields {
+		b, err := parseCronField(f, cronFields[i])
+		if err != nil {
+			return nil, errors.Wrapf(err, "cron表达式 %q", spec)
+		}
+		bits[i] = b
+	}
+	// 周日可以写成0或7
+	if bits[4]&(1<<7) != 0 {
+		bits[4] |= 1
+	}
+	return &Cron{
+		minute:   bits[0],
+		hour:     bits[1],
+		dom:      bits[2],
+		month:    bits[3],
+		dow:      bits[4],
+		domStar:  fields[2] == "*" || fields[2] == "?",
+		dowStar:  fields[4] == "*" || fields[4] == "?",
+		hourStar: strings.HasPrefix(fields[1], "*"),
+	}, nil
+}
+
+func parseCronField(field string, r cronField) (uint64, error) {
+	var bits uint64
+	max := r.max
+	if r.max == 6 {
+		max = 7 // 周日可以写成7
+	}
+	for _, part := range strings.Split(field, ",") {
+		var (
+			rangePart = part
+			step      = 1
+			start     = r.min
+			end       = r.max
+			err       error
+		)
+		if i := strings.Index(part, "/"); i >= 0 {
+			rangePart = part[:i]
+			if step, err = strconv.Atoi(part[i+1:]); err != nil || step <= 0 {
+				return 0, errors.Errorf("非法的步长 %q", part)
+			}
+		}
+		switch {
+		case rangePart == "*" || rangePart == "?":
+		case strings.Contains(rangePart, "-"):
+			bounds := strings.SplitN(rangePart, "-", 2)
+			if start, err = strconv.Atoi(bounds[0]); err != nil {
+				return 0, errors.Errorf("非法的范围 %q", part)
+			}
+			if end, err = strconv.Atoi(bounds[1]); err != nil {
+				return 0, errors.Errorf("非法的范围 %q", part)
+			}
+		default:
+			if start, err = strconv.Atoi(rangePart); err != nil {
+				return 0, errors.Errorf("非法的值 %q", part)
+			}
+			end = start
+			if step > 1 {
+				end = r.max // 5/10 表示从5开始每10个
+			}
+		}
+		if start < r.min || end > max || start > end {
+			return 0, errors.Errorf("%q 超出范围 %d-%d", part, r.min, r.max)
+		}
+		for v := start; v <= end; v += step {
+			bits |= 1 << uint(v)
+		}
+	}
+	return bits, nil
+}
+
+// t之后的下一次执行时间(精确到分钟)，5年内没有匹配时返回零值
+func (c *Cron) Next(t time.Time) time.Time {
+	from := wallClock(t)
+	t = t.Truncate(time.Minute).Add(time.Minute)
+	limit := t.AddDate(5, 0, 0)
+	for t.Before(limit) {
+		if c.month&(1<<uint(t.Month())) == 0 {
+			t = forward(t, time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, t.Location()))
+			continue
+		}
+		if !c.matchDay(t) {
+			t = forward(t, time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, t.Location()))
+			continue
+		}
+		if c.hour&(1<<uint(t.Hour())) == 0 {
+			t = forward(t, time.Date(t.Year(), t.Month(), t.Day(), t.Hour()+1, 0, 0, 0, t.Location()))
+			continue
+		}
+		if c.minute&(1<<uint(t.Minute())) == 0 {
+			t = t.Add(time.Minute)
+			continue
+		}
+		if !c.hourStar && !wallClock(t).After(from) {
+			t = t.Add(time.Minute) // 夏令时结束时重复的时间点
+			continue
+		}
+		return t
+	}
+	return time.Time{}
+}
+
+// 跳到next，夏令时开始时不存在的时间点会被调整到之前的时间，此时向后移动一小时
+func forward(t, next time.Time) time.Time {
+	if next.After(t) {
+		return next
+	}
+	return t.Truncate(time.Hour).Add(time.Hour)
+}
+
+// 不带时区的本地时间，用于比较夏令时前后的时间点
+func wallClock(t time.Time) time.Time {
+	return time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), t.Minute(), 0, 0, time.UTC)
+}
+
+// 之后的n次执行时间
+func (c *Cron) NextN(t time.Time, n int) []time.Time {
+	var times []time.Time
+	for i := 0; i < n; i++ {
+		t = c.Next(t)
+		if t.IsZero() {
+			break
+		}
+		times = append(times, t)
+	}
+	return times
+}
+
+// 日和周都有限制时，满足其一即可(与crontab一致)
+func (c *Cron) matchDay(t time.Time) bool {
+	domMatch := c.dom&(1<<uint(t.Day())) != 0
+	dowMatch := c.dow&(1<<uint(t.Weekday())) != 0
+	if c.domStar || c.dowStar {
+		return domMatch && dowMatch
+	}
+	return domMatch || dowMatch
+}
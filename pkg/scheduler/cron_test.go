@@ -0,0 +1,126 @@
+package scheduler
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseCron(t *testing.T) {
+	tests := []struct {
+		spec string
+		ok   bool
+	}{
+		{"* * * * *", true},
+		{"*/15 9-18 * * 1-5", true},
+		{"0 0 1,15 * *", true},
+		{"5/10 * * * *", true},
+		{"0 0 * * 7", true},
+		{"@daily", true},
+		{" @hourly ", true},
+		{"0 0 ? * ?", true},
+		{"* * * *", false},
+		{"* * * * * *", false},
+		{"60 * * * *", false},
+		{"* 24 * * *", false},
+		{"* * 0 * *", false},
+		{"* * * 13 *", false},
+		{"* * * * 8", false},
+		{"*/0 * * * *", false},
+		{"5-1 * * * *", false},
+		{"a * * * *", false},
+		{"@every 5m", false},
+	}
+	for _, tt := range tests {
+		_, err := ParseCron(tt.spec)
+		if (err == nil) != tt.ok {
+			t.Errorf("ParseCron(%q) error = %v, want ok %v", tt.spec, err, tt.ok)
+		}
+	}
+}
+
+func TestCronNext(t *testing.T) {
+	at := func(s string) time.Time {
+		v, err := time.ParseInLocation("2006-01-02 15:04", s, time.UTC)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return v
+	}
+	tests := []struct {
+		spec string
+		from string
+		want []string
+	}{
+		{"*/15 * * * *", "2021-10-18 10:07", []string{"2021-10-18 10:15", "2021-10-18 10:30", "2021-10-18 10:45"}},
+		{"0 9 * * 1-5", "2021-10-22 09:00", []string{"2021-10-25 09:00", "2021-10-26 09:00"}},                     // 周五之后是周一
+		{"5/20 * * * *", "2021-10-18 10:00", []string{"2021-10-18 10:05", "2021-10-18 10:25"}},                    // 从5开始每20分钟
+		{"0 0 * * 7", "2021-10-18 00:00", []string{"2021-10-24 00:00"}},                                           // 7表示周日
+		{"@monthly", "2021-10-18 00:00", []string{"2021-11-01 00:00", "2021-12-01 00:00"}},                        // 跨月
+		{"0 0 29 2 *", "2021-03-01 00:00", []string{"2024-02-29 00:00"}},                                          // 闰年
+		{"0 0 31 * *", "2021-04-01 00:00", []string{"2021-05-31 00:00", "2021-07-31 00:00"}},                      // 跳过没有31日的月份
+		{"0 12 13 * 5", "2021-10-01 00:00", []string{"2021-10-01 12:00", "2021-10-08 12:00", "2021-10-13 12:00"}}, // 日和周满足其一
+		{"0 12 13 * *", "2021-10-01 00:00", []string{"2021-10-13 12:00", "2021-11-13 12:00"}},                     // 周为*时只看日
+		{"0 12 * * 5", "2021-10-09 00:00", []string{"2021-10-15 12:00"}},                                          // 日为*时只看周
+	}
+	for _, tt := range tests {
+		c, err := ParseCron(tt.spec)
+		if err != nil {
+			t.Fatalf("ParseCron(%q): %v", tt.spec, err)
+		}
+		got := c.NextN(at(tt.from), len(tt.want))
+		if len(got) != len(tt.want) {
+			t.Errorf("%q from %s: got %v", tt.spec, tt.from, got)
+			continue
+		}
+		for i := range got {
+			if !got[i].Equal(at(tt.want[i])) {
+				t.Errorf("%q from %s: [%d] = %s, want %s", tt.spec, tt.from, i, got[i].Format("2006-01-02 15:04"), tt.want[i])
+			}
+		}
+	}
+
+	c, _ := ParseCron("0 0 30 2 *")
+	if next := c.Next(at("2021-01-01 00:00")); !next.IsZero() {
+		t.Errorf("2月30日 Next = %s, want zero", next)
+	}
+}
+
+func TestCronNextDST(t *testing.T) {
+	loc, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		t.Skip(err)
+	}
+	fire := func(spec string, from time.Time, n int) []string {
+		c, err := ParseCron(spec)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var out []string
+		for _, v := range c.NextN(from, n) {
+			out = append(out, v.Format("01-02 15:04 MST"))
+		}
+		return out
+	}
+	equal := func(name string, got, want []string) {
+		if len(got) != len(want) {
+			t.Errorf("%s: got %v, want %v", name, got, want)
+			return
+		}
+		for i := range got {
+			if got[i] != want[i] {
+				t.Errorf("%s: got %v, want %v", name, got, want)
+				return
+			}
+		}
+	}
+
+	// 2021-03-14 02:00 跳到 03:00，不存在的02:30不执行
+	spring := time.Date(2021, 3, 13, 12, 0, 0, 0, loc)
+	equal("spring forward", fire("30 2 * * *", spring, 2), []string{"03-15 02:30 EDT", "03-16 02:30 EDT"})
+	equal("spring forward hourly", fire("0 * * * *", time.Date(2021, 3, 14, 0, 30, 0, 0, loc), 3), []string{"03-14 01:00 EST", "03-14 03:00 EDT", "03-14 04:00 EDT"})
+
+	// 2021-11-07 02:00 回到 01:00，重复的01:30只执行一次
+	fall := time.Date(2021, 11, 6, 12, 0, 0, 0, loc)
+	equal("fall back", fire("30 1 * * *", fall, 2), []string{"11-07 01:30 EDT", "11-08 01:30 EST"})
+	equal("fall back hourly", fire("30 * * * *", time.Date(2021, 11, 7, 0, 45, 0, 0, loc), 3), []string{"11-07 01:30 EDT", "11-07 01:30 EST", "11-07 02:30 EST"})
+}
@@ -0,0 +1,32 @@
+package scheduler
+
+import (
+	"context"
+	"lyyops-cicd/config"
+	"lyyops-cicd/dao"
+	"lyyops-cicd/pkg/log"
+	"time"
+)
+
+// 定时同步Argocd的部署历史，统计配置了Argocd Application的应用的部署
+func StartDeploymentSync(conf config.Metrics) {
+	if len(conf.ArgocdApps) == 0 || conf.SyncInterval <= 0 {
+		log.Info("argocd deployment sync is disabled")
+		return
+	}
+	go func() {
+		ticker := time.NewTicker(conf.SyncInterval)
+		defer ticker.Stop()
+		for range ticker.C {
+			SyncDeployments()
+		}
+	}()
+	log.Infof("argocd deployment sync is running: %d apps, interval: %s", len(conf.ArgocdApps), conf.SyncInterval)
+}
+
+// 执行一次Argocd部署历史的同步
+func SyncDeployments() {
+	if err := dao.SyncArgocdDeployments(context.Background()); err != nil {
+		log.Errorf("sync argocd deployments: %+v", err)
+	}
+}
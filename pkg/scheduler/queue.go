@@ -0,0 +1,31 @@
+package scheduler
+
+import (
+	"context"
+	"lyyops-cicd/dao"
+	"lyyops-cicd/pkg/log"
+	"time"
+)
+
+// 定时启动排队中的任务
+// 任务结束时也会触发，定时检查用于服务重启和运行中集合不准确的情况
+func StartQueueDispatcher(interval time.Duration) {
+	if interval <= 0 {
+		log.Info("queue dispatcher is disabled")
+		return
+	}
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for range ticker.C {
+			ctx := context.Background()
+			if err := dao.PruneActiveJobs(ctx); err != nil {
+				log.Errorf("prune active jobs: %+v", err)
+			}
+			if err := dao.DispatchQueue(ctx); err != nil {
+				log.Errorf("dispatch job queue: %+v", err)
+			}
+		}
+	}()
+	log.Infof("queue dispatcher is running, interval: %s", interval)
+}
@@ -0,0 +1,64 @@
+package scheduler
+
+import (
+	"lyyops-cicd/dao"
+	"lyyops-cicd/pkg/log"
+	"sync"
+	"time"
+)
+
+// 任务状态修复的执行结果
+type ReconcileReport struct {
+	StartTime   time.Time           `json:"start_time"`
+	EndTime     time.Time           `json:"end_time"`
+	Checked     int                 `json:"checked"`
+	Corrections []dao.JobCorrection `json:"corrections"`
+	Error       string              `json:"error,omitempty"`
+}
+
+var (
+	reconcileMu   sync.Mutex
+	lastReconcile *ReconcileReport
+)
+
+// 定时对比任务记录和argo workflow的实际状态
+func StartReconciler(interval time.Duration) {
+	if interval <= 0 {
+		log.Info("reconciler is disabled")
+		return
+	}
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for range ticker.C {
+			Reconcile()
+		}
+	}()
+	log.Infof("reconciler is running, interval: %s", interval)
+}
+
+// 执行一次任务状态修复
+func Reconcile() *ReconcileReport {
+	reconcileMu.Lock()
+	defer reconcileMu.Unlock()
+
+	report := &ReconcileReport{StartTime: time.Now()}
+	checked, corrections, err := dao.ReconcileJobs()
+	report.EndTime = time.Now()
+	report.Checked = checked
+	report.Corrections = corrections
+	if err != nil {
+		report.Error = err.Error()
+		log.Errorf("reconcile jobs: %+v", err)
+	}
+	log.Infof("reconcile jobs: checked %d, corrected %d", report.Checked, len(report.Corrections))
+	lastReconcile = report
+	return report
+}
+
+// 最近一次任务状态修复的结果，没有执行过时返回nil
+func LastReconcile() *ReconcileReport {
+	reconcileMu.Lock()
+	defer reconcileMu.Unlock()
+	return lastReconcile
+}
@@ -0,0 +1,105 @@
+package scheduler
+
+import (
+	"context"
+	"lyyops-cicd/dao"
+	"lyyops-cicd/pkg/log"
+	"time"
+)
+
+// 定时检查到期的定时任务，并创建发布任务
+func StartCronScheduler(interval time.Duration) {
+	if interval <= 0 {
+		log.Info("cron scheduler is disabled")
+		return
+	}
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for now := range ticker.C {
+			fireSchedules(now)
+		}
+	}()
+	log.Infof("cron scheduler is running, interval: %s", interval)
+}
+
+func fireSchedules(now time.Time) {
+	ctx := context.Background()
+	schedules, err := dao.ListSchedule(ctx, "")
+	if err != nil {
+		log.Errorf("list schedules: %+v", err)
+		return
+	}
+	for _, s := range schedules {
+		if s.Paused {
+			continue
+		}
+		fireSchedule(s, now)
+	}
+}
+
+// 到期时创建一次发布任务，错过的多次触发只补一次
+func fireSchedule(s *dao.Schedule, now time.Time) {
+	cron, err := ParseCron(s.Cron)
+	if err != nil {
+		log.Errorf("schedule[%s] cron: %v", s.Id, err)
+		return
+	}
+	from := s.LastFire
+	if from.IsZero() {
+		from = s.CreatedAt
+	}
+	fireTime := cron.Next(from)
+	if fireTime.IsZero() || fireTime.After(now) {
+		return
+	}
+	for next := cron.Next(fireTime); !next.IsZero() && !next.After(now); next = cron.Next(fireTime) {
+		fireTime = next
+	}
+	if !s.AcquireFire(fireTime) {
+		return // 其他副本已触发
+	}
+
+	var jobId, errMsg string
+	job, err := dao.NewJobFromApplication(s.Ctx, s.AppId, s.Parameters)
+	if err == nil {
+		job.ScheduleId = s.Id
+		job.Trigger = dao.JobTriggerSchedule
+		job.CreatedBy = s.CreatedBy
+		err = job.Submit("")
+	}
+	if err != nil {
+		errMsg = err.Error()
+		log.Errorf("schedule[%s] create job: %+v", s.Id, err)
+	} else {
+		jobId = job.Id
+		log.Infof("schedule[%s] fired at %s, job: %s", s.Id, fireTime.Format(time.RFC3339), jobId)
+	}
+
+	// 重新读取，避免覆盖触发期间对定时任务的修改
+	latest, err := dao.GetSchedule(s.Ctx, s.Id)
+	if err != nil {
+		log.Warningf("schedule[%s] reload: %v", s.Id, err)
+		return
+	}
+	latest.LastFire = fireTime
+	latest.LastJob = jobId
+	latest.LastError = errMsg
+	if ok, err := latest.Update(); err != nil {
+		log.Errorf("schedule[%s] save: %+v", s.Id, err)
+	} else if !ok {
+		log.Infof("schedule[%s] deleted while firing", s.Id)
+	}
+}
+
+// 定时任务之后的n次触发时间
+func NextFireTimes(s *dao.Schedule, n int) []time.Time {
+	if s.Paused {
+		return []time.Time{}
+	}
+	cron, err := ParseCron(s.Cron)
+	if err != nil {
+		return []time.Time{}
+	}
+	return cron.NextN(time.Now(), n)
+}
@@ -0,0 +1,168 @@
+package webhook
+
+import (
+	"context"
+	"lyyops-cicd/dao"
+	"lyyops-cicd/pkg/log"
+	"net/http"
+	"path"
+	"time"
+)
+
+const (
+	defaultBranchParam = "branch"
+	defaultCommitParam = "commit"
+)
+
+// 不保存到接收记录中的请求头
+var secretHeaders = map[string]bool{
+	"X-Gitlab-Token": true,
+	"X-Gitee-Token":  true,
+}
+
+// 处理webhook请求: 解析事件，按触发规则创建发布任务，并保存接收记录
+func Handle(ctx context.Context, provider string, header http.Header, body []byte, replayOf string) (*dao.WebhookDelivery, error) {
+	d := &dao.WebhookDelivery{
+		Ctx:        ctx,
+		Provider:   provider,
+		Headers:    map[string]string{},
+		Body:       string(body),
+		ReceivedAt: time.Now(),
+		ReplayOf:   replayOf,
+		Jobs:       []string{},
+		Errors:     []string{},
+	}
+	for k := range header {
+		if !secretHeaders[http.CanonicalHeaderKey(k)] {
+			d.Headers[k] = header.Get(k)
+		}
+	}
+
+	event, err := Parse(provider, header, body)
+	if err != nil {
+		d.Errors = append(d.Errors, err.Error())
+		return d, firstErr(err, d.Save())
+	}
+	if event == nil {
+		d.Event = header.Get(EventHeader(provider)) // 不处理的事件只做记录
+		return d, d.Save()
+	}
+	d.Event = event.Event
+	d.Ref = event.Ref
+	d.Commit = event.Commit
+	if event.Deleted {
+		return d, d.Save() // 删除分支/tag不触发任务
+	}
+
+	triggers, err := dao.ListWebhookTrigger(ctx, "")
+	if err != nil {
+		d.Errors = append(d.Errors, err.Error())
+		return d, firstErr(err, d.Save())
+	}
+	for _, t := range triggers {
+		if !Match(t, event) {
+			continue
+		}
+		jobId, err := createJob(ctx, t, event)
+		if err != nil {
+			log.Errorf("webhook trigger[%s] create job: %+v", t.Id, err)
+			d.Errors = append(d.Errors, t.Id+": "+err.Error())
+			continue
+		}
+		log.Infof("webhook trigger[%s] %s %s created job: %s", t.Id, event.Event, event.RefName, jobId)
+		d.Jobs = append(d.Jobs, jobId)
+	}
+	return d, d.Save()
+}
+
+// 触发规则是否匹配仓库地址、事件类型和分支
+func Match(t *dao.WebhookTrigger, event *PushEvent) bool {
+	events := t.Events
+	if len(events) == 0 {
+		events = []string{EventPush}
+	}
+	if !contains(events, event.Event) {
+		return false
+	}
+
+	repoMatched := false
+	for _, u := range event.RepoUrls {
+		if NormalizeRepoUrl(u) == NormalizeRepoUrl(t.RepoUrl) {
+			repoMatched = true
+			break
+		}
+	}
+	if !repoMatched {
+		return false
+	}
+
+	if len(t.Branches) == 0 {
+		return true
+	}
+	for _, pattern := range t.Branches {
+		if ok, _ := path.Match(pattern, event.RefName); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// 创建发布任务，workflow中有分支和commit参数时自动填入
+func createJob(ctx context.Context, t *dao.WebhookTrigger, event *PushEvent) (string, error) {
+	names, err := dao.ApplicationParameterNames(ctx, t.AppId)
+	if err != nil {
+		return "", err
+	}
+	params := map[string]string{}
+	for k, v := range t.Parameters {
+		params[k] = v
+	}
+	branchParam, commitParam := t.BranchParam, t.CommitParam
+	if branchParam == "" {
+		branchParam = defaultBranchParam
+	}
+	if commitParam == "" {
+		commitParam = defaultCommitParam
+	}
+	if names[branchParam] {
+		params[branchParam] = event.RefName
+	}
+	if names[commitParam] {
+		params[commitParam] = event.Commit
+	}
+
+	job, err := dao.NewJobFromApplication(ctx, t.AppId, params)
+	if err != nil {
+		return "", err
+	}
+	job.Trigger = dao.JobTriggerWebhook
+	job.CreatedBy = event.Pusher
+	job.Commit = event.Commit
+	if event.Event == EventTag {
+		job.Tag = event.RefName
+	} else {
+		job.Branch = event.RefName
+	}
+	if err := job.Submit(""); err != nil {
+		return "", err
+	}
+	return job.Id, nil
+}
+
+func contains(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+func firstErr(errs ...error) error {
+	for _, err := range errs {
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,190 @@
+package webhook
+
+// 解析和校验GitHub, GitLab, Gitee的push和tag事件
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"github.com/pkg/errors"
+	"lyyops-cicd/config"
+	"net/http"
+	"strings"
+)
+
+const (
+	ProviderGithub = "github"
+	ProviderGitlab = "gitlab"
+	ProviderGitee  = "gitee"
+
+	EventPush = "push"
+	EventTag  = "tag"
+)
+
+// 各平台push/tag事件的公共信息
+type PushEvent struct {
+	Provider string   `json:"provider"`
+	Event    string   `json:"event"` // push, tag
+	RepoUrls []string `json:"repo_urls"`
+	Ref      string   `json:"ref"`
+	RefName  string   `json:"ref_name"` // 分支名或tag名
+	Commit   string   `json:"commit"`
+	Pusher   string   `json:"pusher"`
+	Deleted  bool     `json:"deleted"` // 删除分支/tag
+}
+
+// 事件头和签名头
+var eventHeaders = map[string]string{
+	ProviderGithub: "X-GitHub-Event",
+	ProviderGitlab: "X-Gitlab-Event",
+	ProviderGitee:  "X-Gitee-Event",
+}
+
+func EventHeader(provider string) string {
+	return eventHeaders[provider]
+}
+
+// 校验请求签名
+// github: X-Hub-Signature-256 = sha256=hex(hmac_sha256(secret, body))
+// gitlab: X-Gitlab-Token = token
+// gitee: X-Gitee-Token = 密码，或者 base64(hmac_sha256(secret, timestamp+"\n"+secret))
+func Verify(provider string, header http.Header, body []byte) error {
+	conf := config.Config.Webhook
+	switch provider {
+	case ProviderGithub:
+		if conf.GithubSecret == "" {
+			return errors.New("webhook.githubSecret 未配置")
+		}
+		mac := hmac.New(sha256.New, []byte(conf.GithubSecret))
+		mac.Write(body)
+		expected := "sha256=" + hex.EncodeToString(mac.Sum(nil))
+		if !hmac.Equal([]byte(expected), []byte(header.Get("X-Hub-Signature-256"))) {
+			return errors.New("github签名校验失败")
+		}
+	case ProviderGitlab:
+		if conf.GitlabToken == "" {
+			return errors.New("webhook.gitlabToken 未配置")
+		}
+		if !hmac.Equal([]byte(conf.GitlabToken), []byte(header.Get("X-Gitlab-Token"))) {
+			return errors.New("gitlab token校验失败")
+		}
+	case ProviderGitee:
+		if conf.GiteeSecret == "" {
+			return errors.New("webhook.giteeSecret 未配置")
+		}
+		token := header.Get("X-Gitee-Token")
+		expected := conf.GiteeSecret
+		if ts := header.Get("X-Gitee-Timestamp"); ts != "" {
+			mac := hmac.New(sha256.New, []byte(conf.GiteeSecret))
+			mac.Write([]byte(ts + "\n" + conf.GiteeSecret))
+			expected = base64.StdEncoding.EncodeToString(mac.Sum(nil))
+		}
+		if !hmac.Equal([]byte(expected), []byte(token)) {
+			return errors.New("gitee签名校验失败")
+		}
+	default:
+		return errors.Errorf("不支持的webhook: %s", provider)
+	}
+	return nil
+}
+
+// 三个平台push事件中用到的字段
+type pushPayload struct {
+	Ref         string `json:"ref"`
+	After       string `json:"after"`
+	CheckoutSha string `json:"checkout_sha"`
+	Deleted     bool   `json:"deleted"`
+	UserName    string `json:"user_username"`
+	Pusher      struct {
+		Name     string `json:"name"`
+		Username string `json:"username"`
+	} `json:"pusher"`
+	Repository struct {
+		CloneUrl   string `json:"clone_url"`
+		SshUrl     string `json:"ssh_url"`
+		HtmlUrl    string `json:"html_url"`
+		GitHttpUrl string `json:"git_http_url"`
+		GitSshUrl  string `json:"git_ssh_url"`
+		Url        string `json:"url"`
+	} `json:"repository"`
+	Project struct {
+		GitHttpUrl string `json:"git_http_url"`
+		GitSshUrl  string `json:"git_ssh_url"`
+		WebUrl     string `json:"web_url"`
+	} `json:"project"`
+}
+
+// 解析push和tag事件，其他事件返回nil
+func Parse(provider string, header http.Header, body []byte) (*PushEvent, error) {
+	eventType := header.Get(EventHeader(provider))
+	switch eventType {
+	case "push", "Push Hook", "Tag Push Hook":
+	default:
+		return nil, nil
+	}
+
+	var p pushPayload
+	if err := json.Unmarshal(body, &p); err != nil {
+		return nil, errors.Wrap(err, "解析webhook内容失败")
+	}
+	event := &PushEvent{
+		Provider: provider,
+		Event:    EventPush,
+		Ref:      p.Ref,
+		Commit:   p.After,
+		Pusher:   firstNotEmpty(p.Pusher.Username, p.Pusher.Name, p.UserName),
+	}
+	if p.CheckoutSha != "" {
+		event.Commit = p.CheckoutSha
+	}
+	switch {
+	case strings.HasPrefix(p.Ref, "refs/tags/"):
+		event.Event = EventTag
+		event.RefName = strings.TrimPrefix(p.Ref, "refs/tags/")
+	case strings.HasPrefix(p.Ref, "refs/heads/"):
+		event.RefName = strings.TrimPrefix(p.Ref, "refs/heads/")
+	default:
+		event.RefName = p.Ref
+	}
+	event.Deleted = p.Deleted || strings.Trim(event.Commit, "0") == ""
+
+	for _, u := range []string{
+		p.Repository.CloneUrl, p.Repository.SshUrl, p.Repository.HtmlUrl,
+		p.Repository.GitHttpUrl, p.Repository.GitSshUrl, p.Repository.Url,
+		p.Project.GitHttpUrl, p.Project.GitSshUrl, p.Project.WebUrl,
+	} {
+		if u != "" {
+			event.RepoUrls = append(event.RepoUrls, u)
+		}
+	}
+	if len(event.RepoUrls) == 0 {
+		return nil, errors.New("webhook内容中没有仓库地址")
+	}
+	return event, nil
+}
+
+// 统一仓库地址格式: 去掉协议、用户名和.git后缀，例如 gitee.com/carter115/argocd-example-apps
+func NormalizeRepoUrl(u string) string {
+	u = strings.ToLower(strings.TrimSpace(u))
+	if i := strings.Index(u, "://"); i >= 0 {
+		u = u[i+3:]
+	} else if i := strings.Index(u, ":"); i >= 0 && !strings.Contains(u[:i], "/") {
+		u = u[:i] + "/" + u[i+1:] // git@host:path
+	}
+	if i := strings.Index(u, "@"); i >= 0 && i < strings.Index(u+"/", "/") {
+		u = u[i+1:]
+	}
+	u = strings.TrimSuffix(strings.TrimSuffix(u, "/"), ".git")
+	return u
+}
+
+func firstNotEmpty(values ...string) string {
+	for _, v := range values {
+		if v != "" {
+			return v
+		}
+	}
+	return ""
+}